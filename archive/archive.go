@@ -0,0 +1,359 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	ErrNoDocument = errors.New("html document not found in archive")
+
+	cssUrlPattern = regexp.MustCompile(`url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)`)
+)
+
+// resource is a single part of the MHTML archive.
+type resource struct {
+	contentType string
+	location    string
+	data        []byte
+}
+
+// SingleFile converts the MHTML archive captured by the browser into a
+// self-contained html document. Stylesheets, images, fonts and frames found in
+// the archive are inlined as data urls, references to resources missing from the
+// archive are left untouched.
+func SingleFile(mhtml []byte) ([]byte, error) {
+	resources, document, err := parseMhtml(mhtml)
+	if err != nil {
+		return nil, fmt.Errorf("single file: %w", err)
+	}
+
+	base, err := url.Parse(document.location)
+	if err != nil {
+		return nil, fmt.Errorf("single file: %w", err)
+	}
+
+	doc, err := html.Parse(bytes.NewReader(document.data))
+	if err != nil {
+		return nil, fmt.Errorf("single file: %w", err)
+	}
+
+	i := inliner{resources: resources, seen: map[string]bool{document.location: true}}
+	i.inlineDocument(doc, base)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, fmt.Errorf("single file: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// parseMhtml parses the parts of the MHTML archive and returns the resources
+// indexed by both their Content-Location and "cid:" Content-ID urls, along with
+// the main html document which is the first html part of the archive.
+func parseMhtml(mhtml []byte) (map[string]*resource, *resource, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(mhtml)))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse mhtml: %w", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse mhtml: %w", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, nil, fmt.Errorf("parse mhtml: unexpected content type %s", mediaType)
+	}
+
+	resources := make(map[string]*resource)
+	var document *resource
+	parts := multipart.NewReader(reader.R, params["boundary"])
+	for {
+		part, err := parts.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse mhtml: %w", err)
+		}
+
+		data, err := decodePart(part)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse mhtml: %w", err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		r := &resource{
+			contentType: contentType,
+			location:    part.Header.Get("Content-Location"),
+			data:        data,
+		}
+		if document == nil && contentType == "text/html" {
+			document = r
+		}
+
+		if r.location != "" {
+			if _, ok := resources[r.location]; !ok {
+				resources[r.location] = r
+			}
+		}
+		if id := strings.Trim(part.Header.Get("Content-ID"), "<>"); id != "" {
+			resources["cid:"+id] = r
+		}
+	}
+
+	if document == nil {
+		return nil, nil, fmt.Errorf("parse mhtml: %w", ErrNoDocument)
+	}
+	return resources, document, nil
+}
+
+func decodePart(part *multipart.Part) ([]byte, error) {
+	var reader io.Reader = part
+	switch strings.ToLower(part.Header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		reader = base64.NewDecoder(base64.StdEncoding, newlineStripper{part})
+	case "quoted-printable":
+		reader = quotedprintable.NewReader(part)
+	}
+
+	return io.ReadAll(reader)
+}
+
+// newlineStripper drops the line breaks of the wrapped base64 content.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
+
+// inliner replaces the references to archived resources with data urls.
+type inliner struct {
+	resources map[string]*resource
+	// seen guards against frames referencing each other
+	seen map[string]bool
+}
+
+func (i *inliner) lookup(base *url.URL, ref string) (*resource, string) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+		return nil, ""
+	}
+	if r, ok := i.resources[ref]; ok {
+		return r, ref
+	}
+	refUrl, err := url.Parse(ref)
+	if err != nil {
+		return nil, ""
+	}
+	location := base.ResolveReference(refUrl)
+	location.Fragment = ""
+	if r, ok := i.resources[location.String()]; ok {
+		return r, location.String()
+	}
+	return nil, ""
+}
+
+func (i *inliner) dataUrl(r *resource) string {
+	return fmt.Sprintf("data:%s;base64,%s", r.contentType, base64.StdEncoding.EncodeToString(r.data))
+}
+
+func (i *inliner) inlineDocument(doc *html.Node, base *url.URL) {
+	if b := findElement(doc, atom.Base); b != nil && attr(b, "href") != "" {
+		if baseUrl, err := base.Parse(attr(b, "href")); err == nil {
+			base = baseUrl
+		}
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			i.inlineElement(n, base)
+		}
+		// Elements may be replaced while walking, keep the next sibling beforehand
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			walk(c)
+			c = next
+		}
+	}
+	walk(doc)
+}
+
+func (i *inliner) inlineElement(n *html.Node, base *url.URL) {
+	switch n.DataAtom {
+	case atom.Link:
+		rels := strings.Fields(strings.ToLower(attr(n, "rel")))
+		r, location := i.lookup(base, attr(n, "href"))
+		if r == nil {
+			return
+		}
+		for _, rel := range rels {
+			if rel == "stylesheet" {
+				i.inlineStylesheet(n, r, location)
+				return
+			}
+		}
+		setAttr(n, "href", i.dataUrl(r))
+	case atom.Style:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				c.Data = i.inlineCss(c.Data, base)
+			}
+		}
+	case atom.Iframe, atom.Frame:
+		r, location := i.lookup(base, attr(n, "src"))
+		if r == nil || i.seen[location] {
+			return
+		}
+		frame, err := i.inlineFrame(r, location)
+		if err != nil {
+			return
+		}
+		setAttr(n, "src", frame)
+	}
+
+	for idx, a := range n.Attr {
+		switch a.Key {
+		case "src", "poster", "background":
+			if n.DataAtom == atom.Iframe || n.DataAtom == atom.Frame {
+				continue
+			}
+			if r, _ := i.lookup(base, a.Val); r != nil {
+				n.Attr[idx].Val = i.dataUrl(r)
+			}
+		case "srcset":
+			n.Attr[idx].Val = i.inlineSrcset(a.Val, base)
+		case "style":
+			n.Attr[idx].Val = i.inlineCss(a.Val, base)
+		}
+	}
+}
+
+// inlineStylesheet turns the stylesheet link n into a style element holding the
+// archived stylesheet content.
+func (i *inliner) inlineStylesheet(n *html.Node, r *resource, location string) {
+	cssBase, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+
+	style := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Style,
+		Data:     "style",
+	}
+	if media := attr(n, "media"); media != "" {
+		style.Attr = append(style.Attr, html.Attribute{Key: "media", Val: media})
+	}
+	style.AppendChild(&html.Node{
+		Type: html.TextNode,
+		Data: i.inlineCss(string(r.data), cssBase),
+	})
+
+	n.Parent.InsertBefore(style, n)
+	n.Parent.RemoveChild(n)
+}
+
+func (i *inliner) inlineFrame(r *resource, location string) (string, error) {
+	frameBase, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	doc, err := html.Parse(bytes.NewReader(r.data))
+	if err != nil {
+		return "", err
+	}
+
+	i.seen[location] = true
+	defer delete(i.seen, location)
+	i.inlineDocument(doc, frameBase)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return "", err
+	}
+	return i.dataUrl(&resource{contentType: "text/html", data: buf.Bytes()}), nil
+}
+
+func (i *inliner) inlineCss(css string, base *url.URL) string {
+	return cssUrlPattern.ReplaceAllStringFunc(css, func(match string) string {
+		sub := cssUrlPattern.FindStringSubmatch(match)
+		r, _ := i.lookup(base, sub[2])
+		if r == nil {
+			return match
+		}
+		return fmt.Sprintf("url(%q)", i.dataUrl(r))
+	})
+}
+
+func (i *inliner) inlineSrcset(srcset string, base *url.URL) string {
+	candidates := strings.Split(srcset, ",")
+	for idx, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		if r, _ := i.lookup(base, fields[0]); r != nil {
+			fields[0] = i.dataUrl(r)
+		}
+		candidates[idx] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
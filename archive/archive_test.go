@@ -0,0 +1,78 @@
+package archive
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// mhtml builds an MHTML archive of the given parts, as captured by the browser.
+func mhtml(parts ...string) []byte {
+	var sb strings.Builder
+	sb.WriteString("From: <Saved by Blink>\r\n")
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: multipart/related;\r\n\ttype=\"text/html\";\r\n\tboundary=\"----boundary\"\r\n\r\n")
+	for _, part := range parts {
+		sb.WriteString("------boundary\r\n")
+		sb.WriteString(part)
+		sb.WriteString("\r\n")
+	}
+	sb.WriteString("------boundary--\r\n")
+	return []byte(sb.String())
+}
+
+func TestSingleFile(t *testing.T) {
+	png := []byte("\x89PNG fake")
+	encodedPng := base64.StdEncoding.EncodeToString(png)
+	archive := mhtml(
+		"Content-Type: text/html\r\n"+
+			"Content-Transfer-Encoding: quoted-printable\r\n"+
+			"Content-Location: https://a.com/page\r\n\r\n"+
+			`<html><head><link rel=3D"stylesheet" href=3D"/a.css"></head><body>`+
+			`<img src=3D"img/logo.png"><img src=3D"https://cdn.com/missing.png">`+
+			`<iframe src=3D"cid:frame@mhtml"></iframe></body></html>`,
+		"Content-Type: text/css\r\n"+
+			"Content-Location: https://a.com/a.css\r\n\r\n"+
+			`body { background: url("img/logo.png"); }`,
+		"Content-Type: image/png\r\n"+
+			"Content-Transfer-Encoding: base64\r\n"+
+			"Content-Location: https://a.com/img/logo.png\r\n\r\n"+
+			encodedPng[:8]+"\r\n"+encodedPng[8:],
+		"Content-Type: text/html\r\n"+
+			"Content-ID: <frame@mhtml>\r\n\r\n"+
+			`<html><body><img src="https://a.com/img/logo.png"></body></html>`,
+	)
+
+	content, err := SingleFile(archive)
+	if err != nil {
+		t.Fatalf("SingleFile() error: %v", err)
+	}
+
+	dataUrl := "data:image/png;base64," + encodedPng
+	for _, want := range []string{
+		`<style>body { background: url("` + dataUrl + `"); }</style>`,
+		`<img src="` + dataUrl + `"/>`,
+		// Resources missing from the archive are left untouched
+		`<img src="https://cdn.com/missing.png"/>`,
+		`<iframe src="data:text/html;base64,`,
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("single file misses %q:\n%s", want, content)
+		}
+	}
+	if strings.Contains(string(content), `rel="stylesheet"`) {
+		t.Errorf("stylesheet link not inlined:\n%s", content)
+	}
+}
+
+func TestSingleFileInvalid(t *testing.T) {
+	if _, err := SingleFile([]byte("<html></html>")); err == nil {
+		t.Error("SingleFile() of a non MHTML content succeeded")
+	}
+
+	archive := mhtml("Content-Type: image/png\r\nContent-Location: https://a.com/a.png\r\n\r\npng")
+	if _, err := SingleFile(archive); !errors.Is(err, ErrNoDocument) {
+		t.Errorf("SingleFile() without document error = %v, want ErrNoDocument", err)
+	}
+}
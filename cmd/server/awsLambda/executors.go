@@ -2,6 +2,7 @@ package awsLambda
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -9,7 +10,9 @@ import (
 	"time"
 
 	"github.com/liuminhaw/wrenderer/cmd/shared"
+	"github.com/liuminhaw/wrenderer/cmd/shared/lambdaApp"
 	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/metadata"
 	"github.com/liuminhaw/wrenderer/wrender"
 )
 
@@ -62,6 +65,9 @@ func deleteUrlRenderCache(url string) error {
 	if err := caching.Delete(); err != nil {
 		return err
 	}
+	if err := lambdaApp.DeleteFormatObjects(caching, wrender.Formats); err != nil {
+		return err
+	}
 	empty, err := caching.IsEmptyPrefix("")
 	if err != nil {
 		return err
@@ -73,13 +79,47 @@ func deleteUrlRenderCache(url string) error {
 	return nil
 }
 
-func renderSitemap(url string, logger *slog.Logger) (string, error) {
-	loader, err := shared.NewConfLoader(shared.S3Service, shared.SqsService)
+// renderSitemap queues the urls of the sitemap selected by filter to render
+// following mode, callback is notified once the job ends.
+func renderSitemap(
+	ctx context.Context,
+	url, mode string,
+	filter internal.SitemapFilter,
+	callback wrender.JobCallback,
+	logger *slog.Logger,
+) (string, error) {
+	opts, err := lambdaApp.SitemapOption()
+	if err != nil {
+		return "", err
+	}
+	opts.Logger = logger
+	entries, err := internal.ParseSitemap(ctx, url, opts)
 	if err != nil {
 		return "", err
 	}
+	entries, err = filter.Apply(entries)
+	if err != nil {
+		return "", err
+	}
+
+	return queueJobEntries(ctx, internal.SitemapCategory, entries, mode, callback, logger)
+}
 
-	entries, err := internal.ParseSitemap(url)
+// queueJobEntries queues the entries of a new job of category to render following
+// mode and returns the key of the job, the urls with an up to date page cache are
+// recorded as skipped. The done entries of a job with callback are counted from
+// the start, the callback is recorded with the number of queued entries once every
+// entry is queued, and the workers notify it when the count reaches that number.
+// The job is notified right away if its entries are done by then.
+func queueJobEntries(
+	ctx context.Context,
+	category string,
+	entries []internal.SitemapEntry,
+	mode string,
+	callback wrender.JobCallback,
+	logger *slog.Logger,
+) (string, error) {
+	loader, err := shared.NewConfLoader(shared.S3Service, shared.SqsService)
 	if err != nil {
 		return "", err
 	}
@@ -92,7 +132,7 @@ func renderSitemap(url string, logger *slog.Logger) (string, error) {
 	// Upload render timestamp to S3
 	jobCache := wrender.NewSqsJobCache(
 		randomKey,
-		internal.SitemapCategory,
+		category,
 		wrender.CachedJobPrefix,
 	)
 	caching := wrender.NewS3Caching(
@@ -107,18 +147,39 @@ func renderSitemap(url string, logger *slog.Logger) (string, error) {
 	)
 
 	now := time.Now().UTC().Format(time.RFC3339)
-	if err := caching.UpdateTo(bytes.NewReader([]byte(now)), timestampFile); err != nil {
+	if err := caching.UpdateTo(bytes.NewReader([]byte(now)), lambdaApp.JobTimestampFile); err != nil {
 		return "", err
 	}
 
+	if callback.Enabled() {
+		if err := lambdaApp.StartJobCallback(ctx, loader, category, randomKey); err != nil {
+			return "", err
+		}
+	}
+
 	queue := shared.Queue{
 		Client: loader.Clients.Sqs,
 		Url:    loader.EnvConf.SqsUrl,
 	}
+	var queued int
 	for _, entry := range entries {
 		logger.Debug(fmt.Sprintf("Entry: %s", entry.Loc))
+		if mode != internal.SitemapModeFull {
+			created, cached, err := lambdaApp.PageCacheCreated(ctx, entry.Loc)
+			if err != nil {
+				return "", err
+			}
+			if !entry.NeedsRender(mode, cached, created) {
+				if err := skipSitemapEntry(caching, entry.Loc); err != nil {
+					return "", err
+				}
+				logger.Debug(fmt.Sprintf("Entry %s skipped", entry.Loc))
+				continue
+			}
+		}
+
 		payload, err := json.Marshal(
-			wrender.SqsJobPayload{TargetUrl: entry.Loc, RandomKey: randomKey},
+			wrender.SqsJobPayload{TargetUrl: entry.Loc, RandomKey: randomKey, Category: category},
 		)
 		if err != nil {
 			return "", err
@@ -137,22 +198,57 @@ func renderSitemap(url string, logger *slog.Logger) (string, error) {
 		if err := caching.UpdateTo(bytes.NewReader(payload), suffixPath); err != nil {
 			return "", err
 		}
+		queued++
+	}
+
+	if callback.Enabled() {
+		if err := lambdaApp.SaveJobCallback(ctx, loader, category, randomKey, callback, queued); err != nil {
+			return "", err
+		}
+		// The job is queued, a failed notification does not fail the request
+		if err := lambdaApp.NotifyJob(ctx, loader, category, randomKey, logger); err != nil {
+			logger.Error(
+				fmt.Sprintf("Failed to notify job: %v", err),
+				slog.String("jobKey", randomKey),
+			)
+		}
 	}
 
 	return randomKey, nil
 }
 
-func checkRenderStatus(key string, logger *slog.Logger) (shared.RenderStatusResp, error) {
+// skipSitemapEntry records the entry of url in the skipped state of the job of
+// caching.
+func skipSitemapEntry(caching wrender.S3Caching, url string) error {
+	result := wrender.JobEntryResult{TargetUrl: url, Status: internal.JobStatusSkipped}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	key, err := internal.Sha256Key([]byte(url))
+	if err != nil {
+		return err
+	}
+
+	suffixPath := fmt.Sprintf("%s/%s", internal.JobStatusSkipped, key)
+	return caching.UpdateTo(bytes.NewReader(data), suffixPath)
+}
+
+func checkRenderStatus(
+	category, key string,
+	page, pageSize int,
+	logger *slog.Logger,
+) (shared.RenderStatusResp, error) {
 	loader, err := shared.NewConfLoader(shared.S3Service)
 	if err != nil {
 		return shared.RenderStatusResp{}, err
 	}
 
-	jobCache := wrender.NewSqsJobCache(key, internal.SitemapCategory, wrender.CachedJobPrefix)
+	jobCache := wrender.NewSqsJobCache(key, category, wrender.CachedJobPrefix)
 	caching := wrender.NewS3Caching(
 		loader.Clients.S3,
 		jobCache.KeyPath(),
-		filepath.Join(jobCache.KeyPath(), timestampFile),
+		filepath.Join(jobCache.KeyPath(), lambdaApp.JobTimestampFile),
 		wrender.S3CachingMeta{
 			Bucket:      loader.EnvConf.S3BucketName,
 			Region:      loader.EnvConf.S3BucketRegion,
@@ -160,37 +256,48 @@ func checkRenderStatus(key string, logger *slog.Logger) (shared.RenderStatusResp
 		},
 	)
 
-	queueEmpty, err := caching.IsEmptyPrefix(internal.JobStatusQueued)
-	if err != nil {
-		return shared.RenderStatusResp{}, err
-	}
-	processEmpty, err := caching.IsEmptyPrefix(internal.JobStatusProcessing)
+	// Read job timestamp record
+	now := time.Now().UTC()
+	timestamp, err := caching.Read()
 	if err != nil {
 		return shared.RenderStatusResp{}, err
 	}
-	failureEmpty, err := caching.IsEmptyPrefix(internal.JobStatusFailed)
+	parsedTime, err := time.Parse(time.RFC3339, string(timestamp))
 	if err != nil {
 		return shared.RenderStatusResp{}, err
 	}
 
-	// Read job timestamp record
-	now := time.Now().UTC()
-	timestamp, err := caching.Read()
+	progress, objects, err := lambdaApp.JobProgress(caching, parsedTime)
 	if err != nil {
 		return shared.RenderStatusResp{}, err
 	}
-	parsedTime, err := time.Parse(time.RFC3339, string(timestamp))
+	results, err := jobResults(caching, objects, page, pageSize)
 	if err != nil {
 		return shared.RenderStatusResp{}, err
 	}
+
 	if now.Sub(parsedTime) > time.Duration(loader.EnvConf.JobExpirationInHours)*time.Hour {
-		return shared.RenderStatusResp{Status: internal.JobStatusTimeout}, nil
+		return shared.RenderStatusResp{
+			Status:   internal.JobStatusTimeout,
+			Progress: &progress,
+			Results:  &results,
+		}, nil
 	}
 
-	if !queueEmpty || !processEmpty {
-		return shared.RenderStatusResp{Status: internal.JobStatusProcessing}, nil
-	} else if !failureEmpty {
-		failureResp := shared.RenderStatusResp{Status: internal.JobStatusFailed, Details: []string{}}
+	if progress.Queued != 0 || progress.Processing != 0 {
+		progress.EstimateEta(now)
+		return shared.RenderStatusResp{
+			Status:   internal.JobStatusProcessing,
+			Progress: &progress,
+			Results:  &results,
+		}, nil
+	} else if progress.Failed != 0 {
+		failureResp := shared.RenderStatusResp{
+			Status:   internal.JobStatusFailed,
+			Details:  []string{},
+			Progress: &progress,
+			Results:  &results,
+		}
 		failureContents, err := caching.List(internal.JobStatusFailed)
 		if err != nil {
 			return shared.RenderStatusResp{}, err
@@ -198,15 +305,95 @@ func checkRenderStatus(key string, logger *slog.Logger) (shared.RenderStatusResp
 		for _, content := range failureContents {
 			logger.Debug(fmt.Sprintf("Failure object key: %s", content.Path))
 
-			var queuePayload wrender.SqsJobPayload
-			if err := json.Unmarshal(content.Content, &queuePayload); err != nil {
+			var entryResult wrender.JobEntryResult
+			if err := json.Unmarshal(content.Content, &entryResult); err != nil {
 				return shared.RenderStatusResp{}, err
 			}
-			failureResp.Details = append(failureResp.Details, queuePayload.TargetUrl)
+			failureResp.Details = append(failureResp.Details, entryResult.TargetUrl)
 		}
 
 		return failureResp, nil
 	}
 
-	return shared.RenderStatusResp{Status: internal.JobStatusCompleted}, nil
+	return shared.RenderStatusResp{
+		Status:   internal.JobStatusCompleted,
+		Progress: &progress,
+		Results:  &results,
+	}, nil
+}
+
+// jobResults reads the entry results of the given page of the job entry objects.
+func jobResults(
+	caching wrender.S3Caching,
+	objects []lambdaApp.JobEntryObject,
+	page, pageSize int,
+) (wrender.JobResults, error) {
+	results := wrender.JobResults{
+		Page:     page,
+		PageSize: pageSize,
+		Total:    len(objects),
+		Entries:  []wrender.JobEntryResult{},
+	}
+
+	start := min((page-1)*pageSize, len(objects))
+	end := min(start+pageSize, len(objects))
+	for _, object := range objects[start:end] {
+		caching.CachedPath = object.Path
+		content, err := caching.Read()
+		if err != nil {
+			return wrender.JobResults{}, err
+		}
+
+		// Queued and processing entries hold the queue payload
+		var result wrender.JobEntryResult
+		if err := json.Unmarshal(content, &result); err != nil {
+			return wrender.JobResults{}, err
+		}
+		result.Status = object.State
+		results.Entries = append(results.Entries, result)
+	}
+
+	return results, nil
+}
+
+func renderMeta(ctx context.Context, url string, logger *slog.Logger) (pageMetaResponse, error) {
+	loader, err := shared.NewConfLoader(shared.S3Service)
+	if err != nil {
+		return pageMetaResponse{}, err
+	}
+
+	rendered, err := lambdaApp.RenderUrl(ctx, url, true, logger)
+	if err != nil {
+		return pageMetaResponse{}, err
+	}
+
+	caching := wrender.NewS3Caching(
+		loader.Clients.S3,
+		"",
+		rendered.Path,
+		wrender.S3CachingMeta{
+			Bucket:      loader.EnvConf.S3BucketName,
+			Region:      loader.EnvConf.S3BucketRegion,
+			ContentType: wrender.HtmlContentType,
+		},
+	)
+	content, err := caching.Read()
+	if err != nil {
+		return pageMetaResponse{}, err
+	}
+
+	pageUrl := url
+	if location := rendered.Redirects.Location(); location != "" {
+		pageUrl = location
+	}
+	meta, err := metadata.Extract(content, pageUrl)
+	if err != nil {
+		return pageMetaResponse{}, err
+	}
+
+	return pageMetaResponse{
+		Url:        pageUrl,
+		StatusCode: rendered.StatusCode,
+		PageMeta:   meta,
+	}, nil
 }
@@ -1,30 +1,37 @@
 package awsLambda
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/liuminhaw/wrenderer/cmd/shared"
 	"github.com/liuminhaw/wrenderer/cmd/shared/lambdaApp"
 	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/metadata"
+	"github.com/liuminhaw/wrenderer/renderer"
 	"github.com/liuminhaw/wrenderer/wrender"
 )
 
-const (
-	JobKeyLength  = 6
-	timestampFile = "timestamp"
-)
+const JobKeyLength = 6
 
 type handler struct {
+	// ctx of the Lambda invocation, done when the invocation times out
+	ctx    context.Context
 	logger *slog.Logger
 }
 
 type renderResponse struct {
-	Path string `json:"path"`
+	Path       string                 `json:"path"`
+	StatusCode int                    `json:"statusCode"`
+	Location   string                 `json:"location,omitempty"`
+	Redirects  renderer.RedirectChain `json:"redirects,omitempty"`
 }
 
 func (h *handler) getRenderHandleFunc(
@@ -49,12 +56,79 @@ func (h *handler) getRenderHandleFunc(
 		)
 	}
 
-	cachePath, err := lambdaApp.RenderUrl(urlParam, true, h.logger)
+	format := event.QueryStringParameters["format"]
+	if !wrender.SupportedFormat(format) {
+		h.logger.Info("Unsupported format parameter", slog.String("format", format))
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: fmt.Sprintf("Unsupported format: %s", format)},
+		)
+	}
+
+	rendered, err := lambdaApp.RenderUrlWithFormat(h.ctx, urlParam, format, h.logger)
+	if err != nil {
+		return h.serverError(event, err, nil)
+	}
+
+	resp := renderResponse{
+		Path:       rendered.Path,
+		StatusCode: rendered.StatusCode,
+		Redirects:  rendered.Redirects,
+	}
+	if len(rendered.Redirects) > 0 && lambdaApp.RedirectMode() == wrender.RedirectModeRespond {
+		resp.StatusCode = rendered.Redirects.StatusCode()
+		resp.Location = rendered.Redirects.Location()
+	}
+
+	responseBody, err := json.Marshal(resp)
+	if err != nil {
+		return h.serverError(event, err, nil)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(responseBody),
+	}, nil
+}
+
+type pageMetaResponse struct {
+	Url        string `json:"url"`
+	StatusCode int    `json:"statusCode"`
+	*metadata.PageMeta
+}
+
+func (h *handler) getRenderMetaHandleFunc(
+	event events.APIGatewayProxyRequest,
+) (events.APIGatewayProxyResponse, error) {
+	urlParam := event.QueryStringParameters["url"]
+	h.logger.Info(fmt.Sprintf("Render metadata url: %s", urlParam))
+	if urlParam == "" {
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Missing url parameter"},
+		)
+	}
+
+	if !internal.ValidUrl(urlParam) {
+		h.logger.Info("Invalid url parameter", slog.String("url", urlParam))
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Invalid url parameter"},
+		)
+	}
+
+	meta, err := renderMeta(h.ctx, urlParam, h.logger)
 	if err != nil {
 		return h.serverError(event, err, nil)
 	}
 
-	responseBody, err := json.Marshal(renderResponse{Path: cachePath})
+	responseBody, err := json.Marshal(meta)
 	if err != nil {
 		return h.serverError(event, err, nil)
 	}
@@ -138,7 +212,46 @@ func (h *handler) putRenderSitemapHandleFunc(
 		)
 	}
 
-	location, err := renderSitemap(payload.SitemapUrl, h.logger)
+	mode := payload.Mode
+	if mode == "" {
+		mode = internal.SitemapModeFull
+	}
+	if !internal.SupportedSitemapMode(mode) {
+		h.logger.Info("Invalid sitemap mode", slog.String("mode", payload.Mode))
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Invalid mode"},
+		)
+	}
+
+	var ferr *internal.SitemapFilterError
+	if err := payload.SitemapFilter.Validate(); errors.As(err, &ferr) {
+		h.logger.Info("Invalid sitemap filter", slog.String("error", err.Error()))
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: fmt.Sprintf("Invalid %s", ferr.Param)},
+		)
+	}
+
+	if err := payload.JobCallback().Validate(); err != nil {
+		h.logger.Info("Invalid callback url", slog.String("callback url", payload.CallbackUrl))
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Invalid callbackUrl"},
+		)
+	}
+
+	location, err := renderSitemap(
+		h.ctx,
+		payload.SitemapUrl,
+		mode,
+		payload.SitemapFilter,
+		payload.JobCallback(),
+		h.logger,
+	)
 	if err != nil {
 		return h.serverError(event, err, nil)
 	}
@@ -156,23 +269,141 @@ func (h *handler) putRenderSitemapHandleFunc(
 	}, nil
 }
 
+func (h *handler) putRenderBatchHandleFunc(
+	event events.APIGatewayProxyRequest,
+) (events.APIGatewayProxyResponse, error) {
+	body := []byte(event.Body)
+	if event.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return h.clientError(event, http.StatusBadRequest, nil)
+		}
+		body = decoded
+	}
+
+	contentType := event.Headers["Content-Type"]
+	if contentType == "" {
+		contentType = event.Headers["content-type"]
+	}
+	query := url.Values{}
+	for key, value := range event.QueryStringParameters {
+		query.Set(key, value)
+	}
+	payload, err := shared.ParseBatchPayload(contentType, body, query)
+	if err != nil {
+		h.logger.Info("Invalid batch request", slog.String("error", err.Error()))
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Invalid request body"},
+		)
+	}
+	if len(payload.Urls) == 0 {
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Missing urls"},
+		)
+	}
+
+	opts, err := lambdaApp.SitemapOption()
+	if err != nil {
+		return h.serverError(event, err, nil)
+	}
+	entries, err := internal.UrlListEntries(payload.Urls)
+	if err != nil || (opts.MaxUrls > 0 && len(payload.Urls) > opts.MaxUrls) {
+		h.logger.Info("Invalid batch urls", slog.Int("count", len(payload.Urls)))
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Invalid urls"},
+		)
+	}
+
+	mode := payload.Mode
+	if mode == "" {
+		mode = internal.SitemapModeFull
+	}
+	if !internal.SupportedSitemapMode(mode) {
+		h.logger.Info("Invalid batch mode", slog.String("mode", payload.Mode))
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Invalid mode"},
+		)
+	}
+
+	if err := payload.JobCallback().Validate(); err != nil {
+		h.logger.Info("Invalid callback url", slog.String("callback url", payload.CallbackUrl))
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Invalid callbackUrl"},
+		)
+	}
+
+	location, err := queueJobEntries(
+		h.ctx,
+		internal.BatchCategory,
+		entries,
+		mode,
+		payload.JobCallback(),
+		h.logger,
+	)
+	if err != nil {
+		return h.serverError(event, err, nil)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusAccepted,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Location":     fmt.Sprintf("/render/batch/%s/status", location),
+		},
+		Body: fmt.Sprintf(
+			"{\"message\": \"Batch rendering accepted\", \"location\": \"/render/batch/%s/status\"}",
+			location,
+		),
+	}, nil
+}
+
 type jobStatusResponse struct {
 	Status  string   `json:"status"`
 	Details []string `json:"details,omitempty"`
 }
 
-func (h *handler) getRenderSitemapStatusHandleFunc(
+// getRenderJobStatusHandleFunc reports the status of the job of the id path
+// parameter in category.
+func (h *handler) getRenderJobStatusHandleFunc(
 	event events.APIGatewayProxyRequest,
+	category string,
 ) (events.APIGatewayProxyResponse, error) {
 	jobId := event.PathParameters["id"]
-	h.logger.Debug("Check job status", slog.String("jobId", jobId))
+	h.logger.Debug(
+		"Check job status",
+		slog.String("category", category),
+		slog.String("jobId", jobId),
+	)
+
+	page, pageSize, err := shared.ParsePage(
+		event.QueryStringParameters["page"],
+		event.QueryStringParameters["pageSize"],
+	)
+	if err != nil {
+		return h.clientError(
+			event,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: err.Error()},
+		)
+	}
 
-	statusResp, err := checkRenderStatus(jobId, h.logger)
+	statusResp, err := checkRenderStatus(category, jobId, page, pageSize, h.logger)
 	if err != nil {
 		var werr *wrender.CacheNotFoundError
 		if errors.As(err, &werr) {
 			h.logger.Info(
-				"Status of sitemap job not found",
+				"Status of job not found",
+				slog.String("category", category),
 				slog.String("job id", jobId),
 				slog.String("error", err.Error()),
 			)
@@ -1,6 +1,7 @@
 package awsLambda
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -9,8 +10,11 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 )
 
-func Routes(event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	handler := handler{}
+func Routes(
+	ctx context.Context,
+	event events.APIGatewayProxyRequest,
+) (events.APIGatewayProxyResponse, error) {
+	handler := handler{ctx: ctx}
 
 	debugMode, exists := os.LookupEnv("WRENDERER_DEBUG_MODE")
 	if !exists {
@@ -28,7 +32,7 @@ func Routes(event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse
 	handler.logger.Info(fmt.Sprintf("HTTP method: %s", event.HTTPMethod))
 
 	jobStatusPattern := regexp.MustCompile(
-		fmt.Sprintf("^/render/sitemap/[a-zA-Z]{6}-[a-zA-Z]{6}/status$"),
+		"^/render/(sitemap|batch)/[a-zA-Z]{6}-[a-zA-Z]{6}/status$",
 	)
 	switch {
 	case "/render" == event.Path:
@@ -48,6 +52,20 @@ func Routes(event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse
 				Body: "Method Not Allowed",
 			}, nil
 		}
+	case "/render/meta" == event.Path:
+		switch event.HTTPMethod {
+		case "GET":
+			handler.logger.Debug("request for rendered page metadata")
+			return handler.getRenderMetaHandleFunc(event)
+		default:
+			return events.APIGatewayProxyResponse{
+				StatusCode: 405,
+				Headers: map[string]string{
+					"Content-Type": "text/plain",
+				},
+				Body: "Method Not Allowed",
+			}, nil
+		}
 	case "/render/sitemap" == event.Path:
 		switch event.HTTPMethod {
 		case "PUT":
@@ -62,14 +80,31 @@ func Routes(event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse
 				Body: "Method Not Allowed",
 			}, nil
 		}
+	case "/render/batch" == event.Path:
+		switch event.HTTPMethod {
+		case "PUT":
+			handler.logger.Debug("request for rendering batch")
+			return handler.putRenderBatchHandleFunc(event)
+		default:
+			return events.APIGatewayProxyResponse{
+				StatusCode: 405,
+				Headers: map[string]string{
+					"Content-Type": "text/plain",
+				},
+				Body: "Method Not Allowed",
+			}, nil
+		}
 	case jobStatusPattern.MatchString(event.Path):
 		switch event.HTTPMethod {
 		case "GET":
+			// The route name is the job category
+			category := jobStatusPattern.FindStringSubmatch(event.Path)[1]
 			handler.logger.Debug(
 				"request for checking job status",
+				slog.String("category", category),
 				slog.String("id", event.PathParameters["id"]),
 			)
-			return handler.getRenderSitemapStatusHandleFunc(event)
+			return handler.getRenderJobStatusHandleFunc(event, category)
 		default:
 			return events.APIGatewayProxyResponse{
 				StatusCode: 405,
@@ -1,12 +1,17 @@
 package upAndRun
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/liuminhaw/wrenderer/cmd/shared/localEnv"
@@ -14,6 +19,10 @@ import (
 	"github.com/spf13/pflag"
 )
 
+// workersStopGrace is the time given to the render workers to return once the
+// requests and jobs are stopped.
+const workersStopGrace = 5 * time.Second
+
 func Start() error {
 	pflag.String("app.addr", ":8080", "Server address")
 	pflag.Bool("debug", false, "Enable debug mode")
@@ -52,7 +61,13 @@ func Start() error {
 	}
 	defer db.Close()
 
-	renderQueue := make(chan upAndRunWorker.RenderJob, vConfig.GetInt("queue.capacity"))
+	// Create the browser pool shared by render workers and sitemap jobs
+	pool := upAndRunWorker.NewRendererPool(vConfig, logger)
+	defer pool.Close()
+
+	renders := upAndRunWorker.NewRenderGroup()
+	jobs := upAndRunWorker.NewJobRegistry()
+	scheduler := upAndRunWorker.NewRenderScheduler(vConfig)
 	semaphoreChan := make(chan struct{}, vConfig.GetInt("semaphore.capacity"))
 	errChan := make(chan error, vConfig.GetInt("semaphore.capacity"))
 
@@ -61,20 +76,42 @@ func Start() error {
 		logger:           logger,
 		addr:             vConfig.GetString("app.addr"),
 		db:               db,
-		renderQueue:      renderQueue,
+		scheduler:        scheduler,
 		sitemapSemaphore: semaphoreChan,
 		errorChan:        errChan,
+		pool:             pool,
+		renders:          renders,
+		jobs:             jobs,
 	}
 
 	workerHandler := upAndRunWorker.Handler{
-		Logger:      app.logger,
-		DB:          db,
-		RenderQueue: renderQueue,
-		Semaphore:   semaphoreChan,
-		ErrorChan:   errChan,
+		Logger:    app.logger,
+		DB:        db,
+		Pool:      pool,
+		Renders:   renders,
+		Scheduler: scheduler,
+		Jobs:      jobs,
+		Semaphore: semaphoreChan,
+		ErrorChan: errChan,
+	}
+	// Background routines stopping on shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if err := workerHandler.StartWorkers(bgCtx, vConfig); err != nil {
+		logger.Error(fmt.Sprintf("Error starting workers: %s", err))
+		return err
+	}
+	go workerHandler.StartCacheCleaner(bgCtx, vConfig.GetInt("cache.cleanupIntervalInMinutes"))
+	go workerHandler.ResumeJobs(bgCtx, vConfig)
+
+	// Scheduled sitemap warmups
+	warmups, err := upAndRunWorker.NewWarmups(&workerHandler, vConfig)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error loading warmups: %s", err))
+		return err
 	}
-	workerHandler.StartWorkers(vConfig)
-	go workerHandler.StartCacheCleaner(vConfig.GetInt("cache.cleanupIntervalInMinutes"))
+	app.warmups = warmups
+	go warmups.Run(bgCtx)
 
 	srv := &http.Server{
 		Addr:     app.addr,
@@ -87,21 +124,80 @@ func Start() error {
 		slog.String("address", app.addr),
 		slog.Bool("tls", vConfig.GetBool("app.tls")),
 	)
-	if vConfig.GetBool("app.tls") {
-		srv.TLSConfig = &tls.Config{
-			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	serverErr := make(chan error, 1)
+	go func() {
+		if vConfig.GetBool("app.tls") {
+			srv.TLSConfig = &tls.Config{
+				CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+			}
+			serverErr <- srv.ListenAndServeTLS(
+				vConfig.GetString("app.tlsCert"),
+				vConfig.GetString("app.tlsKey"),
+			)
+		} else {
+			serverErr <- srv.ListenAndServe()
 		}
-		err = srv.ListenAndServeTLS(
-			vConfig.GetString("app.tlsCert"),
-			vConfig.GetString("app.tlsKey"),
-		)
-	} else {
-		err = srv.ListenAndServe()
-	}
+	}()
 
-	if err != nil {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serverErr:
 		logger.Error(fmt.Sprintf("Error starting server: %s", err))
 		return err
+	case sig := <-quit:
+		logger.Info("shutting down server", slog.String("signal", sig.String()))
 	}
+
+	timeout := vConfig.GetDuration("app.shutdownTimeoutInSeconds") * time.Second
+	shutdown(logger, srv, &workerHandler, jobs, timeout)
+	stopBackground()
+
+	logger.Info("server stopped")
 	return nil
 }
+
+// shutdown stops the server gracefully within timeout: no new request is
+// accepted, the requests in progress and the sitemap jobs are waited for, and
+// the render workers finish their current render. Sitemap jobs still running at
+// the deadline are interrupted, requests still running are closed.
+func shutdown(
+	logger *slog.Logger,
+	srv *http.Server,
+	workerHandler *upAndRunWorker.Handler,
+	jobs *upAndRunWorker.JobRegistry,
+	timeout time.Duration,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Info("Closing remaining requests", slog.String("error", err.Error()))
+			srv.Close()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		running := jobs.Running()
+		if err := jobs.Stop(ctx); err != nil {
+			logger.Info(
+				"Sitemap jobs interrupted",
+				slog.Int("running", running),
+				slog.String("error", err.Error()),
+			)
+		}
+	}()
+	wg.Wait()
+
+	// Renders of closed requests and interrupted jobs are abandoned, the workers
+	// are given a short grace period past the deadline to return.
+	workersCtx, workersCancel := context.WithTimeout(context.Background(), workersStopGrace)
+	defer workersCancel()
+	if err := workerHandler.StopWorkers(workersCtx); err != nil {
+		logger.Info("Render workers not stopped", slog.String("error", err.Error()))
+	}
+}
@@ -7,133 +7,84 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
-	"time"
 
 	"github.com/liuminhaw/wrenderer/cmd/shared"
 	"github.com/liuminhaw/wrenderer/cmd/worker/upAndRunWorker"
 	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/metadata"
 	"github.com/liuminhaw/wrenderer/wrender"
 	"github.com/spf13/viper"
 )
 
-const (
-	statusKeyLength = 6
-)
-
 func (app *application) pageRenderWithConfig(config *viper.Viper) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Get query parameters
-		url := r.URL.Query().Get("url")
-		app.logger.Info(
-			fmt.Sprintf("url: %s", url),
-			slog.String("request", r.URL.String()),
-			slog.String("method", r.Method),
-		)
-		if url == "" {
-			app.clientError(
-				w,
-				http.StatusBadRequest,
-				&shared.RespErrorMessage{Message: "One of url or domain parameter is required"},
-			)
+		url, ok := app.urlParam(w, r)
+		if !ok {
 			return
 		}
 
-		if !internal.ValidUrl(url) {
-			app.logger.Info(
-				"Invalid url",
-				slog.String("url", url),
-				slog.String("request", r.URL.String()),
-			)
+		format := r.URL.Query().Get("format")
+		if !wrender.SupportedFormat(format) {
 			app.clientError(
 				w,
 				http.StatusBadRequest,
-				&shared.RespErrorMessage{Message: fmt.Sprintf("Invalid url: %s", url)},
+				&shared.RespErrorMessage{Message: fmt.Sprintf("Unsupported format: %s", format)},
 			)
 			return
 		}
 
-		caching, err := wrender.NewBoltCaching(
-			app.db,
-			url,
-			wrender.CachedPagePrefix,
-			false,
-		)
+		page, err := app.formattedPage(r.Context(), config, url, format)
 		if err != nil {
-			app.serverError(w, r, err)
+			app.renderError(w, r, config, err)
 			return
 		}
 
-		app.logger.Debug("Checking cache", slog.String("url", url))
-		var exists, expired bool
-		var cached wrender.PageCached
-		cachedData, err := caching.Read()
-		if err != nil { // cache not exists
-			var werr *wrender.CacheNotFoundError
-			if !errors.As(err, &werr) {
-				app.serverError(w, r, err)
-				return
-			}
-		} else { // cache exists
-			exists = true
-			if err := json.Unmarshal([]byte(cachedData), &cached); err != nil {
-				app.serverError(w, r, err)
-				return
-			}
-			expired = cached.IsExpired()
-		}
-		app.logger.Debug("Checking cache done", slog.String("url", url))
+		app.writeRenderedPage(w, r, config, format, page.StatusCode, page.Redirects, page.Content)
+	}
+}
 
-		if exists && !expired && config.GetBool("cache.enabled") {
-			app.logger.Debug(
-				"Cache exists and not expired",
-				slog.String("RootBucket", caching.RootBucket),
-				slog.String("HostBucket", caching.HostBucket),
-				slog.String("CachedKey", caching.CachedKey),
-			)
+type pageMetaResponse struct {
+	Url        string `json:"url"`
+	StatusCode int    `json:"statusCode"`
+	*metadata.PageMeta
+}
 
-			decompressContent, err := internal.Decompress(cached.Content)
-			if err != nil {
-				app.serverError(w, r, err)
-				return
-			}
-			w.Write(decompressContent)
+func (app *application) pageMetaWithConfig(config *viper.Viper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		url, ok := app.urlParam(w, r)
+		if !ok {
 			return
-		} else {
-			app.logger.Debug(
-				"Cache expired or not exists",
-				slog.String("RootBucket", caching.RootBucket),
-				slog.String("HostBucket", caching.HostBucket),
-				slog.String("CachedKey", caching.CachedKey),
-			)
+		}
 
-			// Add render job to queue
-			var result upAndRunWorker.RenderJobResult
-			job := upAndRunWorker.RenderJob{Url: url, Result: make(chan upAndRunWorker.RenderJobResult, 1)}
-			select {
-			case app.renderQueue <- job:
-				// Wait for the job to be processed
-				app.logger.Info("Job added to queue", slog.String("url", url))
-				result = <-job.Result
-				if result.Err != nil {
-					app.serverError(w, r, result.Err)
-					return
-				}
-			default:
-				app.clientError(w, http.StatusTooManyRequests, nil)
-				return
-			}
+		page, err := app.renderedPage(r.Context(), config, url)
+		if err != nil {
+			app.renderError(w, r, config, err)
+			return
+		}
 
-			// Save the rendered page to cache
-			pageCache := wrender.NewPageCached(url, nil, config.GetDuration("cache.durationInMinutes")*time.Minute)
-			if err := pageCache.Update(caching, result.Content, false); err != nil {
-				app.serverError(w, r, err)
-				return
-			}
+		pageUrl := url
+		if location := page.Redirects.Location(); location != "" {
+			pageUrl = location
+		}
+		meta, err := metadata.Extract(page.Content, pageUrl)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
 
-			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusOK)
-			w.Write(result.Content)
+		responseBody, err := json.Marshal(pageMetaResponse{
+			Url:        pageUrl,
+			StatusCode: page.StatusCode,
+			PageMeta:   meta,
+		})
+		if err != nil {
+			app.serverError(w, r, err)
+			return
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(responseBody)
 	}
 }
 
@@ -180,6 +131,13 @@ func (app *application) deleteRenderedCache(w http.ResponseWriter, r *http.Reque
 		app.serverError(w, r, err)
 		return
 	}
+	// Remove the other format caches of the url along with the html cache
+	if !targetBucket {
+		if err := upAndRunWorker.DeleteFormatCaches(caching, wrender.Formats); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -209,116 +167,320 @@ func (app *application) renderSitemapWithConfig(config *viper.Viper) http.Handle
 			return
 		}
 
-		if !internal.ValidUrl(payload.SitemapUrl) {
-			app.logger.Info("Invalid sitemap url", slog.String("sitemap url", payload.SitemapUrl))
+		job := upAndRunWorker.SitemapJob{
+			SitemapUrl:  payload.SitemapUrl,
+			Priority:    payload.Priority,
+			Concurrency: payload.Concurrency,
+			Mode:        payload.Mode,
+
+			SitemapFilter: payload.SitemapFilter,
+			JobCallback:   payload.JobCallback(),
+		}
+		app.submitSitemapJob(w, r, config, job, "sitemap", "Sitemap rendering accepted")
+	}
+}
+
+// renderBatchWithConfig renders an explicit list of urls as a job, following the
+// sitemap job flow.
+func (app *application) renderBatchWithConfig(config *viper.Viper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		defer r.Body.Close()
+
+		payload, err := shared.ParseBatchPayload(
+			r.Header.Get("Content-Type"),
+			body,
+			r.URL.Query(),
+		)
+		if err != nil {
+			app.logger.Info("Invalid batch request", slog.String("error", err.Error()))
+			app.clientError(
+				w,
+				http.StatusBadRequest,
+				&shared.RespErrorMessage{Message: "Invalid request body"},
+			)
+			return
+		}
+		if len(payload.Urls) == 0 {
+			app.clientError(
+				w,
+				http.StatusBadRequest,
+				&shared.RespErrorMessage{Message: "Missing urls"},
+			)
+			return
+		}
+
+		job := upAndRunWorker.SitemapJob{
+			Urls:        payload.Urls,
+			Priority:    payload.Priority,
+			Concurrency: payload.Concurrency,
+			Mode:        payload.Mode,
+			JobCallback: payload.JobCallback(),
+		}
+		app.submitSitemapJob(w, r, config, job, "batch", "Batch rendering accepted")
+	}
+}
+
+// renderCrawlWithConfig starts a crawl job from the seed urls of the request.
+func (app *application) renderCrawlWithConfig(config *viper.Viper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		defer r.Body.Close()
+
+		var payload shared.RenderCrawlPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			app.logger.Info(
+				"Failed to unmarhsal request body",
+				slog.String("request body", string(body)),
+			)
 			app.clientError(
 				w,
 				http.StatusBadRequest,
-				&shared.RespErrorMessage{Message: "Invalid sitemap url"},
+				&shared.RespErrorMessage{Message: "Invalid request body"},
 			)
 			return
 		}
 
-		var renderKey string
-		select {
-		case app.sitemapSemaphore <- struct{}{}:
-			var err error
-			renderKey, err = wrender.RandomKey(statusKeyLength, statusKeyLength)
-			if err != nil {
-				<-app.sitemapSemaphore // release semaphore slot
+		job := upAndRunWorker.CrawlJob{
+			Seeds:       payload.Seeds,
+			MaxDepth:    payload.MaxDepth,
+			MaxPages:    payload.MaxPages,
+			UrlFilter:   payload.UrlFilter,
+			Priority:    payload.Priority,
+			Concurrency: payload.Concurrency,
+			JobCallback: payload.JobCallback(),
+		}
+		if err := job.Normalize(config); err != nil {
+			var jobErr *upAndRunWorker.InvalidCrawlJobError
+			if !errors.As(err, &jobErr) {
 				app.serverError(w, r, err)
 				return
 			}
-
-			workerHandler := upAndRunWorker.Handler{
-				Logger:    app.logger,
-				DB:        app.db,
-				Semaphore: app.sitemapSemaphore,
-				ErrorChan: app.errorChan,
-			}
-			go workerHandler.RenderSitemap(
-				config,
-				payload.SitemapUrl,
-				renderKey,
+			app.logger.Info("Invalid crawl job", slog.String("error", err.Error()))
+			app.clientError(
+				w,
+				http.StatusBadRequest,
+				&shared.RespErrorMessage{Message: fmt.Sprintf("Invalid %s", jobErr.Param)},
 			)
-		default:
+			return
+		}
+
+		renderKey, err := app.workerHandler().SubmitCrawl(config, job)
+		if errors.Is(err, upAndRunWorker.ErrSitemapJobsFull) {
 			app.clientError(w, http.StatusTooManyRequests, nil)
 			return
 		}
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
 
+		location := fmt.Sprintf("/render/crawl/%s/status", renderKey)
 		msg := fmt.Sprintf(
-			"{\"message\": \"Sitemap rendering accepted\", \"location\": \"/render/sitemap/%s/status\"}",
-			renderKey,
+			"{\"message\": \"Crawl rendering accepted\", \"location\": \"%s\"}",
+			location,
 		)
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Location", fmt.Sprintf("/render/sitemap/%s/status", renderKey))
+		w.Header().Set("Location", location)
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(msg))
 	}
 }
 
-func (app *application) renderSitemapStatus(w http.ResponseWriter, r *http.Request) {
-	jobId := r.PathValue("jobId")
-	if jobId == "" {
+// submitSitemapJob validates and submits job, the status location of the job is
+// returned under /render/{route} along with message.
+func (app *application) submitSitemapJob(
+	w http.ResponseWriter,
+	r *http.Request,
+	config *viper.Viper,
+	job upAndRunWorker.SitemapJob,
+	route, message string,
+) {
+	if err := job.Normalize(config); err != nil {
+		var jobErr *upAndRunWorker.InvalidSitemapJobError
+		if !errors.As(err, &jobErr) {
+			app.serverError(w, r, err)
+			return
+		}
+		app.logger.Info("Invalid sitemap job", slog.String("error", err.Error()))
 		app.clientError(
 			w,
 			http.StatusBadRequest,
-			&shared.RespErrorMessage{Message: "Missing jobId in request path"},
+			&shared.RespErrorMessage{Message: fmt.Sprintf("Invalid %s", jobErr.Param)},
 		)
 		return
 	}
-	app.logger.Debug("Check job status", slog.String("jobId", jobId))
 
-	param := fmt.Sprintf("%s/%s", internal.SitemapCategory, jobId)
-	jobCaching, err := wrender.NewBoltCaching(app.db, param, wrender.CachedJobPrefix, false)
+	renderKey, err := app.workerHandler().SubmitSitemap(config, job)
+	if errors.Is(err, upAndRunWorker.ErrSitemapJobsFull) {
+		app.clientError(w, http.StatusTooManyRequests, nil)
+		return
+	}
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	var statusResp shared.RenderStatusResp
-	content, err := jobCaching.Read()
-	if err != nil {
-		var werr *wrender.CacheNotFoundError
-		if errors.As(err, &werr) {
-			app.logger.Info(
-				"Status of sitemap job not found",
-				slog.String("job id", jobId),
-				slog.String("error", err.Error()),
+	location := fmt.Sprintf("/render/%s/%s/status", route, renderKey)
+	msg := fmt.Sprintf("{\"message\": \"%s\", \"location\": \"%s\"}", message, location)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(msg))
+}
+
+// renderJobStatus reports the status and the progress of the job of category.
+func (app *application) renderJobStatus(category string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobId := r.PathValue("jobId")
+		if jobId == "" {
+			app.clientError(
+				w,
+				http.StatusBadRequest,
+				&shared.RespErrorMessage{Message: "Missing jobId in request path"},
+			)
+			return
+		}
+		app.logger.Debug("Check job status", slog.String("jobId", jobId))
+
+		page, pageSize, err := shared.ParsePage(
+			r.URL.Query().Get("page"),
+			r.URL.Query().Get("pageSize"),
+		)
+		if err != nil {
+			app.clientError(
+				w,
+				http.StatusBadRequest,
+				&shared.RespErrorMessage{Message: err.Error()},
 			)
-			statusResp = shared.RenderStatusResp{
-				Status:     internal.JobStatusUnknown,
-				StatusCode: http.StatusNotFound,
-			}
-		} else {
-			app.serverError(w, r, err)
 			return
 		}
-	} else {
-		jobCache := wrender.SitemapJobCache{}
-		if err := json.Unmarshal([]byte(content), &jobCache); err != nil {
+
+		param := fmt.Sprintf("%s/%s", category, jobId)
+		jobCaching, err := wrender.NewBoltCaching(app.db, param, wrender.CachedJobPrefix, false)
+		if err != nil {
 			app.serverError(w, r, err)
 			return
 		}
 
-		if jobCache.Status != internal.JobStatusCompleted && jobCache.IsExpired() {
-			jobCache.Status = internal.JobStatusTimeout
+		var statusResp shared.RenderStatusResp
+		content, err := jobCaching.Read()
+		if err != nil {
+			var werr *wrender.CacheNotFoundError
+			if errors.As(err, &werr) {
+				app.logger.Info(
+					"Status of job not found",
+					slog.String("job id", jobId),
+					slog.String("error", err.Error()),
+				)
+				statusResp = shared.RenderStatusResp{
+					Status:     internal.JobStatusUnknown,
+					StatusCode: http.StatusNotFound,
+				}
+			} else {
+				app.serverError(w, r, err)
+				return
+			}
+		} else {
+			jobCache := wrender.SitemapJobCache{}
+			if err := json.Unmarshal([]byte(content), &jobCache); err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			if jobCache.Status == internal.JobStatusProcessing && jobCache.IsExpired() {
+				jobCache.Status = internal.JobStatusTimeout
+			}
+			queue := wrender.NewBoltJobQueue(app.db, category, jobId)
+			progress, results, err := sitemapJobProgress(queue, jobCache, page, pageSize)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			statusResp = shared.RenderStatusResp{
+				Status:     jobCache.Status,
+				Reason:     jobCache.Reason,
+				Details:    jobCache.Failed,
+				Progress:   &progress,
+				Results:    &results,
+				StatusCode: http.StatusOK,
+			}
 		}
-		statusResp = shared.RenderStatusResp{
-			Status:     jobCache.Status,
-			Details:    jobCache.Failed,
-			StatusCode: http.StatusOK,
+		responseBody, err := json.Marshal(statusResp)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusResp.StatusCode)
+		w.Write(responseBody)
 	}
-	responseBody, err := json.Marshal(statusResp)
-	if err != nil {
-		app.serverError(w, r, err)
-		return
-	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusResp.StatusCode)
-	w.Write(responseBody)
+// cancelJob cancels the running job of category, the job stops after the render
+// in progress and its status turns to cancelled. The status location of the job
+// is returned under /render/{route}.
+func (app *application) cancelJob(category, route string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobId := r.PathValue("jobId")
+		if jobId == "" {
+			app.clientError(
+				w,
+				http.StatusBadRequest,
+				&shared.RespErrorMessage{Message: "Missing jobId in request path"},
+			)
+			return
+		}
+
+		if !app.jobs.Cancel(category, jobId) {
+			param := fmt.Sprintf("%s/%s", category, jobId)
+			jobCaching, err := wrender.NewBoltCaching(app.db, param, wrender.CachedJobPrefix, false)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			_, err = jobCaching.Read()
+			var werr *wrender.CacheNotFoundError
+			switch {
+			case errors.As(err, &werr):
+				app.clientError(
+					w,
+					http.StatusNotFound,
+					&shared.RespErrorMessage{Message: "Job not found"},
+				)
+			case err != nil:
+				app.serverError(w, r, err)
+			default:
+				app.clientError(
+					w,
+					http.StatusConflict,
+					&shared.RespErrorMessage{Message: "Job is not running"},
+				)
+			}
+			return
+		}
+		app.logger.Info(
+			"Job cancelled",
+			slog.String("category", category),
+			slog.String("jobId", jobId),
+		)
+
+		location := fmt.Sprintf("/render/%s/%s/status", route, jobId)
+		msg := fmt.Sprintf("{\"message\": \"Job cancelled\", \"location\": \"%s\"}", location)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(msg))
+	}
 }
 
 type renderedCachesResponse struct {
@@ -396,6 +558,162 @@ func (app *application) listJobCaches(w http.ResponseWriter, r *http.Request) {
 	w.Write(response)
 }
 
+type metricsResponse struct {
+	InflightRenders  int            `json:"inflightRenders"`
+	CoalescedRenders int64          `json:"coalescedRenders"`
+	QueuedRenders    map[string]int `json:"queuedRenders"`
+}
+
+func (app *application) listMetrics(w http.ResponseWriter, r *http.Request) {
+	output, err := json.Marshal(metricsResponse{
+		InflightRenders:  app.renders.Inflight(),
+		CoalescedRenders: app.renders.Coalesced(),
+		QueuedRenders:    app.scheduler.Queued(),
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
+
+func (app *application) listWarmups(w http.ResponseWriter, r *http.Request) {
+	output, err := json.Marshal(app.warmups.List())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
+
+// addWarmup adds a scheduled sitemap warmup, or replaces the warmup of the same
+// id.
+func (app *application) addWarmup(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	var payload shared.WarmupPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		app.logger.Info(
+			"Failed to unmarhsal request body",
+			slog.String("request body", string(body)),
+		)
+		app.clientError(
+			w,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Invalid request body"},
+		)
+		return
+	}
+
+	if payload.CallbackUrl != "" || payload.CallbackSecret != "" {
+		app.logger.Info("Invalid warmup", slog.String("error", "callback not supported"))
+		app.clientError(
+			w,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "Callbacks are not supported by warmups"},
+		)
+		return
+	}
+
+	schedule, err := app.warmups.Add(upAndRunWorker.WarmupSchedule{
+		Id: payload.Id,
+		SitemapJob: upAndRunWorker.SitemapJob{
+			SitemapUrl:  payload.SitemapUrl,
+			Priority:    payload.Priority,
+			Concurrency: payload.Concurrency,
+			Mode:        payload.Mode,
+
+			SitemapFilter: payload.SitemapFilter,
+		},
+		Cron:              payload.Cron,
+		IntervalInMinutes: payload.IntervalInMinutes,
+		Paused:            payload.Paused,
+	})
+	if err != nil {
+		var jobErr *upAndRunWorker.InvalidSitemapJobError
+		if !errors.As(err, &jobErr) {
+			app.serverError(w, r, err)
+			return
+		}
+		app.logger.Info("Invalid warmup", slog.String("error", err.Error()))
+		app.clientError(
+			w,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: fmt.Sprintf("Invalid %s", jobErr.Param)},
+		)
+		return
+	}
+
+	output, err := json.Marshal(schedule)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(output)
+}
+
+func (app *application) pauseWarmup(w http.ResponseWriter, r *http.Request) {
+	app.setWarmupPaused(w, r, true)
+}
+
+func (app *application) resumeWarmup(w http.ResponseWriter, r *http.Request) {
+	app.setWarmupPaused(w, r, false)
+}
+
+func (app *application) setWarmupPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	id := r.PathValue("id")
+	schedule, err := app.warmups.SetPaused(id, paused)
+	if errors.Is(err, upAndRunWorker.ErrWarmupNotFound) {
+		app.clientError(w, http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	output, err := json.Marshal(schedule)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
+
+func (app *application) removeWarmup(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := app.warmups.Remove(id)
+	if errors.Is(err, upAndRunWorker.ErrWarmupNotFound) {
+		app.clientError(w, http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "warmup removed"}`))
+}
+
 func (app *application) listConfigWithConfig(config *viper.Viper) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		settings := config.AllSettings()
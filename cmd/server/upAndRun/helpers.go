@@ -1,24 +1,39 @@
 package upAndRun
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/liuminhaw/wrenderer/cmd/shared"
 	"github.com/liuminhaw/wrenderer/cmd/worker/upAndRunWorker"
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/renderer"
 	"github.com/liuminhaw/wrenderer/wrender"
+	"github.com/spf13/viper"
 )
 
+var errRenderQueueFull = errors.New("render queue is full")
+
 type application struct {
 	logger           *slog.Logger
 	addr             string
 	db               *bolt.DB
-	renderQueue      chan upAndRunWorker.RenderJob
+	scheduler        *upAndRunWorker.Scheduler
 	sitemapSemaphore chan struct{}
 	errorChan        chan error
+	pool             *renderer.Pool
+	renders          *upAndRunWorker.RenderGroup
+	jobs             *upAndRunWorker.JobRegistry
+	warmups          *upAndRunWorker.Warmups
 }
 
 // The serverError helper writes a log entry at Error level (including the request
@@ -62,6 +77,373 @@ func (app *application) clientError(
 	w.Write([]byte(respMsg))
 }
 
+// The urlParam helper reads the url query parameter of the request. A bad request
+// response is sent and false is returned if the url parameter is missing or invalid.
+func (app *application) urlParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	url := r.URL.Query().Get("url")
+	app.logger.Info(
+		fmt.Sprintf("url: %s", url),
+		slog.String("request", r.URL.String()),
+		slog.String("method", r.Method),
+	)
+	if url == "" {
+		app.clientError(
+			w,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: "One of url or domain parameter is required"},
+		)
+		return "", false
+	}
+
+	if !internal.ValidUrl(url) {
+		app.logger.Info(
+			"Invalid url",
+			slog.String("url", url),
+			slog.String("request", r.URL.String()),
+		)
+		app.clientError(
+			w,
+			http.StatusBadRequest,
+			&shared.RespErrorMessage{Message: fmt.Sprintf("Invalid url: %s", url)},
+		)
+		return "", false
+	}
+
+	return url, true
+}
+
+// renderedPage is the rendered content of a page along with the status code and
+// the redirect chain of the page.
+type renderedPage struct {
+	Content    []byte
+	StatusCode int
+	Redirects  renderer.RedirectChain
+}
+
+// The cachedPage helper reads the page cache of caching. The returned bool is
+// false if the cache does not exist, is expired or caching is disabled in config.
+func (app *application) cachedPage(
+	config *viper.Viper,
+	caching wrender.BoltCaching,
+) (renderedPage, bool, error) {
+	app.logger.Debug(
+		"Checking cache",
+		slog.String("HostBucket", caching.HostBucket),
+		slog.String("CachedKey", caching.CachedKey),
+	)
+	cachedData, err := caching.Read()
+	if err != nil { // cache not exists
+		var werr *wrender.CacheNotFoundError
+		if !errors.As(err, &werr) {
+			return renderedPage{}, false, err
+		}
+		return renderedPage{}, false, nil
+	}
+
+	var cached wrender.PageCached
+	if err := json.Unmarshal([]byte(cachedData), &cached); err != nil {
+		return renderedPage{}, false, err
+	}
+	if cached.IsExpired() || !config.GetBool("cache.enabled") {
+		return renderedPage{}, false, nil
+	}
+	app.logger.Debug(
+		"Cache exists and not expired",
+		slog.String("RootBucket", caching.RootBucket),
+		slog.String("HostBucket", caching.HostBucket),
+		slog.String("CachedKey", caching.CachedKey),
+	)
+
+	decompressContent, err := internal.Decompress(cached.Content)
+	if err != nil {
+		return renderedPage{}, false, err
+	}
+	return renderedPage{
+		Content:    decompressContent,
+		StatusCode: cached.Status(),
+		Redirects:  cached.Redirects,
+	}, true, nil
+}
+
+// The renderedPage helper returns the rendered page of url from the cache. If the
+// cache does not exist or is expired, the page is rendered through the render
+// helper.
+func (app *application) renderedPage(
+	ctx context.Context,
+	config *viper.Viper,
+	url string,
+) (renderedPage, error) {
+	caching, err := wrender.NewBoltCaching(
+		app.db,
+		url,
+		wrender.CachedPagePrefix,
+		false,
+	)
+	if err != nil {
+		return renderedPage{}, err
+	}
+
+	page, ok, err := app.cachedPage(config, caching)
+	if err != nil {
+		return renderedPage{}, err
+	}
+	if ok {
+		return page, nil
+	}
+
+	app.logger.Debug(
+		"Cache expired or not exists",
+		slog.String("RootBucket", caching.RootBucket),
+		slog.String("HostBucket", caching.HostBucket),
+		slog.String("CachedKey", caching.CachedKey),
+	)
+
+	result, err := app.render(ctx, config, url, false)
+	if err != nil {
+		return renderedPage{}, err
+	}
+
+	return renderedPage{
+		Content:    result.Content,
+		StatusCode: result.StatusCode,
+		Redirects:  result.Redirects,
+	}, nil
+}
+
+// The render helper renders url through the scheduler as an interactive render
+// and saves the rendered page to cache. Concurrent renders of the same page are
+// coalesced into a single render job, a queued job of lower priority is promoted
+// when joined. The job is dropped if all requests waiting for it are cancelled. An
+// MHTML snapshot of the page is captured into the result if archive is set.
+// errRenderQueueFull is returned if the render queue stays full for the maximum
+// enqueue wait.
+func (app *application) render(
+	ctx context.Context,
+	config *viper.Viper,
+	url string,
+	archive bool,
+) (*renderer.RenderResult, error) {
+	key, err := upAndRunWorker.RenderKey(url, archive)
+	if err != nil {
+		return nil, err
+	}
+
+	app.scheduler.Promote(key, upAndRunWorker.PriorityInteractive)
+	result, shared, err := app.renders.Do(ctx, key, func(ctx context.Context) (*renderer.RenderResult, error) {
+		job, err := upAndRunWorker.NewRenderJob(ctx, url, archive, upAndRunWorker.PriorityInteractive)
+		if err != nil {
+			return nil, err
+		}
+		if err := app.enqueue(ctx, config, job); err != nil {
+			return nil, err
+		}
+
+		// Wait for the job to be processed
+		result, err := job.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Save the rendered page to cache
+		if err := upAndRunWorker.CachePage(ctx, app.db, config, url, result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+	if shared {
+		app.logger.Debug("Render coalesced with in-flight render", slog.String("url", url))
+	}
+
+	return result, err
+}
+
+// The enqueue helper adds the job to the scheduler, waiting up to the maximum
+// enqueue wait in config if the queue is full. errRenderQueueFull is returned if
+// the queue is still full after waiting, and ctx.Err() if ctx is done before the
+// job is queued.
+func (app *application) enqueue(
+	ctx context.Context,
+	config *viper.Viper,
+	job upAndRunWorker.RenderJob,
+) error {
+	queued, err := app.scheduler.TryEnqueue(job)
+	if err != nil {
+		return err
+	}
+	if queued {
+		app.logger.Info("Job added to queue", slog.String("url", job.Url))
+		return nil
+	}
+
+	maxWait := config.GetDuration("queue.maxWaitInSeconds") * time.Second
+	if maxWait <= 0 {
+		return errRenderQueueFull
+	}
+	app.logger.Debug("Render queue full, waiting", slog.String("url", job.Url))
+	waitCtx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+
+	if err := app.scheduler.Enqueue(waitCtx, job); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return errRenderQueueFull
+		}
+		return err
+	}
+	app.logger.Info("Job added to queue", slog.String("url", job.Url))
+	return nil
+}
+
+// The renderError helper sends the response of a failed render. A full render
+// queue is answered with 429 Too Many Requests and a Retry-After header, nothing
+// is sent if the request is cancelled by the client.
+func (app *application) renderError(
+	w http.ResponseWriter,
+	r *http.Request,
+	config *viper.Viper,
+	err error,
+) {
+	switch {
+	case errors.Is(err, errRenderQueueFull):
+		w.Header().Set("Retry-After", strconv.Itoa(config.GetInt("queue.retryAfterInSeconds")))
+		app.clientError(w, http.StatusTooManyRequests, nil)
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		app.logger.Info(
+			"Request cancelled by client",
+			slog.String("method", r.Method),
+			slog.String("uri", r.URL.RequestURI()),
+		)
+	default:
+		app.serverError(w, r, err)
+	}
+}
+
+// The formattedPage helper returns the rendered page of url in the given output
+// format. Each format is cached separately from the rendered html, with the same
+// ttl as the html page. If the format cache does not exist or is expired, text
+// formats are converted from the html page of the renderedPage helper, and
+// archive formats are captured from a fresh render of the page.
+func (app *application) formattedPage(
+	ctx context.Context,
+	config *viper.Viper,
+	url string,
+	format string,
+) (renderedPage, error) {
+	if format == "" || format == wrender.FormatHtml {
+		return app.renderedPage(ctx, config, url)
+	}
+
+	caching, err := wrender.NewBoltCaching(
+		app.db,
+		url,
+		wrender.CachedPagePrefix,
+		false,
+	)
+	if err != nil {
+		return renderedPage{}, err
+	}
+	caching.CachedKey = wrender.FormatKey(caching.CachedKey, format)
+
+	page, ok, err := app.cachedPage(config, caching)
+	if err != nil {
+		return renderedPage{}, err
+	}
+	if ok {
+		return page, nil
+	}
+
+	if wrender.IsArchiveFormat(format) {
+		// Archives are snapshots of a fresh render
+		result, err := app.render(ctx, config, url, true)
+		if err != nil {
+			return renderedPage{}, err
+		}
+		page = renderedPage{StatusCode: result.StatusCode, Redirects: result.Redirects}
+		page.Content, err = wrender.ArchiveContent(result.Archive, format)
+		if err != nil {
+			return renderedPage{}, err
+		}
+	} else {
+		page, err = app.renderedPage(ctx, config, url)
+		if err != nil {
+			return renderedPage{}, err
+		}
+		pageUrl := url
+		if location := page.Redirects.Location(); location != "" {
+			pageUrl = location
+		}
+		page.Content, err = wrender.ConvertFormat(page.Content, pageUrl, format)
+		if err != nil {
+			return renderedPage{}, err
+		}
+	}
+
+	ttl, ok := upAndRunWorker.PageCacheTtl(config, page.StatusCode)
+	if !ok {
+		return page, nil
+	}
+	pageCache := wrender.NewPageCached(url, nil, ttl)
+	pageCache.Format = format
+	pageCache.StatusCode = page.StatusCode
+	pageCache.Redirects = page.Redirects
+	if err := pageCache.Update(caching, page.Content, false); err != nil {
+		return renderedPage{}, err
+	}
+
+	return page, nil
+}
+
+// The writeRenderedPage helper writes the rendered page to the response with the
+// given status code and the content type of format. The redirect chain of the page is sent in the
+// X-Wrenderer-Redirects header, and with the respond redirect mode a redirected
+// page is answered with a redirect to the final url instead of the page content.
+func (app *application) writeRenderedPage(
+	w http.ResponseWriter,
+	r *http.Request,
+	config *viper.Viper,
+	format string,
+	statusCode int,
+	redirects renderer.RedirectChain,
+	content []byte,
+) {
+	if len(redirects) > 0 {
+		chain, err := json.Marshal(redirects)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		w.Header().Set("X-Wrenderer-Redirects", string(chain))
+
+		if config.GetString("renderer.redirectMode") == wrender.RedirectModeRespond {
+			w.Header().Set("Location", redirects.Location())
+			w.WriteHeader(redirects.StatusCode())
+			return
+		}
+	}
+
+	contentType := wrender.FormatContentType(format)
+	if strings.HasPrefix(contentType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	w.Write(content)
+}
+
+// workerHandler returns the worker handler submitting jobs to the shared render
+// workers and job slots.
+func (app *application) workerHandler() *upAndRunWorker.Handler {
+	return &upAndRunWorker.Handler{
+		Logger:    app.logger,
+		DB:        app.db,
+		Pool:      app.pool,
+		Renders:   app.renders,
+		Scheduler: app.scheduler,
+		Jobs:      app.jobs,
+		Semaphore: app.sitemapSemaphore,
+		ErrorChan: app.errorChan,
+	}
+}
+
 func listCaches[T any](
 	db *bolt.DB,
 	cachePrefix string,
@@ -104,3 +486,32 @@ func listCaches[T any](
 
 	return response, nil
 }
+
+// sitemapJobProgress reports the progress of the sitemap job of jobCache from
+// its persisted queue, along with the given page of its entry results.
+func sitemapJobProgress(
+	queue wrender.BoltJobQueue,
+	jobCache wrender.SitemapJobCache,
+	page, pageSize int,
+) (wrender.JobProgress, wrender.JobResults, error) {
+	progress, err := queue.Progress(jobCache.Created, jobCache.Finished)
+	if err != nil {
+		return wrender.JobProgress{}, wrender.JobResults{}, err
+	}
+	if jobCache.Status == internal.JobStatusProcessing {
+		progress.EstimateEta(time.Now())
+	}
+
+	entries, total, err := queue.Results((page-1)*pageSize, pageSize)
+	if err != nil {
+		return wrender.JobProgress{}, wrender.JobResults{}, err
+	}
+	results := wrender.JobResults{
+		Page:     page,
+		PageSize: pageSize,
+		Total:    total,
+		Entries:  entries,
+	}
+
+	return progress, results, nil
+}
@@ -0,0 +1,90 @@
+package upAndRun
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/cmd/worker/upAndRunWorker"
+	"github.com/spf13/viper"
+)
+
+// newFullQueueApp returns an application whose interactive render queue is full.
+func newFullQueueApp(t *testing.T) *application {
+	t.Helper()
+
+	scheduler := upAndRunWorker.NewScheduler(upAndRunWorker.SchedulerOption{Capacity: 1, Workers: 1})
+	t.Cleanup(scheduler.Close)
+	app := &application{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		scheduler: scheduler,
+	}
+	if err := app.enqueue(context.Background(), viper.New(), newRenderJob(t, "https://a.com/1")); err != nil {
+		t.Fatalf("enqueue() error: %v", err)
+	}
+	return app
+}
+
+func newRenderJob(t *testing.T, url string) upAndRunWorker.RenderJob {
+	t.Helper()
+
+	job, err := upAndRunWorker.NewRenderJob(context.Background(), url, false, upAndRunWorker.PriorityInteractive)
+	if err != nil {
+		t.Fatalf("NewRenderJob() error: %v", err)
+	}
+	return job
+}
+
+func TestEnqueueFullQueue(t *testing.T) {
+	config := viper.New()
+
+	// Without wait, a full queue is answered right away
+	app := newFullQueueApp(t)
+	if err := app.enqueue(context.Background(), config, newRenderJob(t, "https://a.com/2")); !errors.Is(err, errRenderQueueFull) {
+		t.Errorf("enqueue() without wait error = %v, want errRenderQueueFull", err)
+	}
+
+	config.Set("queue.maxWaitInSeconds", 1)
+	start := time.Now()
+	if err := app.enqueue(context.Background(), config, newRenderJob(t, "https://a.com/2")); !errors.Is(err, errRenderQueueFull) {
+		t.Errorf("enqueue() of a queue staying full error = %v, want errRenderQueueFull", err)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("enqueue() gave up after %v, want the 1s maximum wait", waited)
+	}
+
+	// The job is queued once a worker takes a job within the wait
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		app.scheduler.Next()
+	}()
+	if err := app.enqueue(context.Background(), config, newRenderJob(t, "https://a.com/3")); err != nil {
+		t.Errorf("enqueue() with a slot freed while waiting error = %v, want nil", err)
+	}
+
+	// A request cancelled while waiting is not a full queue
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := app.enqueue(ctx, config, newRenderJob(t, "https://a.com/4")); !errors.Is(err, context.Canceled) {
+		t.Errorf("enqueue() of a cancelled request error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRenderErrorQueueFull(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	config := viper.New()
+	config.Set("queue.retryAfterInSeconds", 7)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/render?url=https://a.com/", nil)
+	app.renderError(w, r, config, errRenderQueueFull)
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "7" {
+		t.Errorf("renderError() = %d with Retry-After %q, want 429 with 7", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -3,6 +3,7 @@ package upAndRun
 import (
 	"net/http"
 
+	"github.com/liuminhaw/wrenderer/internal"
 	"github.com/spf13/viper"
 )
 
@@ -12,14 +13,28 @@ func (app *application) routes(vConfig *viper.Viper) http.Handler {
 
 	mux.HandleFunc("GET /render", app.pageRenderWithConfig(vConfig))
 	mux.HandleFunc("DELETE /render", app.deleteRenderedCache)
+	mux.HandleFunc("GET /render/meta", app.pageMetaWithConfig(vConfig))
 	mux.HandleFunc("PUT /render/sitemap", app.renderSitemapWithConfig(vConfig))
-	mux.HandleFunc("GET /render/sitemap/{jobId}/status", app.renderSitemapStatus)
+	mux.HandleFunc("GET /render/sitemap/{jobId}/status", app.renderJobStatus(internal.SitemapCategory))
+	mux.HandleFunc("DELETE /render/sitemap/{jobId}", app.cancelJob(internal.SitemapCategory, "sitemap"))
+	mux.HandleFunc("PUT /render/batch", app.renderBatchWithConfig(vConfig))
+	mux.HandleFunc("GET /render/batch/{jobId}/status", app.renderJobStatus(internal.BatchCategory))
+	mux.HandleFunc("DELETE /render/batch/{jobId}", app.cancelJob(internal.BatchCategory, "batch"))
+	mux.HandleFunc("PUT /render/crawl", app.renderCrawlWithConfig(vConfig))
+	mux.HandleFunc("GET /render/crawl/{jobId}/status", app.renderJobStatus(internal.CrawlCategory))
+	mux.HandleFunc("DELETE /render/crawl/{jobId}", app.cancelJob(internal.CrawlCategory, "crawl"))
 
 	// admin routes
 	adminCheck := authorizedAdmin(vConfig)
 	mux.Handle("GET /admin/renders", adminCheck(http.HandlerFunc(app.listRenderedCaches)))
 	mux.Handle("GET /admin/jobs", adminCheck(http.HandlerFunc(app.listJobCaches)))
 	mux.Handle("GET /admin/config", adminCheck(http.HandlerFunc(app.listConfigWithConfig(vConfig))))
+	mux.Handle("GET /admin/metrics", adminCheck(http.HandlerFunc(app.listMetrics)))
+	mux.Handle("GET /admin/warmups", adminCheck(http.HandlerFunc(app.listWarmups)))
+	mux.Handle("POST /admin/warmups", adminCheck(http.HandlerFunc(app.addWarmup)))
+	mux.Handle("PUT /admin/warmups/{id}/pause", adminCheck(http.HandlerFunc(app.pauseWarmup)))
+	mux.Handle("PUT /admin/warmups/{id}/resume", adminCheck(http.HandlerFunc(app.resumeWarmup)))
+	mux.Handle("DELETE /admin/warmups/{id}", adminCheck(http.HandlerFunc(app.removeWarmup)))
 
 	return authorized(vConfig)(mux)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -127,3 +128,18 @@ func (q Queue) SendMessage(message string) (string, error) {
 
 	return *resp.MessageId, nil
 }
+
+// SendMessageWithDelay sends a message to the SQS queue, delivered once delay
+// is over, and returns the message ID. SQS delays messages up to 15 minutes.
+func (q Queue) SendMessageWithDelay(message string, delay time.Duration) (string, error) {
+	resp, err := q.Client.SendMessage(context.Background(), &sqs.SendMessageInput{
+		QueueUrl:     aws.String(q.Url),
+		MessageBody:  aws.String(message),
+		DelaySeconds: int32(min(delay, 15*time.Minute) / time.Second),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return *resp.MessageId, nil
+}
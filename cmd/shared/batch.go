@@ -0,0 +1,121 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// batchFileField is the form field of the url list uploaded as multipart form.
+const batchFileField = "file"
+
+// RenderBatchPayload is an explicit list of urls to render as a job.
+type RenderBatchPayload struct {
+	Urls     []string `json:"urls"`
+	Priority string   `json:"priority,omitempty"`
+	// Concurrency is the number of urls of the job rendered at a time
+	Concurrency int `json:"concurrency,omitempty"`
+	// Mode is full, incremental or missing-only, full renders every url
+	Mode string `json:"mode,omitempty"`
+	CallbackPayload
+}
+
+// ParseBatchPayload reads the body of a batch render request following its
+// content type. A text/plain body or the file field of a multipart/form-data
+// body is a newline delimited list of urls, where blank lines and lines starting
+// with "#" are skipped. Other bodies are JSON, either a RenderBatchPayload or an
+// array of urls. The priority, concurrency, mode, callbackUrl and callbackSecret
+// query values are used when the body has no such fields.
+func ParseBatchPayload(contentType string, body []byte, query url.Values) (RenderBatchPayload, error) {
+	var payload RenderBatchPayload
+
+	// An invalid content type is read as JSON
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+
+	var err error
+	switch mediaType {
+	case "text/plain":
+		payload.Urls = parseUrlLines(body)
+	case "multipart/form-data":
+		payload.Urls, err = parseBatchForm(body, params["boundary"])
+	default:
+		trimmed := bytes.TrimSpace(body)
+		if bytes.HasPrefix(trimmed, []byte("[")) {
+			err = json.Unmarshal(trimmed, &payload.Urls)
+		} else {
+			err = json.Unmarshal(trimmed, &payload)
+		}
+	}
+	if err != nil {
+		return payload, fmt.Errorf("parse batch payload: %w", err)
+	}
+
+	if payload.Priority == "" {
+		payload.Priority = query.Get("priority")
+	}
+	if payload.Mode == "" {
+		payload.Mode = query.Get("mode")
+	}
+	if payload.CallbackUrl == "" {
+		payload.CallbackUrl = query.Get("callbackUrl")
+	}
+	if payload.CallbackSecret == "" {
+		payload.CallbackSecret = query.Get("callbackSecret")
+	}
+	if value := query.Get("concurrency"); payload.Concurrency == 0 && value != "" {
+		payload.Concurrency, err = strconv.Atoi(value)
+		if err != nil {
+			return payload, fmt.Errorf("parse batch payload: invalid concurrency %q", value)
+		}
+	}
+
+	return payload, nil
+}
+
+// parseBatchForm reads the url list of the file field of a multipart form.
+func parseBatchForm(body []byte, boundary string) ([]string, error) {
+	if boundary == "" {
+		return nil, errors.New("missing multipart boundary")
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("missing %s field", batchFileField)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != batchFileField {
+			continue
+		}
+
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		return parseUrlLines(content), nil
+	}
+}
+
+func parseUrlLines(content []byte) []string {
+	var urls []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls
+}
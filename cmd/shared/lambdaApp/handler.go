@@ -2,34 +2,88 @@ package lambdaApp
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/liuminhaw/renderer"
 	"github.com/liuminhaw/wrenderer/cmd/shared"
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/postprocess"
+	"github.com/liuminhaw/wrenderer/renderer"
 	"github.com/liuminhaw/wrenderer/wrender"
 )
 
+const (
+	// S3 object metadata keys of the rendered page cache
+	statusCodeMetaKey = "status-code"
+	expiresMetaKey    = "expires"
+	redirectsMetaKey  = "redirects"
+
+	// S3 limits the user-defined metadata to 2 KB, longer redirect chains are
+	// not stored with the cached object.
+	redirectsMetaMaxLength = 1024
+)
+
+// RenderedObject is the cached object of a rendered page in S3 bucket along
+// with the status code and the redirect chain of the rendered page.
+type RenderedObject struct {
+	Path       string
+	StatusCode int
+	Redirects  renderer.RedirectChain
+}
+
 // RenderUrl will check if the given url is already rendered and cached in S3 bucket.
 // If not, it will render the url and upload the result to S3 bucket for caching.
 // existenceCheck is a flag to check the existence of the object in S3 bucket.
 // If the flag is set to false, the object will be rendered and uploaded to S3 bucket
 // no matter if the object already exists in the bucket.
-// The cached object path will be returned if no error occurred, otherwise an error
-// will be returned.
+// The cached object path and status code will be returned if no error occurred,
+// otherwise an error will be returned. The S3 requests and the render are bound
+// to ctx.
 // func (app *Application) RenderUrl(url string, existenceCheck bool) (string, error) {
-func RenderUrl(url string, existenceCheck bool, logger *slog.Logger) (string, error) {
+func RenderUrl(
+	ctx context.Context,
+	url string,
+	existenceCheck bool,
+	logger *slog.Logger,
+) (RenderedObject, error) {
+	return renderUrl(ctx, url, existenceCheck, true, logger)
+}
+
+// PrerenderUrl renders url and uploads the result to S3 bucket for caching, as
+// RenderUrl without existence check does for a page which is not served. Error
+// pages are not uploaded with the skip error cache policy, the returned object
+// has no Path then.
+func PrerenderUrl(ctx context.Context, url string, logger *slog.Logger) (RenderedObject, error) {
+	return renderUrl(ctx, url, false, false, logger)
+}
+
+// renderUrl renders url following RenderUrl. An error page rendered to be served
+// is always uploaded since it is served from the bucket, otherwise it is only
+// uploaded if the error cache policy caches error pages.
+func renderUrl(
+	ctx context.Context,
+	url string,
+	existenceCheck, served bool,
+	logger *slog.Logger,
+) (RenderedObject, error) {
 	loader, err := shared.NewConfLoader(shared.S3Service)
 	if err != nil {
-		return "", err
+		return RenderedObject{}, err
 	}
 
 	// Check if object exists
 	render, err := wrender.NewWrender(url, wrender.CachedPagePrefix)
 	if err != nil {
-		return "", err
+		return RenderedObject{}, err
 	}
 	caching := wrender.NewS3Caching(
 		loader.Clients.S3,
@@ -40,40 +94,403 @@ func RenderUrl(url string, existenceCheck bool, logger *slog.Logger) (string, er
 			Region:      loader.EnvConf.S3BucketRegion,
 			ContentType: wrender.HtmlContentType,
 		},
-	)
+	).WithContext(ctx)
 
-	// var exists bool
 	if existenceCheck {
-		exists, err := caching.Exists()
-		if err != nil {
-			return "", err
+		metadata, err := caching.Metadata()
+		if err == nil && !metadataExpired(metadata) {
+			return RenderedObject{
+				Path:       caching.CachedPath,
+				StatusCode: metadataStatusCode(metadata),
+				Redirects:  metadataRedirects(metadata),
+			}, nil
 		}
-		if exists {
-			return caching.CachedPath, nil
+		var werr *wrender.CacheNotFoundError
+		if err != nil && !errors.As(err, &werr) {
+			return RenderedObject{}, err
 		}
 	}
 
 	// Render the page
-	content, err := renderPage(url, logger)
+	result, err := renderPage(ctx, url, false, logger)
 	if err != nil {
-		return "", err
+		return RenderedObject{}, err
 	}
 
 	// Check if rendered result is empty
-	if len(content) == 0 {
-		return "", fmt.Errorf("empty content render result")
+	if len(result.Content) == 0 {
+		return RenderedObject{}, fmt.Errorf("empty content render result")
 	}
 
-	// Upload rendered result to S3
-	contentReader := bytes.NewReader(content)
+	if !served && wrender.IsErrorStatus(result.StatusCode) {
+		policy, _, err := errorCachePolicy()
+		if err != nil {
+			return RenderedObject{}, err
+		}
+		if policy == wrender.ErrorPolicySkip {
+			logger.Debug(
+				"Error page not cached",
+				slog.String("url", url),
+				slog.Int("status", result.StatusCode),
+			)
+			return RenderedObject{
+				StatusCode: result.StatusCode,
+				Redirects:  result.Redirects,
+			}, nil
+		}
+	}
+
+	// Upload rendered result to S3. A served object is always uploaded since it
+	// is served from the bucket, error pages which should not be cached are
+	// marked as expired right away to be rendered again on next request.
+	caching.Meta.Metadata, err = objectMetadata(result.StatusCode, result.Redirects)
+	if err != nil {
+		return RenderedObject{}, err
+	}
+
+	contentReader := bytes.NewReader(result.Content)
 	if err := caching.Update(contentReader); err != nil {
-		return "", err
+		return RenderedObject{}, err
+	}
+	// Converted objects of the previous rendered content are outdated
+	if err := DeleteFormatObjects(caching, wrender.ConvertedFormats); err != nil {
+		return RenderedObject{}, err
+	}
+
+	// Cache the redirected page under the final url as well with follow mode
+	location := result.Redirects.Location()
+	if RedirectMode() == wrender.RedirectModeFollow && location != "" && location != url {
+		target, err := wrender.NewWrender(location, wrender.CachedPagePrefix)
+		if err != nil {
+			return RenderedObject{}, err
+		}
+		targetCaching := caching
+		targetCaching.CachedPrefix = target.GetPrefixPath()
+		targetCaching.CachedPath = target.CachePath
+		targetCaching.Meta.Metadata = maps.Clone(caching.Meta.Metadata)
+		if err := targetCaching.Update(bytes.NewReader(result.Content)); err != nil {
+			return RenderedObject{}, err
+		}
+		if err := DeleteFormatObjects(targetCaching, wrender.ConvertedFormats); err != nil {
+			return RenderedObject{}, err
+		}
+	}
+
+	return RenderedObject{
+		Path:       caching.CachedPath,
+		StatusCode: result.StatusCode,
+		Redirects:  result.Redirects,
+	}, nil
+}
+
+// RenderUrlWithFormat returns the cached object of the given url in the given
+// output format. If the object does not exist or is expired, text formats are
+// converted from the rendered html object of RenderUrl, and archive formats are
+// captured from a fresh render of the page. The object is uploaded to S3 bucket
+// next to the html object.
+func RenderUrlWithFormat(
+	ctx context.Context,
+	url, format string,
+	logger *slog.Logger,
+) (RenderedObject, error) {
+	if format == "" || format == wrender.FormatHtml {
+		return RenderUrl(ctx, url, true, logger)
+	}
+
+	loader, err := shared.NewConfLoader(shared.S3Service)
+	if err != nil {
+		return RenderedObject{}, err
+	}
+
+	render, err := wrender.NewWrender(url, wrender.CachedPagePrefix)
+	if err != nil {
+		return RenderedObject{}, err
+	}
+	caching := wrender.NewS3Caching(
+		loader.Clients.S3,
+		render.GetPrefixPath(),
+		wrender.FormatKey(render.CachePath, format),
+		wrender.S3CachingMeta{
+			Bucket:      loader.EnvConf.S3BucketName,
+			Region:      loader.EnvConf.S3BucketRegion,
+			ContentType: wrender.FormatContentType(format),
+		},
+	).WithContext(ctx)
+
+	metadata, err := caching.Metadata()
+	if err == nil && !metadataExpired(metadata) {
+		return RenderedObject{
+			Path:       caching.CachedPath,
+			StatusCode: metadataStatusCode(metadata),
+			Redirects:  metadataRedirects(metadata),
+		}, nil
+	}
+	var werr *wrender.CacheNotFoundError
+	if err != nil && !errors.As(err, &werr) {
+		return RenderedObject{}, err
+	}
+
+	var rendered RenderedObject
+	var converted []byte
+	if wrender.IsArchiveFormat(format) {
+		// Archives are snapshots of a fresh render
+		result, err := renderPage(ctx, url, true, logger)
+		if err != nil {
+			return RenderedObject{}, err
+		}
+		rendered = RenderedObject{StatusCode: result.StatusCode, Redirects: result.Redirects}
+		converted, err = wrender.ArchiveContent(result.Archive, format)
+		if err != nil {
+			return RenderedObject{}, err
+		}
+	} else {
+		rendered, err = RenderUrl(ctx, url, true, logger)
+		if err != nil {
+			return RenderedObject{}, err
+		}
+		htmlCaching := caching
+		htmlCaching.CachedPath = rendered.Path
+		content, err := htmlCaching.Read()
+		if err != nil {
+			return RenderedObject{}, err
+		}
+
+		pageUrl := url
+		if location := rendered.Redirects.Location(); location != "" {
+			pageUrl = location
+		}
+		converted, err = wrender.ConvertFormat(content, pageUrl, format)
+		if err != nil {
+			return RenderedObject{}, err
+		}
 	}
 
-	return caching.CachedPath, nil
+	caching.Meta.Metadata, err = objectMetadata(rendered.StatusCode, rendered.Redirects)
+	if err != nil {
+		return RenderedObject{}, err
+	}
+	if err := caching.Update(bytes.NewReader(converted)); err != nil {
+		return RenderedObject{}, err
+	}
+
+	return RenderedObject{
+		Path:       caching.CachedPath,
+		StatusCode: rendered.StatusCode,
+		Redirects:  rendered.Redirects,
+	}, nil
+}
+
+// DeleteFormatObjects removes the objects of the given output formats belonging to
+// the html page object of caching.
+func DeleteFormatObjects(caching wrender.S3Caching, formats []string) error {
+	htmlPath := caching.CachedPath
+	for _, format := range formats {
+		if format == wrender.FormatHtml {
+			continue
+		}
+		caching.CachedPath = wrender.FormatKey(htmlPath, format)
+		if err := caching.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func renderPage(urlParam string, logger *slog.Logger) ([]byte, error) {
+// objectMetadata returns the S3 object metadata of a rendered page with the given
+// status code and redirect chain. Error pages get an expires entry following the
+// error cache policy.
+func objectMetadata(statusCode int, redirects renderer.RedirectChain) (map[string]string, error) {
+	metadata := map[string]string{
+		statusCodeMetaKey: strconv.Itoa(statusCode),
+	}
+	if len(redirects) > 0 {
+		chain, err := json.Marshal(redirects)
+		if err != nil {
+			return nil, err
+		}
+		if len(chain) <= redirectsMetaMaxLength {
+			metadata[redirectsMetaKey] = string(chain)
+		}
+	}
+	if wrender.IsErrorStatus(statusCode) {
+		policy, ttl, err := errorCachePolicy()
+		if err != nil {
+			return nil, err
+		}
+		switch policy {
+		case wrender.ErrorPolicySkip:
+			metadata[expiresMetaKey] = time.Now().UTC().Format(time.RFC3339)
+		case wrender.ErrorPolicyShort:
+			metadata[expiresMetaKey] = time.Now().Add(ttl).UTC().Format(time.RFC3339)
+		}
+	}
+
+	return metadata, nil
+}
+
+// RedirectMode reads the redirect mode for redirected pages from environment
+// variable, defaults to follow mode.
+func RedirectMode() string {
+	mode, exists := os.LookupEnv("WRENDERER_REDIRECT_MODE")
+	if !exists || mode != wrender.RedirectModeRespond {
+		return wrender.RedirectModeFollow
+	}
+	return mode
+}
+
+// DomainLimits reads the per domain render limits of the sitemap worker from
+// environment variables, unset limits are disabled. The limits are enforced
+// across the instances by AcquireDomainLease and ClaimDomainSlot.
+func DomainLimits() (internal.DomainLimits, error) {
+	var limits internal.DomainLimits
+
+	concurrentConfig, exists := os.LookupEnv("WRENDERER_DOMAIN_MAX_CONCURRENT_RENDERS")
+	if exists {
+		concurrent, err := strconv.Atoi(concurrentConfig)
+		if err != nil {
+			return limits, fmt.Errorf("domainLimits: %w", err)
+		}
+		limits.MaxConcurrent = max(concurrent, 0)
+	}
+
+	rateConfig, exists := os.LookupEnv("WRENDERER_DOMAIN_RENDERS_PER_SECOND")
+	if exists {
+		rate, err := strconv.ParseFloat(rateConfig, 64)
+		if err != nil {
+			return limits, fmt.Errorf("domainLimits: %w", err)
+		}
+		limits.RatePerSecond = max(rate, 0)
+	}
+	delayConfig, exists := os.LookupEnv("WRENDERER_DOMAIN_DELAY_IN_MILLISECONDS")
+	if exists {
+		delay, err := strconv.Atoi(delayConfig)
+		if err != nil {
+			return limits, fmt.Errorf("domainLimits: %w", err)
+		}
+		limits.Delay = time.Duration(max(delay, 0)) * time.Millisecond
+	}
+
+	return limits, nil
+}
+
+// PageCacheCreated returns the time the page cache object of url was written,
+// false is returned if the page has no valid cache.
+func PageCacheCreated(ctx context.Context, url string) (time.Time, bool, error) {
+	loader, err := shared.NewConfLoader(shared.S3Service)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	render, err := wrender.NewWrender(url, wrender.CachedPagePrefix)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	caching := wrender.NewS3Caching(
+		loader.Clients.S3,
+		render.GetPrefixPath(),
+		render.CachePath,
+		wrender.S3CachingMeta{
+			Bucket:      loader.EnvConf.S3BucketName,
+			Region:      loader.EnvConf.S3BucketRegion,
+			ContentType: wrender.HtmlContentType,
+		},
+	).WithContext(ctx)
+
+	info, metadata, err := caching.ObjectInfo()
+	if err != nil {
+		var werr *wrender.CacheNotFoundError
+		if errors.As(err, &werr) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	if metadataExpired(metadata) {
+		return time.Time{}, false, nil
+	}
+	return info.Modified, true, nil
+}
+
+// SitemapOption reads the limits of the sitemap sources followed from the
+// WRENDERER_SITEMAP_MAX_DEPTH and WRENDERER_SITEMAP_MAX_URLS environment
+// variables, the limits default to a depth of 3 and 50000 urls.
+func SitemapOption() (internal.SitemapOption, error) {
+	opts := internal.SitemapOption{MaxDepth: 3, MaxUrls: 50000}
+
+	depthConfig, exists := os.LookupEnv("WRENDERER_SITEMAP_MAX_DEPTH")
+	if exists {
+		depth, err := strconv.Atoi(depthConfig)
+		if err != nil {
+			return opts, fmt.Errorf("sitemapOption: %w", err)
+		}
+		opts.MaxDepth = max(depth, 0)
+	}
+	urlsConfig, exists := os.LookupEnv("WRENDERER_SITEMAP_MAX_URLS")
+	if exists {
+		urls, err := strconv.Atoi(urlsConfig)
+		if err != nil {
+			return opts, fmt.Errorf("sitemapOption: %w", err)
+		}
+		opts.MaxUrls = max(urls, 0)
+	}
+
+	return opts, nil
+}
+
+// errorCachePolicy reads the cache policy and the ttl for rendered error pages
+// from environment variables.
+func errorCachePolicy() (string, time.Duration, error) {
+	policy, exists := os.LookupEnv("WRENDERER_ERROR_CACHE_POLICY")
+	if !exists {
+		policy = wrender.ErrorPolicyCache
+	}
+
+	duration := 5
+	durationConfig, exists := os.LookupEnv("WRENDERER_ERROR_CACHE_DURATION_IN_MINUTES")
+	if exists {
+		var err error
+		duration, err = strconv.Atoi(durationConfig)
+		if err != nil {
+			return "", 0, fmt.Errorf("errorCachePolicy: %w", err)
+		}
+	}
+
+	return policy, time.Duration(duration) * time.Minute, nil
+}
+
+func metadataStatusCode(metadata map[string]string) int {
+	statusCode, err := strconv.Atoi(metadata[statusCodeMetaKey])
+	if err != nil {
+		return http.StatusOK
+	}
+	return statusCode
+}
+
+func metadataRedirects(metadata map[string]string) renderer.RedirectChain {
+	var redirects renderer.RedirectChain
+	if err := json.Unmarshal([]byte(metadata[redirectsMetaKey]), &redirects); err != nil {
+		return nil
+	}
+	return redirects
+}
+
+func metadataExpired(metadata map[string]string) bool {
+	expires, ok := metadata[expiresMetaKey]
+	if !ok {
+		return false
+	}
+	expiresTime, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		return false
+	}
+	return time.Now().UTC().After(expiresTime)
+}
+
+func renderPage(
+	ctx context.Context,
+	urlParam string,
+	archive bool,
+	logger *slog.Logger,
+) (*renderer.RenderResult, error) {
 	idleType, exists := os.LookupEnv("WRENDERER_IDLE_TYPE")
 	if !exists {
 		idleType = "networkIdle"
@@ -106,7 +523,7 @@ func renderPage(urlParam string, logger *slog.Logger) ([]byte, error) {
 	}
 
 	r := renderer.NewRenderer(renderer.WithLogger(logger))
-	content, err := r.RenderPage(urlParam, &renderer.RendererOption{
+	result, err := r.RenderPage(ctx, urlParam, &renderer.RendererOption{
 		BrowserOpts: renderer.BrowserConf{
 			IdleType:  idleType,
 			Container: true,
@@ -116,10 +533,30 @@ func renderPage(urlParam string, logger *slog.Logger) ([]byte, error) {
 		WindowHeight: windowHeight,
 		Timeout:      30,
 		UserAgent:    userAgent,
+		Archive:      archive,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("renderPage: %w", err)
 	}
 
-	return content, nil
+	// Post-process rendered content
+	var steps []string
+	stepsConfig, exists := os.LookupEnv("WRENDERER_POSTPROCESS_STEPS")
+	if exists {
+		steps = strings.Split(stepsConfig, ",")
+	}
+	pipeline, err := postprocess.NewPipeline(steps)
+	if err != nil {
+		return nil, fmt.Errorf("renderPage: %w", err)
+	}
+	pageUrl := urlParam
+	if location := result.Redirects.Location(); location != "" {
+		pageUrl = location
+	}
+	result.Content, err = pipeline.Process(result.Content, pageUrl)
+	if err != nil {
+		return nil, fmt.Errorf("renderPage: %w", err)
+	}
+
+	return result, nil
 }
@@ -0,0 +1,423 @@
+package lambdaApp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/cmd/shared"
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/wrender"
+)
+
+const (
+	// JobTimestampFile is the job cache object of the time the job started.
+	JobTimestampFile = "timestamp"
+	// jobCallbackFile is the job cache object of the job callback, written once
+	// every entry of the job is queued.
+	jobCallbackFile = "callback"
+	// jobNotifiedFile is the job cache object marking the job callback as sent.
+	jobNotifiedFile = "notified"
+	// jobDoneFile is the job cache object counting the done entries of a job with
+	// callback.
+	jobDoneFile = "done"
+	// jobDoneAttempts bounds the attempts to count a done entry while other
+	// workers update the count at the same time.
+	jobDoneAttempts = 20
+)
+
+// JobEntryObject is a job cache object of a job entry in the given state.
+type JobEntryObject struct {
+	wrender.CacheObjectInfo
+	State string
+}
+
+// JobProgress counts the job cache objects of the job entries in each state, the
+// job is finished when the last entry is done. The objects of the entries are
+// returned in the order they were last updated.
+func JobProgress(
+	caching wrender.S3Caching,
+	started time.Time,
+) (wrender.JobProgress, []JobEntryObject, error) {
+	progress := wrender.JobProgress{Started: started}
+	counts := map[string]*int{
+		internal.JobStatusQueued:     &progress.Queued,
+		internal.JobStatusProcessing: &progress.Processing,
+		internal.JobStatusSucceeded:  &progress.Succeeded,
+		internal.JobStatusFailed:     &progress.Failed,
+		internal.JobStatusSkipped:    &progress.Skipped,
+	}
+
+	var objects []JobEntryObject
+	var lastDone time.Time
+	for _, state := range wrender.JobEntryStates {
+		infos, err := caching.ListObjects(state)
+		if err != nil {
+			return wrender.JobProgress{}, nil, err
+		}
+		for _, info := range infos {
+			objects = append(objects, JobEntryObject{CacheObjectInfo: info, State: state})
+			done := state == internal.JobStatusSucceeded || state == internal.JobStatusFailed
+			if done && info.Modified.After(lastDone) {
+				lastDone = info.Modified
+			}
+		}
+		*counts[state] = len(infos)
+		progress.Total += len(infos)
+	}
+	if progress.Queued == 0 && progress.Processing == 0 && !lastDone.IsZero() {
+		finished := lastDone.UTC()
+		progress.Finished = &finished
+	}
+
+	slices.SortStableFunc(objects, func(a, b JobEntryObject) int {
+		if c := a.Modified.Compare(b.Modified); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return progress, objects, nil
+}
+
+// CallbackOption reads the delivery policy of the job callbacks from the
+// WRENDERER_CALLBACK_MAX_ATTEMPTS, WRENDERER_CALLBACK_BACKOFF_IN_SECONDS and
+// WRENDERER_CALLBACK_TIMEOUT_IN_SECONDS environment variables, the policy
+// defaults to 5 attempts with a backoff of 1 second and a timeout of 10 seconds.
+func CallbackOption() (wrender.CallbackOption, error) {
+	opts := wrender.CallbackOption{
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		Timeout:     10 * time.Second,
+	}
+
+	attemptsConfig, exists := os.LookupEnv("WRENDERER_CALLBACK_MAX_ATTEMPTS")
+	if exists {
+		attempts, err := strconv.Atoi(attemptsConfig)
+		if err != nil {
+			return opts, fmt.Errorf("callbackOption: %w", err)
+		}
+		opts.MaxAttempts = max(attempts, 1)
+	}
+	backoffConfig, exists := os.LookupEnv("WRENDERER_CALLBACK_BACKOFF_IN_SECONDS")
+	if exists {
+		backoff, err := strconv.Atoi(backoffConfig)
+		if err != nil {
+			return opts, fmt.Errorf("callbackOption: %w", err)
+		}
+		opts.Backoff = time.Duration(max(backoff, 0)) * time.Second
+	}
+	timeoutConfig, exists := os.LookupEnv("WRENDERER_CALLBACK_TIMEOUT_IN_SECONDS")
+	if exists {
+		timeout, err := strconv.Atoi(timeoutConfig)
+		if err != nil {
+			return opts, fmt.Errorf("callbackOption: %w", err)
+		}
+		if timeout > 0 {
+			opts.Timeout = time.Duration(timeout) * time.Second
+		}
+	}
+
+	return opts, nil
+}
+
+// RetryPolicy reads the retry policy of the failed renders of the jobs from the
+// WRENDERER_RETRY_MAX_ATTEMPTS, WRENDERER_RETRY_BACKOFF_IN_SECONDS and
+// WRENDERER_RETRY_MAX_BACKOFF_IN_SECONDS environment variables, the policy
+// defaults to 3 attempts with a backoff of 5 seconds up to 60 seconds. The
+// backoff is bounded by the 15 minutes delay limit of SQS.
+func RetryPolicy() (wrender.RetryPolicy, error) {
+	policy := wrender.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     5 * time.Second,
+		MaxBackoff:  time.Minute,
+	}
+
+	attemptsConfig, exists := os.LookupEnv("WRENDERER_RETRY_MAX_ATTEMPTS")
+	if exists {
+		attempts, err := strconv.Atoi(attemptsConfig)
+		if err != nil {
+			return policy, fmt.Errorf("retryPolicy: %w", err)
+		}
+		policy.MaxAttempts = max(attempts, 1)
+	}
+	backoffConfig, exists := os.LookupEnv("WRENDERER_RETRY_BACKOFF_IN_SECONDS")
+	if exists {
+		backoff, err := strconv.Atoi(backoffConfig)
+		if err != nil {
+			return policy, fmt.Errorf("retryPolicy: %w", err)
+		}
+		policy.Backoff = time.Duration(max(backoff, 0)) * time.Second
+	}
+	maxBackoffConfig, exists := os.LookupEnv("WRENDERER_RETRY_MAX_BACKOFF_IN_SECONDS")
+	if exists {
+		maxBackoff, err := strconv.Atoi(maxBackoffConfig)
+		if err != nil {
+			return policy, fmt.Errorf("retryPolicy: %w", err)
+		}
+		policy.MaxBackoff = time.Duration(max(maxBackoff, 0)) * time.Second
+	}
+	if policy.MaxBackoff == 0 || policy.MaxBackoff > 15*time.Minute {
+		policy.MaxBackoff = 15 * time.Minute
+	}
+
+	return policy, nil
+}
+
+// jobCaching returns the caching of the job cache objects of the job of jobKey in
+// category.
+func jobCaching(
+	ctx context.Context,
+	loader *shared.ConfLoader,
+	category, jobKey string,
+) wrender.S3Caching {
+	jobCache := wrender.NewSqsJobCache(jobKey, category, wrender.CachedJobPrefix)
+	return wrender.NewS3Caching(
+		loader.Clients.S3,
+		jobCache.KeyPath(),
+		"",
+		wrender.S3CachingMeta{
+			Bucket:      loader.EnvConf.S3BucketName,
+			Region:      loader.EnvConf.S3BucketRegion,
+			ContentType: wrender.PlainContentType,
+		},
+	).WithContext(ctx)
+}
+
+// jobCallbackRecord is the job cache object of a job callback, along with the
+// number of entries queued by the job.
+type jobCallbackRecord struct {
+	wrender.JobCallback
+	Entries int `json:"entries"`
+}
+
+// StartJobCallback starts the count of the done entries of the job of jobKey in
+// category, it is expected to be called before the entries of a job with
+// callback are queued.
+func StartJobCallback(
+	ctx context.Context,
+	loader *shared.ConfLoader,
+	category, jobKey string,
+) error {
+	return jobCaching(ctx, loader, category, jobKey).UpdateTo(strings.NewReader("0"), jobDoneFile)
+}
+
+// SaveJobCallback records the callback of the job of jobKey in category along
+// with the number of queued entries, it is expected to be called once every
+// entry of the job is queued since NotifyJob skips the jobs without callback.
+func SaveJobCallback(
+	ctx context.Context,
+	loader *shared.ConfLoader,
+	category, jobKey string,
+	callback wrender.JobCallback,
+	entries int,
+) error {
+	data, err := json.Marshal(jobCallbackRecord{JobCallback: callback, Entries: entries})
+	if err != nil {
+		return err
+	}
+	return jobCaching(ctx, loader, category, jobKey).UpdateTo(bytes.NewReader(data), jobCallbackFile)
+}
+
+// JobEntryDone counts a done entry of the job of jobKey in category, and notifies
+// the job callback through NotifyJob once the count reaches the number of queued
+// entries, or when the job is expired. The job entries are only listed then,
+// jobs without callback are skipped.
+func JobEntryDone(
+	ctx context.Context,
+	loader *shared.ConfLoader,
+	category, jobKey string,
+	logger *slog.Logger,
+) error {
+	caching := jobCaching(ctx, loader, category, jobKey)
+
+	done, err := countJobEntryDone(caching)
+	var werr *wrender.CacheNotFoundError
+	switch {
+	case errors.As(err, &werr):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	caching.CachedPath = filepath.Join(caching.CachedPrefix, jobCallbackFile)
+	content, err := caching.Read()
+	switch {
+	case errors.As(err, &werr):
+		// Still queueing, the job is checked once every entry is queued
+		return nil
+	case err != nil:
+		return err
+	}
+	var record jobCallbackRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		return err
+	}
+	if done < record.Entries {
+		expired, err := jobExpired(caching, loader)
+		if err != nil || !expired {
+			return err
+		}
+	}
+
+	return NotifyJob(ctx, loader, category, jobKey, logger)
+}
+
+// countJobEntryDone adds a done entry to the count of caching and returns the
+// count, the count is replaced only if no other worker changed it meanwhile.
+func countJobEntryDone(caching wrender.S3Caching) (int, error) {
+	caching.CachedPath = filepath.Join(caching.CachedPrefix, jobDoneFile)
+	for range jobDoneAttempts {
+		content, etag, err := caching.ReadTag()
+		if err != nil {
+			return 0, err
+		}
+		done, err := strconv.Atoi(string(content))
+		if err != nil {
+			return 0, err
+		}
+
+		done++
+		replaced, err := caching.ReplaceTo(strings.NewReader(strconv.Itoa(done)), jobDoneFile, etag)
+		if err != nil {
+			return 0, err
+		}
+		if replaced {
+			return done, nil
+		}
+	}
+
+	return 0, fmt.Errorf("count job entry done: too many concurrent updates")
+}
+
+// jobExpired reports whether the job of caching started longer than the job
+// expiration ago.
+func jobExpired(caching wrender.S3Caching, loader *shared.ConfLoader) (bool, error) {
+	started, err := jobStarted(caching)
+	if err != nil {
+		return false, err
+	}
+	expiration := time.Duration(loader.EnvConf.JobExpirationInHours) * time.Hour
+	return time.Since(started) > expiration, nil
+}
+
+// jobStarted reads the time the job of caching started.
+func jobStarted(caching wrender.S3Caching) (time.Time, error) {
+	caching.CachedPath = filepath.Join(caching.CachedPrefix, JobTimestampFile)
+	timestamp, err := caching.Read()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, string(timestamp))
+}
+
+// NotifyJob sends the summary of the job of jobKey in category to its callback if
+// the job has ended, that is when no entry is left queued or processing or when the job
+// is expired. The job entries are listed for the summary, the workers check the
+// job through JobEntryDone instead. Jobs without callback are skipped, and the
+// callback of a job is sent at most once, by the first caller seeing the job
+// ended.
+func NotifyJob(
+	ctx context.Context,
+	loader *shared.ConfLoader,
+	category, jobKey string,
+	logger *slog.Logger,
+) error {
+	caching := jobCaching(ctx, loader, category, jobKey)
+
+	caching.CachedPath = filepath.Join(caching.CachedPrefix, jobCallbackFile)
+	content, err := caching.Read()
+	var werr *wrender.CacheNotFoundError
+	switch {
+	case errors.As(err, &werr):
+		return nil
+	case err != nil:
+		return err
+	}
+	var record jobCallbackRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		return err
+	}
+	callback := record.JobCallback
+	if !callback.Enabled() {
+		return nil
+	}
+
+	// The entries of a notified job are not listed again
+	caching.CachedPath = filepath.Join(caching.CachedPrefix, jobNotifiedFile)
+	notified, err := caching.Exists()
+	if err != nil || notified {
+		return err
+	}
+
+	started, err := jobStarted(caching)
+	if err != nil {
+		return err
+	}
+	progress, _, err := JobProgress(caching, started)
+	if err != nil {
+		return err
+	}
+
+	expiration := time.Duration(loader.EnvConf.JobExpirationInHours) * time.Hour
+	status := internal.JobStatusCompleted
+	switch {
+	case time.Since(started) > expiration:
+		status = internal.JobStatusTimeout
+	case progress.Queued != 0 || progress.Processing != 0:
+		return nil
+	case progress.Failed != 0:
+		status = internal.JobStatusFailed
+	}
+
+	failed := []string{}
+	failedContents, err := caching.List(internal.JobStatusFailed)
+	if err != nil {
+		return err
+	}
+	for _, content := range failedContents {
+		var entryResult wrender.JobEntryResult
+		if err := json.Unmarshal(content.Content, &entryResult); err != nil {
+			return err
+		}
+		failed = append(failed, entryResult.TargetUrl)
+	}
+
+	// Only the first caller seeing the job ended sends the callback
+	created, err := caching.CreateTo(bytes.NewReader([]byte(status)), jobNotifiedFile)
+	if err != nil || !created {
+		return err
+	}
+
+	opts, err := CallbackOption()
+	if err != nil {
+		return err
+	}
+	summary := wrender.JobSummary{
+		JobId:    jobKey,
+		Category: category,
+		Status:   status,
+		Progress: progress,
+		Failed:   failed,
+	}
+	if err := callback.Send(ctx, summary, opts); err != nil {
+		return err
+	}
+	logger.Debug(
+		"Job callback sent",
+		slog.String("category", category),
+		slog.String("jobKey", jobKey),
+		slog.String("status", status),
+	)
+
+	return nil
+}
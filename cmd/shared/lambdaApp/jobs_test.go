@@ -0,0 +1,242 @@
+package lambdaApp
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/wrender"
+)
+
+// fakeS3 serves the GetObject, PutObject, DeleteObject and ListObjectsV2 requests
+// of a bucket in memory, with the If-Match and If-None-Match conditions of
+// PutObject.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+		f.list(w, r)
+		return
+	}
+	content, exists := f.objects[r.URL.Path]
+	etag := fmt.Sprintf("%q", md5Hex(content))
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(content)
+	case http.MethodPut:
+		if match := r.Header.Get("If-Match"); match != "" && (!exists || match != etag) {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = body
+		f.modified[r.URL.Path] = time.Now().UTC()
+		w.Header().Set("ETag", fmt.Sprintf("%q", md5Hex(body)))
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		delete(f.modified, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// list answers a ListObjectsV2 request of the objects under the prefix of the
+// request after its start-after key, in a single page.
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	bucket := "/" + strings.Trim(r.URL.Path, "/") + "/"
+	prefix := r.URL.Query().Get("prefix")
+	startAfter := r.URL.Query().Get("start-after")
+
+	var keys []string
+	for path := range f.objects {
+		key, ok := strings.CutPrefix(path, bucket)
+		if ok && strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, "<ListBucketResult><IsTruncated>false</IsTruncated>")
+	for _, key := range keys {
+		fmt.Fprintf(
+			w,
+			"<Contents><Key>%s</Key><LastModified>%s</LastModified></Contents>",
+			key,
+			f.modified[bucket+key].Format(time.RFC3339Nano),
+		)
+	}
+	fmt.Fprintf(w, "<KeyCount>%d</KeyCount></ListBucketResult>", len(keys))
+}
+
+func md5Hex(content []byte) string {
+	sum := md5.Sum(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// newFakeS3Caching returns a caching of prefix on a fake bucket.
+func newFakeS3Caching(t *testing.T, prefix string) wrender.S3Caching {
+	t.Helper()
+
+	server := httptest.NewServer(&fakeS3{
+		objects:  map[string][]byte{},
+		modified: map[string]time.Time{},
+	})
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+
+	return wrender.NewS3Caching(
+		client,
+		prefix,
+		"",
+		wrender.S3CachingMeta{Bucket: "bucket", ContentType: wrender.PlainContentType},
+	)
+}
+
+func TestCountJobEntryDone(t *testing.T) {
+	caching := newFakeS3Caching(t, "jobs/sitemap/abcdef-ghijkl")
+
+	if _, err := countJobEntryDone(caching); err == nil {
+		t.Fatal("countJobEntryDone() of a job without count succeeded")
+	}
+	if err := caching.UpdateTo(strings.NewReader("0"), jobDoneFile); err != nil {
+		t.Fatalf("UpdateTo() error: %v", err)
+	}
+
+	const workers = 8
+	counts := make(chan int, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := countJobEntryDone(caching)
+			if err != nil {
+				t.Errorf("countJobEntryDone() error: %v", err)
+				return
+			}
+			counts <- done
+		}()
+	}
+	wg.Wait()
+	close(counts)
+
+	// Each worker sees its own count, a single one sees the last entry done
+	seen := map[int]bool{}
+	for done := range counts {
+		if seen[done] {
+			t.Errorf("count %d returned twice", done)
+		}
+		seen[done] = true
+	}
+	for done := 1; done <= workers; done++ {
+		if !seen[done] {
+			t.Errorf("count %d not returned", done)
+		}
+	}
+}
+
+func TestJobProgress(t *testing.T) {
+	caching := newFakeS3Caching(t, "jobs/sitemap/abcdef-ghijkl")
+	started := time.Now().UTC().Add(-time.Minute)
+
+	// Entries of a job without a state object are not counted
+	progress, objects, err := JobProgress(caching, started)
+	if err != nil {
+		t.Fatalf("JobProgress() error: %v", err)
+	}
+	if progress.Total != 0 || len(objects) != 0 || progress.Finished != nil {
+		t.Errorf("JobProgress() of an empty job = %+v, %v", progress, objects)
+	}
+
+	states := map[string]string{
+		"1": internal.JobStatusSucceeded,
+		"2": internal.JobStatusFailed,
+		"3": internal.JobStatusSkipped,
+		"4": internal.JobStatusProcessing,
+		"5": internal.JobStatusQueued,
+	}
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		if err := caching.UpdateTo(strings.NewReader("{}"), filepath.Join(states[id], id)); err != nil {
+			t.Fatalf("UpdateTo() error: %v", err)
+		}
+	}
+	progress, objects, err = JobProgress(caching, started)
+	if err != nil {
+		t.Fatalf("JobProgress() error: %v", err)
+	}
+	want := wrender.JobProgress{
+		Total: 5, Queued: 1, Processing: 1, Succeeded: 1, Failed: 1, Skipped: 1, Started: started,
+	}
+	if !reflect.DeepEqual(progress, want) {
+		t.Errorf("JobProgress() = %+v, want %+v", progress, want)
+	}
+	if len(objects) != 5 {
+		t.Fatalf("JobProgress() returned %d objects, want 5", len(objects))
+	}
+	for _, object := range objects {
+		if states[filepath.Base(object.Path)] != object.State {
+			t.Errorf("object %s state = %s", object.Path, object.State)
+		}
+	}
+
+	// The job is finished once no entry is left to render
+	for _, id := range []string{"4", "5"} {
+		entryCaching := caching
+		if err := entryCaching.UpdateTo(strings.NewReader("{}"), filepath.Join(internal.JobStatusSucceeded, id)); err != nil {
+			t.Fatalf("UpdateTo() error: %v", err)
+		}
+		entryCaching.CachedPath = filepath.Join(caching.CachedPrefix, states[id], id)
+		if err := entryCaching.Delete(); err != nil {
+			t.Fatalf("Delete() error: %v", err)
+		}
+	}
+	progress, _, err = JobProgress(caching, started)
+	if err != nil {
+		t.Fatalf("JobProgress() error: %v", err)
+	}
+	if progress.Succeeded != 3 || progress.Queued != 0 || progress.Processing != 0 || progress.Finished == nil {
+		t.Errorf("JobProgress() of a done job = %+v", progress)
+	}
+}
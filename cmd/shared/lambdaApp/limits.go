@@ -0,0 +1,198 @@
+package lambdaApp
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/cmd/shared"
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/wrender"
+)
+
+const (
+	// CachedLimitPrefix is the prefix of the render slots claimed on the domains.
+	CachedLimitPrefix = "limits"
+	// domainSlotMaxWait is the longest wait for a claimed render slot to start,
+	// the render is queued again instead of waiting for a later slot.
+	domainSlotMaxWait = time.Second
+	// domainSlotClaims is the number of slots tried by a claim.
+	domainSlotClaims = 5
+	// domainLeasePrefix is the prefix of the render leases of a domain, under the
+	// domain slots.
+	domainLeasePrefix = "running"
+	// domainLeaseRequeueDelay is the delay before a render refused by the
+	// concurrency limit of its domain is tried again.
+	domainLeaseRequeueDelay = 10 * time.Second
+	// domainLeaseDefault is the lease of a render without deadline.
+	domainLeaseDefault = 15 * time.Minute
+)
+
+// DomainLease is a running render of a domain counted toward its concurrency
+// limit, released once the render ends.
+type DomainLease struct {
+	caching wrender.S3Caching
+}
+
+// Release ends the lease, a zero lease is ignored.
+func (l DomainLease) Release() error {
+	if l.caching.CachedPath == "" {
+		return nil
+	}
+	return l.caching.Delete()
+}
+
+// AcquireDomainLease takes one of the limits.MaxConcurrent render leases of
+// domain, shared by every worker instance through the bucket. A lease is an object
+// holding its expiration, the deadline of ctx, and the lease of a worker which
+// ended without releasing it is taken over once expired. If every lease is taken,
+// the delay before trying again is returned instead. A zero delay means the
+// render can start.
+func AcquireDomainLease(
+	ctx context.Context,
+	loader *shared.ConfLoader,
+	domain string,
+	limits internal.DomainLimits,
+) (DomainLease, time.Duration, error) {
+	if limits.MaxConcurrent <= 0 {
+		return DomainLease{}, 0, nil
+	}
+
+	expires, ok := ctx.Deadline()
+	if !ok {
+		expires = time.Now().Add(domainLeaseDefault)
+	}
+	caching := wrender.NewS3Caching(
+		loader.Clients.S3,
+		filepath.Join(CachedLimitPrefix, domain, domainLeasePrefix),
+		"",
+		wrender.S3CachingMeta{
+			Bucket:      loader.EnvConf.S3BucketName,
+			Region:      loader.EnvConf.S3BucketRegion,
+			ContentType: wrender.PlainContentType,
+		},
+	).WithContext(ctx)
+
+	lease, ok, err := acquireDomainLease(caching, limits.MaxConcurrent, expires)
+	if err != nil || ok {
+		return lease, 0, err
+	}
+	return DomainLease{}, domainLeaseRequeueDelay, nil
+}
+
+// acquireDomainLease takes the first free or expired of the leases of caching,
+// false is returned if every lease is taken.
+func acquireDomainLease(
+	caching wrender.S3Caching,
+	leases int,
+	expires time.Time,
+) (DomainLease, bool, error) {
+	expiration := expires.UTC().Format(time.RFC3339Nano)
+	for i := range leases {
+		suffixPath := strconv.Itoa(i)
+		created, err := caching.CreateTo(strings.NewReader(expiration), suffixPath)
+		if err != nil {
+			return DomainLease{}, false, err
+		}
+		if !created {
+			created, err = takeExpiredLease(caching, suffixPath, expiration)
+			if err != nil {
+				return DomainLease{}, false, err
+			}
+		}
+		if created {
+			caching.CachedPath = filepath.Join(caching.CachedPrefix, suffixPath)
+			return DomainLease{caching: caching}, true, nil
+		}
+	}
+
+	return DomainLease{}, false, nil
+}
+
+// takeExpiredLease replaces the lease at suffixPath with expiration if it is
+// expired, unless another worker replaced it first.
+func takeExpiredLease(caching wrender.S3Caching, suffixPath, expiration string) (bool, error) {
+	caching.CachedPath = filepath.Join(caching.CachedPrefix, suffixPath)
+	content, etag, err := caching.ReadTag()
+	var werr *wrender.CacheNotFoundError
+	switch {
+	case errors.As(err, &werr):
+		// Released meanwhile, left for the next claim
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	leaseExpires, err := time.Parse(time.RFC3339Nano, string(content))
+	if err == nil && time.Now().Before(leaseExpires) {
+		return false, nil
+	}
+	return caching.ReplaceTo(strings.NewReader(expiration), suffixPath, etag)
+}
+
+// ClaimDomainSlot claims a start time for a render of domain, shared by every
+// worker instance through the bucket. The starts of a domain are spaced by
+// limits.Spacing(), each start being a slot object created only once. The claim
+// waits for one of the next domainSlotClaims slots starting within
+// domainSlotMaxWait, if they are taken the delay before trying again is returned
+// instead. A zero delay means the render can start.
+func ClaimDomainSlot(
+	ctx context.Context,
+	loader *shared.ConfLoader,
+	domain string,
+	limits internal.DomainLimits,
+) (time.Duration, error) {
+	spacing := limits.Spacing()
+	if spacing <= 0 {
+		return 0, nil
+	}
+
+	caching := wrender.NewS3Caching(
+		loader.Clients.S3,
+		filepath.Join(CachedLimitPrefix, domain),
+		"",
+		wrender.S3CachingMeta{
+			Bucket:      loader.EnvConf.S3BucketName,
+			Region:      loader.EnvConf.S3BucketRegion,
+			ContentType: wrender.PlainContentType,
+		},
+	).WithContext(ctx)
+
+	now := time.Now()
+	first := now.UnixNano() / int64(spacing)
+	for slot := first; slot < first+domainSlotClaims; slot++ {
+		start := time.Unix(0, slot*int64(spacing))
+		wait := start.Sub(now)
+		if wait > domainSlotMaxWait {
+			break
+		}
+
+		created, err := caching.CreateTo(
+			strings.NewReader(now.UTC().Format(time.RFC3339Nano)),
+			strconv.FormatInt(slot, 10),
+		)
+		if err != nil {
+			return 0, err
+		}
+		if !created {
+			continue
+		}
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return 0, ctx.Err()
+			}
+		}
+		return 0, nil
+	}
+
+	// SQS delays messages by whole seconds
+	return max(spacing.Round(time.Second), time.Second), nil
+}
@@ -0,0 +1,51 @@
+package lambdaApp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireDomainLease(t *testing.T) {
+	caching := newFakeS3Caching(t, "limits/a.com/running")
+	expires := time.Now().Add(time.Minute)
+
+	first, ok, err := acquireDomainLease(caching, 2, expires)
+	if err != nil || !ok {
+		t.Fatalf("first acquireDomainLease() = (%v, %v), want a lease", ok, err)
+	}
+	if _, ok, err := acquireDomainLease(caching, 2, expires); err != nil || !ok {
+		t.Fatalf("second acquireDomainLease() = (%v, %v), want a lease", ok, err)
+	}
+	if _, ok, err := acquireDomainLease(caching, 2, expires); err != nil || ok {
+		t.Fatalf("acquireDomainLease() over limit = (%v, %v), want no lease", ok, err)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release() error: %v", err)
+	}
+	if _, ok, err := acquireDomainLease(caching, 2, expires); err != nil || !ok {
+		t.Errorf("acquireDomainLease() after release = (%v, %v), want a lease", ok, err)
+	}
+	if err := (DomainLease{}).Release(); err != nil {
+		t.Errorf("Release() of a zero lease error: %v", err)
+	}
+}
+
+func TestAcquireDomainLeaseExpired(t *testing.T) {
+	caching := newFakeS3Caching(t, "limits/a.com/running")
+
+	// A lease left by a worker which stopped before releasing it
+	expired := time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano)
+	if err := caching.UpdateTo(strings.NewReader(expired), "0"); err != nil {
+		t.Fatalf("UpdateTo() error: %v", err)
+	}
+
+	expires := time.Now().Add(time.Minute)
+	if _, ok, err := acquireDomainLease(caching, 1, expires); err != nil || !ok {
+		t.Fatalf("acquireDomainLease() of an expired lease = (%v, %v), want a lease", ok, err)
+	}
+	if _, ok, err := acquireDomainLease(caching, 1, expires); err != nil || ok {
+		t.Errorf("acquireDomainLease() of a taken over lease = (%v, %v), want no lease", ok, err)
+	}
+}
@@ -1,6 +1,9 @@
 package localEnv
 
 import (
+	"strings"
+
+	"github.com/liuminhaw/wrenderer/postprocess"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
@@ -12,12 +15,15 @@ const (
 	serverDefaultTLS      = false
 	serverDefaultCertFile = "tls/cert.pem"
 	serverDefaultKeyFile  = "tls/key.pem"
+	serverDefaultShutdown = 30
 
 	cacheDefaultEnabled         = true
 	cacheDefaultType            = "boltdb"
 	cacheDefaultPath            = "cache.db"
 	cacheDefaultDuration        = 60
 	cacheDefaultCleanupInterval = 60
+	cacheDefaultErrorPolicy     = "cache"
+	cacheDefaultErrorDuration   = 5
 
 	rendererDefaultWindowWidth  = 1920
 	rendererDefaultWindowHeight = 1080
@@ -26,12 +32,39 @@ const (
 	rendererDefaultUserAgent    = ""
 	rendererDefaultTimeout      = 30
 	rendererDefaultIdleType     = "auto"
+	rendererDefaultRedirectMode = "follow"
+
+	queueDefaultCapacity   = 3
+	queueDefaultWorkers    = 3
+	queueDefaultMaxWait    = 5
+	queueDefaultRetryAfter = 5
+	queueDefaultReserved   = 1
+
+	semaphoreDefaultCapacity       = 5
+	semaphoreDefaultJobTimeout     = 60
+	semaphoreDefaultJobConcurrency = 2
+
+	poolDefaultMaxRenders          = 100
+	poolDefaultMaxMemory           = 1024
+	poolDefaultHealthCheckInterval = 30
 
-	queueDefaultCapacity = 3
-	queueDefaultWorkers  = 3
+	domainLimitsDefaultMaxConcurrent = 0
+	domainLimitsDefaultRate          = 0
+	domainLimitsDefaultDelay         = 0
 
-	semaphoreDefaultCapacity   = 5
-	semaphoreDefaultJobTimeout = 60
+	sitemapDefaultMaxDepth = 3
+	sitemapDefaultMaxUrls  = 50000
+
+	crawlDefaultMaxDepth = 3
+	crawlDefaultMaxPages = 1000
+
+	callbackDefaultMaxAttempts = 5
+	callbackDefaultBackoff     = 1
+	callbackDefaultTimeout     = 10
+
+	retryDefaultMaxAttempts = 3
+	retryDefaultBackoff     = 5
+	retryDefaultMaxBackoff  = 60
 )
 
 func InitConfig() *viper.Viper {
@@ -55,6 +88,14 @@ func ConfigSetup(config *viper.Viper) error {
 	configureRenderer(config)
 	configureQueue(config)
 	configureSemaphore(config)
+	configurePool(config)
+	configureDomainLimits(config)
+	configureSitemap(config)
+	configureCrawl(config)
+	configureCallback(config)
+	configureRetry(config)
+	configureWarmup(config)
+	configurePostprocess(config)
 
 	return nil
 }
@@ -66,6 +107,11 @@ func configureApp(config *viper.Viper) {
 	config.SetDefault("app.tls", serverDefaultTLS)
 	config.SetDefault("app.tlsCert", serverDefaultCertFile)
 	config.SetDefault("app.tlsKey", serverDefaultKeyFile)
+	config.SetDefault("app.shutdownTimeoutInSeconds", serverDefaultShutdown)
+
+	if config.GetInt("app.shutdownTimeoutInSeconds") <= 0 {
+		config.Set("app.shutdownTimeoutInSeconds", serverDefaultShutdown)
+	}
 }
 
 func configureCache(config *viper.Viper) {
@@ -75,6 +121,8 @@ func configureCache(config *viper.Viper) {
 	config.SetDefault("cache.path", cacheDefaultPath)
 	config.SetDefault("cache.durationInMinutes", cacheDefaultDuration)
 	config.SetDefault("cache.cleanupIntervalInMinutes", cacheDefaultCleanupInterval)
+	config.SetDefault("cache.errorPolicy", cacheDefaultErrorPolicy)
+	config.SetDefault("cache.errorDurationInMinutes", cacheDefaultErrorDuration)
 
 	config.Set("cache.enabled", config.GetBool("cache.enabled"))
 	config.Set("cache.type", cacheDefaultType)
@@ -84,6 +132,13 @@ func configureCache(config *viper.Viper) {
 	if config.GetInt("cache.cleanupIntervalInMinutes") <= 0 {
 		config.Set("cache.cleanupIntervalInMinutes", cacheDefaultCleanupInterval)
 	}
+	errorPolicy := config.GetString("cache.errorPolicy")
+	if errorPolicy != "cache" && errorPolicy != "skip" && errorPolicy != "short" {
+		config.Set("cache.errorPolicy", cacheDefaultErrorPolicy)
+	}
+	if config.GetInt("cache.errorDurationInMinutes") <= 0 {
+		config.Set("cache.errorDurationInMinutes", cacheDefaultErrorDuration)
+	}
 }
 
 func configureRenderer(config *viper.Viper) {
@@ -94,6 +149,8 @@ func configureRenderer(config *viper.Viper) {
 	config.SetDefault("renderer.userAgent", rendererDefaultUserAgent)
 	config.SetDefault("renderer.timeout", rendererDefaultTimeout)
 	config.SetDefault("renderer.idleType", rendererDefaultIdleType)
+	config.SetDefault("renderer.redirectMode", rendererDefaultRedirectMode)
+	config.SetDefault("renderer.remoteEndpoints", []string{})
 
 	config.Set("renderer.container", config.GetBool("renderer.container"))
 	config.Set("renderer.headless", config.GetBool("renderer.headless"))
@@ -110,11 +167,28 @@ func configureRenderer(config *viper.Viper) {
 	if idleType != "auto" && idleType != "networkIdle" && idleType != "InteractiveTime" {
 		config.Set("renderer.idleType", rendererDefaultIdleType)
 	}
+	redirectMode := config.GetString("renderer.redirectMode")
+	if redirectMode != "follow" && redirectMode != "respond" {
+		config.Set("renderer.redirectMode", rendererDefaultRedirectMode)
+	}
+
+	// Drop empty endpoints
+	endpoints := []string{}
+	for _, endpoint := range config.GetStringSlice("renderer.remoteEndpoints") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	config.Set("renderer.remoteEndpoints", endpoints)
 }
 
 func configureQueue(config *viper.Viper) {
 	config.SetDefault("queue.capacity", queueDefaultCapacity)
 	config.SetDefault("queue.workers", queueDefaultWorkers)
+	config.SetDefault("queue.maxWaitInSeconds", queueDefaultMaxWait)
+	config.SetDefault("queue.retryAfterInSeconds", queueDefaultRetryAfter)
+	config.SetDefault("queue.reservedInteractiveWorkers", queueDefaultReserved)
+	config.SetDefault("queue.domainWeights", []string{})
 
 	if config.GetInt("queue.capacity") <= 0 {
 		config.Set("queue.capacity", queueDefaultCapacity)
@@ -122,11 +196,27 @@ func configureQueue(config *viper.Viper) {
 	if config.GetInt("queue.workers") <= 0 {
 		config.Set("queue.workers", queueDefaultWorkers)
 	}
+	// Zero max wait rejects right away when the queue is full
+	if config.GetInt("queue.maxWaitInSeconds") < 0 {
+		config.Set("queue.maxWaitInSeconds", queueDefaultMaxWait)
+	}
+	if config.GetInt("queue.retryAfterInSeconds") <= 0 {
+		config.Set("queue.retryAfterInSeconds", queueDefaultRetryAfter)
+	}
+	// At least one worker is left for refresh and bulk renders
+	reserved := config.GetInt("queue.reservedInteractiveWorkers")
+	if reserved < 0 || reserved >= config.GetInt("queue.workers") {
+		config.Set(
+			"queue.reservedInteractiveWorkers",
+			min(queueDefaultReserved, config.GetInt("queue.workers")-1),
+		)
+	}
 }
 
 func configureSemaphore(config *viper.Viper) {
 	config.SetDefault("semaphore.capacity", semaphoreDefaultCapacity)
 	config.SetDefault("semaphore.jobTimeoutInMinutes", semaphoreDefaultJobTimeout)
+	config.SetDefault("semaphore.jobConcurrency", semaphoreDefaultJobConcurrency)
 
 	if config.GetInt("semaphore.capacity") <= 0 {
 		config.Set("semaphore.capacity", semaphoreDefaultCapacity)
@@ -134,4 +224,115 @@ func configureSemaphore(config *viper.Viper) {
 	if config.GetInt("semaphore.jobTimeoutInMinutes") <= 0 {
 		config.Set("semaphore.jobTimeoutInMinutes", semaphoreDefaultJobTimeout)
 	}
+	if config.GetInt("semaphore.jobConcurrency") <= 0 {
+		config.Set("semaphore.jobConcurrency", semaphoreDefaultJobConcurrency)
+	}
+}
+
+func configurePool(config *viper.Viper) {
+	config.SetDefault("pool.maxRenders", poolDefaultMaxRenders)
+	config.SetDefault("pool.maxMemoryInMB", poolDefaultMaxMemory)
+	config.SetDefault("pool.healthCheckIntervalInSeconds", poolDefaultHealthCheckInterval)
+
+	// Zero disables the renders and memory limits
+	if config.GetInt("pool.maxRenders") < 0 {
+		config.Set("pool.maxRenders", poolDefaultMaxRenders)
+	}
+	if config.GetInt("pool.maxMemoryInMB") < 0 {
+		config.Set("pool.maxMemoryInMB", poolDefaultMaxMemory)
+	}
+	if config.GetInt("pool.healthCheckIntervalInSeconds") <= 0 {
+		config.Set("pool.healthCheckIntervalInSeconds", poolDefaultHealthCheckInterval)
+	}
+}
+
+func configureDomainLimits(config *viper.Viper) {
+	config.SetDefault("domainLimits.maxConcurrentRenders", domainLimitsDefaultMaxConcurrent)
+	config.SetDefault("domainLimits.rendersPerSecond", domainLimitsDefaultRate)
+	config.SetDefault("domainLimits.delayInMilliseconds", domainLimitsDefaultDelay)
+
+	// Zero disables the limit
+	if config.GetInt("domainLimits.maxConcurrentRenders") < 0 {
+		config.Set("domainLimits.maxConcurrentRenders", domainLimitsDefaultMaxConcurrent)
+	}
+	if config.GetFloat64("domainLimits.rendersPerSecond") < 0 {
+		config.Set("domainLimits.rendersPerSecond", domainLimitsDefaultRate)
+	}
+	if config.GetInt("domainLimits.delayInMilliseconds") < 0 {
+		config.Set("domainLimits.delayInMilliseconds", domainLimitsDefaultDelay)
+	}
+}
+
+func configureSitemap(config *viper.Viper) {
+	config.SetDefault("sitemap.maxDepth", sitemapDefaultMaxDepth)
+	config.SetDefault("sitemap.maxUrls", sitemapDefaultMaxUrls)
+
+	// Zero disables the limit
+	if config.GetInt("sitemap.maxDepth") < 0 {
+		config.Set("sitemap.maxDepth", sitemapDefaultMaxDepth)
+	}
+	if config.GetInt("sitemap.maxUrls") < 0 {
+		config.Set("sitemap.maxUrls", sitemapDefaultMaxUrls)
+	}
+}
+
+func configureCrawl(config *viper.Viper) {
+	config.SetDefault("crawl.maxDepth", crawlDefaultMaxDepth)
+	config.SetDefault("crawl.maxPages", crawlDefaultMaxPages)
+
+	if config.GetInt("crawl.maxDepth") < 0 {
+		config.Set("crawl.maxDepth", crawlDefaultMaxDepth)
+	}
+	if config.GetInt("crawl.maxPages") <= 0 {
+		config.Set("crawl.maxPages", crawlDefaultMaxPages)
+	}
+}
+
+func configureCallback(config *viper.Viper) {
+	config.SetDefault("callback.maxAttempts", callbackDefaultMaxAttempts)
+	config.SetDefault("callback.backoffInSeconds", callbackDefaultBackoff)
+	config.SetDefault("callback.timeoutInSeconds", callbackDefaultTimeout)
+
+	if config.GetInt("callback.maxAttempts") <= 0 {
+		config.Set("callback.maxAttempts", callbackDefaultMaxAttempts)
+	}
+	if config.GetInt("callback.backoffInSeconds") < 0 {
+		config.Set("callback.backoffInSeconds", callbackDefaultBackoff)
+	}
+	if config.GetInt("callback.timeoutInSeconds") <= 0 {
+		config.Set("callback.timeoutInSeconds", callbackDefaultTimeout)
+	}
+}
+
+func configureRetry(config *viper.Viper) {
+	config.SetDefault("retry.maxAttempts", retryDefaultMaxAttempts)
+	config.SetDefault("retry.backoffInSeconds", retryDefaultBackoff)
+	config.SetDefault("retry.maxBackoffInSeconds", retryDefaultMaxBackoff)
+
+	if config.GetInt("retry.maxAttempts") <= 0 {
+		config.Set("retry.maxAttempts", retryDefaultMaxAttempts)
+	}
+	if config.GetInt("retry.backoffInSeconds") < 0 {
+		config.Set("retry.backoffInSeconds", retryDefaultBackoff)
+	}
+	if config.GetInt("retry.maxBackoffInSeconds") < 0 {
+		config.Set("retry.maxBackoffInSeconds", retryDefaultMaxBackoff)
+	}
+}
+
+func configureWarmup(config *viper.Viper) {
+	config.SetDefault("warmup.schedules", []map[string]any{})
+}
+
+func configurePostprocess(config *viper.Viper) {
+	config.SetDefault("postprocess.steps", []string{})
+
+	// Drop unsupported steps
+	steps := []string{}
+	for _, step := range config.GetStringSlice("postprocess.steps") {
+		if postprocess.Supported(step) {
+			steps = append(steps, step)
+		}
+	}
+	config.Set("postprocess.steps", steps)
 }
@@ -0,0 +1,33 @@
+package shared
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// ParsePage parses the page and pageSize query values of a paginated listing,
+// the first page and DefaultPageSize are used for empty values.
+func ParsePage(pageValue, pageSizeValue string) (int, int, error) {
+	page, pageSize := 1, DefaultPageSize
+	if pageValue != "" {
+		var err error
+		page, err = strconv.Atoi(pageValue)
+		if err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("invalid page %q", pageValue)
+		}
+	}
+	if pageSizeValue != "" {
+		var err error
+		pageSize, err = strconv.Atoi(pageSizeValue)
+		if err != nil || pageSize < 1 || pageSize > MaxPageSize {
+			return 0, 0, fmt.Errorf("invalid page size %q", pageSizeValue)
+		}
+	}
+
+	return page, pageSize, nil
+}
@@ -1,5 +1,58 @@
 package shared
 
+import (
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/wrender"
+)
+
+// CallbackPayload is the job callback of a request, notified once the job ends.
+type CallbackPayload struct {
+	CallbackUrl    string `json:"callbackUrl,omitempty"`
+	CallbackSecret string `json:"callbackSecret,omitempty"`
+}
+
+// JobCallback returns the job callback of the request.
+func (p CallbackPayload) JobCallback() wrender.JobCallback {
+	return wrender.NewJobCallback(p.CallbackUrl, p.CallbackSecret)
+}
+
 type RenderSitemapPayload struct {
 	SitemapUrl string `json:"sitemapUrl"`
+	Priority   string `json:"priority,omitempty"`
+	// Concurrency is the number of urls of the job rendered at a time
+	Concurrency int `json:"concurrency,omitempty"`
+	// Mode is full, incremental or missing-only, full renders every url
+	Mode string `json:"mode,omitempty"`
+	// SitemapFilter selects and orders the urls of the sitemap to render
+	internal.SitemapFilter
+	CallbackPayload
+}
+
+// RenderCrawlPayload starts a crawl from the seed urls, following the links of the
+// rendered pages.
+type RenderCrawlPayload struct {
+	Seeds    []string `json:"seeds"`
+	MaxDepth int      `json:"maxDepth,omitempty"`
+	MaxPages int      `json:"maxPages,omitempty"`
+	// UrlFilter selects the links followed
+	internal.UrlFilter
+	Priority    string `json:"priority,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
+	CallbackPayload
+}
+
+// WarmupPayload is a scheduled sitemap warmup, one of Cron or IntervalInMinutes
+// is required. Warmups have no job callback, CallbackPayload is only read to
+// reject it.
+type WarmupPayload struct {
+	Id                string `json:"id,omitempty"`
+	SitemapUrl        string `json:"sitemapUrl"`
+	Priority          string `json:"priority,omitempty"`
+	Concurrency       int    `json:"concurrency,omitempty"`
+	Mode              string `json:"mode,omitempty"`
+	Cron              string `json:"cron,omitempty"`
+	IntervalInMinutes int    `json:"intervalInMinutes,omitempty"`
+	Paused            bool   `json:"paused,omitempty"`
+	internal.SitemapFilter
+	CallbackPayload
 }
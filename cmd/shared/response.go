@@ -1,9 +1,14 @@
 package shared
 
+import "github.com/liuminhaw/wrenderer/wrender"
+
 type RenderStatusResp struct {
-	Status     string   `json:"status"`
-	Details    []string `json:"details,omitempty"`
-	StatusCode int      `json:"-"`
+	Status     string               `json:"status"`
+	Details    []string             `json:"details,omitempty"`
+	Reason     string               `json:"reason,omitempty"`
+	Progress   *wrender.JobProgress `json:"progress,omitempty"`
+	Results    *wrender.JobResults  `json:"results,omitempty"`
+	StatusCode int                  `json:"-"`
 }
 
 type RespErrorMessage struct {
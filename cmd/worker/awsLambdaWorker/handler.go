@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/liuminhaw/wrenderer/cmd/shared"
@@ -19,7 +22,7 @@ type handler struct {
 	logger *slog.Logger
 }
 
-func lambdaHandler(event events.SQSEvent) error {
+func lambdaHandler(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
 	h := handler{}
 
 	debugMode, exists := os.LookupEnv("WRENDERER_DEBUG_MODE")
@@ -34,99 +37,284 @@ func lambdaHandler(event events.SQSEvent) error {
 		h.logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
 	}
 
-	return h.sitemapHandler(event)
+	return h.sitemapHandler(ctx, event)
 }
 
-func (h *handler) sitemapHandler(event events.SQSEvent) error {
-	loader, err := shared.NewConfLoader(shared.S3Service)
+// sitemapHandler renders the job entries of the messages of event. The messages
+// which could not be processed are reported as batch item failures, for SQS to
+// deliver them again without the rest of the batch.
+func (h *handler) sitemapHandler(
+	ctx context.Context,
+	event events.SQSEvent,
+) (events.SQSEventResponse, error) {
+	var response events.SQSEventResponse
+
+	loader, err := shared.NewConfLoader(shared.S3Service, shared.SqsService)
 	if err != nil {
 		h.logger.Error(fmt.Sprintf("Failed to create confLoader: %v", err))
-		return err
+		return response, err
+	}
+	limits, err := lambdaApp.DomainLimits()
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("Failed to read domain limits: %v", err))
+		return response, err
+	}
+	policy, err := lambdaApp.RetryPolicy()
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("Failed to read retry policy: %v", err))
+		return response, err
 	}
 
 	for _, message := range event.Records {
-		var payload wrender.SqsJobPayload
-		if err := json.Unmarshal([]byte(message.Body), &payload); err != nil {
-			h.logger.Error(
-				"Failed to unmarshal message",
-				slog.String("id", message.MessageId),
-				slog.String("body", message.Body),
+		if err := h.processMessage(ctx, loader, limits, policy, message); err != nil {
+			response.BatchItemFailures = append(
+				response.BatchItemFailures,
+				events.SQSBatchItemFailure{ItemIdentifier: message.MessageId},
 			)
-			continue
 		}
+	}
 
-		h.logger.Debug(
-			fmt.Sprintf("Processing url: %s", payload.TargetUrl),
-			slog.String("cache key", payload.RandomKey),
+	return response, nil
+}
+
+// processMessage renders the job entry of message. The entry is queued again
+// with a delay while its domain is over limits, the concurrency limit counting
+// the render until it is done. A failed render is queued again
+// with a delay following policy while the failure is retryable, otherwise the
+// entry is moved to the failed state along with its attempts. The returned error
+// is set when the message is to be delivered again.
+func (h *handler) processMessage(
+	ctx context.Context,
+	loader *shared.ConfLoader,
+	limits internal.DomainLimits,
+	policy wrender.RetryPolicy,
+	message events.SQSMessage,
+) error {
+	var payload wrender.SqsJobPayload
+	if err := json.Unmarshal([]byte(message.Body), &payload); err != nil {
+		h.logger.Error(
+			"Failed to unmarshal message",
 			slog.String("id", message.MessageId),
+			slog.String("body", message.Body),
 		)
+		return nil
+	}
 
-		jobCache := wrender.NewSqsJobCache(
-			payload.RandomKey,
-			internal.SitemapCategory,
-			wrender.CachedJobPrefix,
-		)
-		// Tracing current caching state: start with queued
-		caching := wrender.NewS3Caching(
-			loader.Clients.S3,
-			jobCache.KeyPath(),
-			filepath.Join(jobCache.KeyPath(), internal.JobStatusQueued, message.MessageId),
-			wrender.S3CachingMeta{
-				Bucket:      loader.EnvConf.S3BucketName,
-				Region:      loader.EnvConf.S3BucketRegion,
-				ContentType: wrender.PlainContentType,
-			},
-		)
+	h.logger.Debug(
+		fmt.Sprintf("Processing url: %s", payload.TargetUrl),
+		slog.String("cache key", payload.RandomKey),
+		slog.String("id", message.MessageId),
+	)
+
+	category := payload.JobCategory()
+	jobCache := wrender.NewSqsJobCache(
+		payload.RandomKey,
+		category,
+		wrender.CachedJobPrefix,
+	)
+	// Tracing current caching state: start with queued
+	caching := wrender.NewS3Caching(
+		loader.Clients.S3,
+		jobCache.KeyPath(),
+		filepath.Join(jobCache.KeyPath(), internal.JobStatusQueued, message.MessageId),
+		wrender.S3CachingMeta{
+			Bucket:      loader.EnvConf.S3BucketName,
+			Region:      loader.EnvConf.S3BucketRegion,
+			ContentType: wrender.PlainContentType,
+		},
+	)
 
-		// move job cache from queued to process
-		suffixPath := filepath.Join(internal.JobStatusProcessing, message.MessageId)
-		if err := caching.UpdateTo(bytes.NewReader([]byte(message.Body)), suffixPath); err != nil {
+	// move job cache from queued to process
+	suffixPath := filepath.Join(internal.JobStatusProcessing, message.MessageId)
+	if err := caching.UpdateTo(bytes.NewReader([]byte(message.Body)), suffixPath); err != nil {
+		return h.workerError(message, err)
+	}
+	if err := caching.Delete(); err != nil {
+		return h.workerError(message, err)
+	}
+	// caching state update to processing
+	caching.CachedPath = filepath.Join(
+		jobCache.KeyPath(),
+		internal.JobStatusProcessing,
+		message.MessageId,
+	)
+
+	// render the target url within the limits of its domain
+	targetUrl, err := url.Parse(payload.TargetUrl)
+	if err != nil {
+		return h.workerError(message, err)
+	}
+	lease, delay, err := lambdaApp.AcquireDomainLease(ctx, loader, targetUrl.Hostname(), limits)
+	if err != nil {
+		return h.workerError(message, err)
+	}
+	defer func() { h.releaseDomainLease(lease, targetUrl.Hostname()) }()
+	if delay == 0 {
+		delay, err = lambdaApp.ClaimDomainSlot(ctx, loader, targetUrl.Hostname(), limits)
+		if err != nil {
 			return h.workerError(message, err)
 		}
-		if err := caching.Delete(); err != nil {
+	}
+	if delay > 0 {
+		if err := h.requeueJobEntry(loader, caching, payload, delay); err != nil {
 			return h.workerError(message, err)
 		}
-		// caching state update to processing
-		caching.CachedPath = filepath.Join(
-			jobCache.KeyPath(),
-			internal.JobStatusProcessing,
-			message.MessageId,
+		h.logger.Debug(
+			fmt.Sprintf("target url: %s over domain limits, requeued", payload.TargetUrl),
+			slog.String("cache key", payload.RandomKey),
+			slog.Duration("delay", delay),
 		)
+		return nil
+	}
+	start := time.Now()
+	rendered, err := lambdaApp.PrerenderUrl(ctx, payload.TargetUrl, h.logger)
+	duration := time.Since(start)
+	h.releaseDomainLease(lease, targetUrl.Hostname())
+	lease = lambdaApp.DomainLease{}
+	var statusCode int
+	if err == nil {
+		statusCode = rendered.StatusCode
+		err = wrender.RenderStatusErr(statusCode)
+	}
+	payload.Attempts = append(payload.Attempts, wrender.NewJobEntryAttempt(start, statusCode, err))
 
-		// render the target url
-		_, err = lambdaApp.RenderUrl(payload.TargetUrl, false, h.logger)
-		if err != nil {
-			// Move job cache from process to failure
-			suffixPath := filepath.Join(internal.JobStatusFailed, message.MessageId)
-			if err := caching.UpdateTo(bytes.NewReader([]byte(message.Body)), suffixPath); err != nil {
-				return h.workerError(message, err)
-			}
-			if err := caching.Delete(); err != nil {
-				return h.workerError(message, err)
-			} else {
-				h.logger.Debug(
-					fmt.Sprintf("Job cache %s deleted", caching.CachedPath),
-				)
-			}
-
+	if err != nil && policy.Retry(len(payload.Attempts), err) {
+		delay := policy.Delay(len(payload.Attempts))
+		if err := h.requeueJobEntry(loader, caching, payload, delay); err != nil {
 			return h.workerError(message, err)
 		}
+		h.logger.Info(
+			fmt.Sprintf("target url: %s failed, retrying", payload.TargetUrl),
+			slog.String("cache key", payload.RandomKey),
+			slog.Int("attempt", len(payload.Attempts)),
+			slog.Duration("backoff", delay),
+			slog.String("error", err.Error()),
+		)
+		return nil
+	}
 
-		// Clean job cache
-		if err := caching.Delete(); err != nil {
+	if err != nil {
+		// Move job cache from process to failure with the failure reason
+		result := wrender.NewJobEntryResult(
+			payload.TargetUrl,
+			internal.JobStatusFailed,
+			duration,
+			err,
+		)
+		result.StatusCode = statusCode
+		result.Attempts = payload.Attempts
+		if err := h.moveJobEntry(caching, internal.JobStatusFailed, message, result); err != nil {
 			return h.workerError(message, err)
-		} else {
-			h.logger.Debug(
-				fmt.Sprintf("Job cache %s deleted", caching.CachedPath),
-			)
 		}
+		h.notifyJob(ctx, loader, category, payload.RandomKey)
 
-		h.logger.Debug(
-			fmt.Sprintf("target url: %s processed", payload.TargetUrl),
-			slog.String("cache key", payload.RandomKey),
-			slog.String("id", message.MessageId),
+		// The failure is final, the message is not delivered again
+		h.workerError(message, err)
+		return nil
+	}
+
+	// Move job cache from process to succeeded with the render duration
+	result := wrender.NewJobEntryResult(
+		payload.TargetUrl,
+		internal.JobStatusSucceeded,
+		duration,
+		nil,
+	)
+	result.StatusCode = statusCode
+	result.Attempts = payload.Attempts
+	if err := h.moveJobEntry(caching, internal.JobStatusSucceeded, message, result); err != nil {
+		return h.workerError(message, err)
+	}
+	h.notifyJob(ctx, loader, category, payload.RandomKey)
+
+	h.logger.Debug(
+		fmt.Sprintf("target url: %s processed", payload.TargetUrl),
+		slog.String("cache key", payload.RandomKey),
+		slog.String("id", message.MessageId),
+	)
+
+	return nil
+}
+
+// releaseDomainLease releases the render lease of domain, a lease failed to be
+// released is only logged since it is taken over once expired.
+func (h *handler) releaseDomainLease(lease lambdaApp.DomainLease, domain string) {
+	if err := lease.Release(); err != nil {
+		h.logger.Error(
+			fmt.Sprintf("Failed to release domain lease: %v", err),
+			slog.String("domain", domain),
 		)
 	}
+}
+
+// requeueJobEntry queues the processing job entry of caching again with payload,
+// delivered after delay. The entry is queued before its processing job cache is
+// removed, for the job not to be seen as done in between.
+func (h *handler) requeueJobEntry(
+	loader *shared.ConfLoader,
+	caching wrender.S3Caching,
+	payload wrender.SqsJobPayload,
+	delay time.Duration,
+) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	queue := shared.Queue{
+		Client: loader.Clients.Sqs,
+		Url:    loader.EnvConf.SqsUrl,
+	}
+	messageId, err := queue.SendMessageWithDelay(string(data), delay)
+	if err != nil {
+		return err
+	}
+
+	suffixPath := filepath.Join(internal.JobStatusQueued, messageId)
+	if err := caching.UpdateTo(bytes.NewReader(data), suffixPath); err != nil {
+		return err
+	}
+	return caching.Delete()
+}
+
+// moveJobEntry moves the processing job cache of caching to the given state
+// with the entry result.
+func (h *handler) moveJobEntry(
+	caching wrender.S3Caching,
+	state string,
+	message events.SQSMessage,
+	result wrender.JobEntryResult,
+) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	suffixPath := filepath.Join(state, message.MessageId)
+	if err := caching.UpdateTo(bytes.NewReader(data), suffixPath); err != nil {
+		return err
+	}
+	if err := caching.Delete(); err != nil {
+		return err
+	}
+	h.logger.Debug(fmt.Sprintf("Job cache %s moved to %s", caching.CachedPath, state))
 
 	return nil
 }
+
+// notifyJob counts a done entry of the job of jobKey in category, the job callback
+// is notified once its last entry is done. The entry is done either way, a failed
+// notification is only logged for the message not to be delivered again.
+func (h *handler) notifyJob(
+	ctx context.Context,
+	loader *shared.ConfLoader,
+	category, jobKey string,
+) {
+	if err := lambdaApp.JobEntryDone(ctx, loader, category, jobKey, h.logger); err != nil {
+		h.logger.Error(
+			fmt.Sprintf("Failed to notify job: %v", err),
+			slog.String("category", category),
+			slog.String("cache key", jobKey),
+		)
+	}
+}
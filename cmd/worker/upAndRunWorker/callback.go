@@ -0,0 +1,53 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/liuminhaw/wrenderer/wrender"
+	"github.com/spf13/viper"
+)
+
+// notifyJob sends the summary of the ended job of queue to callback in the
+// background, if the job has a callback and its status in jobCache is notified.
+// The delivery failures are reported to the error channel.
+func (h *Handler) notifyJob(
+	config *viper.Viper,
+	callback wrender.JobCallback,
+	queue wrender.BoltJobQueue,
+	jobCache wrender.SitemapJobCache,
+) {
+	if !callback.Enabled() || !wrender.CallbackNotified(jobCache.Status) {
+		return
+	}
+
+	progress, err := queue.Progress(jobCache.Created, jobCache.Finished)
+	if err != nil {
+		err := HandlerError{source: "notifyJob worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+	summary := wrender.JobSummary{
+		JobId:    queue.Key,
+		Category: queue.Category,
+		Status:   jobCache.Status,
+		Reason:   jobCache.Reason,
+		Progress: progress,
+		Failed:   jobCache.Failed,
+	}
+
+	// The callback outlives the job, its context is done once the job ends
+	go func() {
+		if err := callback.Send(context.Background(), summary, CallbackOption(config)); err != nil {
+			err := HandlerError{source: "notifyJob worker", err: err}
+			h.ErrorChan <- &err
+			return
+		}
+		h.Logger.Debug(
+			"Job callback sent",
+			slog.String("category", queue.Category),
+			slog.String("jobKey", queue.Key),
+			slog.String("status", summary.Status),
+		)
+	}()
+}
@@ -0,0 +1,97 @@
+package upAndRunWorker
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/wrender"
+	"github.com/spf13/viper"
+)
+
+// newTestHandler returns a handler on a temporary bolt database.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "handler.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("open bolt db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &Handler{
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		DB:        db,
+		Jobs:      NewJobRegistry(),
+		ErrorChan: make(chan error, 1),
+	}
+}
+
+func TestResumedJobCallback(t *testing.T) {
+	signatures := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures <- r.Header.Get(wrender.CallbackSignatureHeader)
+	}))
+	defer server.Close()
+
+	h := newTestHandler(t)
+	config := viper.New()
+	config.Set("callback.maxAttempts", 1)
+
+	tests := []struct {
+		category string
+		job      any
+	}{
+		{
+			internal.SitemapCategory,
+			SitemapJob{
+				SitemapUrl:  "https://a.com/sitemap.xml",
+				JobCallback: wrender.NewJobCallback(server.URL, "secret"),
+			},
+		},
+		{
+			internal.CrawlCategory,
+			CrawlJob{
+				Seeds:       []string{"https://a.com/"},
+				JobCallback: wrender.NewJobCallback(server.URL, "secret"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.category, func(t *testing.T) {
+			queue := wrender.NewBoltJobQueue(h.DB, tt.category, "abcdef-ghijkl")
+			if err := queue.Create(tt.job, nil); err != nil {
+				t.Fatalf("Create() error: %v", err)
+			}
+
+			// The job parameters are read back as after a restart
+			run, err := h.resumedQueueJob(config, queue)
+			if err != nil {
+				t.Fatalf("resumedQueueJob() error: %v", err)
+			}
+			if run.callback.Url != server.URL || run.callback.Secret != "secret" {
+				t.Fatalf("resumed callback = %+v, want the submitted callback", run.callback)
+			}
+
+			jobCache := wrender.NewSitemapJobCache(internal.JobStatusCompleted, time.Minute)
+			h.notifyJob(config, run.callback, queue, jobCache)
+			select {
+			case signature := <-signatures:
+				if signature == "" {
+					t.Error("resumed job callback not signed")
+				}
+			case err := <-h.ErrorChan:
+				t.Fatalf("notifyJob() error: %v", err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("resumed job callback not sent")
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/metadata"
+	"github.com/liuminhaw/wrenderer/renderer"
+	"github.com/liuminhaw/wrenderer/wrender"
+	"github.com/spf13/viper"
+)
+
+// crawlSkippedExtensions are the extensions of the linked files which are not
+// pages, the links to them are not followed.
+var crawlSkippedExtensions = map[string]bool{
+	".avif": true, ".bmp": true, ".css": true, ".csv": true, ".doc": true,
+	".docx": true, ".gif": true, ".gz": true, ".ico": true, ".jpeg": true,
+	".jpg": true, ".js": true, ".json": true, ".mp3": true, ".mp4": true,
+	".pdf": true, ".png": true, ".ppt": true, ".pptx": true, ".svg": true,
+	".tar": true, ".txt": true, ".webm": true, ".webp": true, ".woff": true,
+	".woff2": true, ".xls": true, ".xlsx": true, ".xml": true, ".zip": true,
+}
+
+// CrawlJob is the parameters of a crawl job, persisted along with its queue for
+// the job to be resumed after a restart. The job renders the Seeds, then the
+// links of the rendered pages with the same origin as a seed, breadth first, up
+// to MaxDepth links away from the seeds and MaxPages pages in total. The
+// UrlFilter selects the links followed.
+type CrawlJob struct {
+	Seeds    []string `json:"seeds"`
+	MaxDepth int      `json:"maxDepth"`
+	MaxPages int      `json:"maxPages"`
+	internal.UrlFilter
+	// Priority is the scheduler priority class of the renders of the job
+	Priority string `json:"priority"`
+	// Concurrency is the number of urls of the job rendered at a time
+	Concurrency int `json:"concurrency"`
+	// JobCallback is notified once the job ends
+	wrender.JobCallback
+}
+
+// Normalize validates the parameters of the job and sets the defaults of the
+// parameters left empty. Crawl jobs render as bulk, and their depth, pages and
+// concurrency default to and are bounded by the configured ones. An
+// InvalidCrawlJobError is returned for invalid parameters.
+func (job *CrawlJob) Normalize(config *viper.Viper) error {
+	maxDepth := config.GetInt("crawl.maxDepth")
+	if job.MaxDepth == 0 {
+		job.MaxDepth = maxDepth
+	}
+	if job.MaxDepth < 0 || job.MaxDepth > maxDepth {
+		return &InvalidCrawlJobError{Param: "maxDepth"}
+	}
+
+	maxPages := config.GetInt("crawl.maxPages")
+	if job.MaxPages == 0 {
+		job.MaxPages = maxPages
+	}
+	if job.MaxPages < 0 || job.MaxPages > maxPages {
+		return &InvalidCrawlJobError{Param: "maxPages"}
+	}
+
+	if len(job.Seeds) == 0 || len(job.Seeds) > job.MaxPages {
+		return &InvalidCrawlJobError{Param: "seeds"}
+	}
+	if _, err := internal.UrlListEntries(job.Seeds); err != nil {
+		return &InvalidCrawlJobError{Param: "seeds"}
+	}
+
+	var ferr *internal.SitemapFilterError
+	if _, err := job.UrlFilter.Matcher(); errors.As(err, &ferr) {
+		return &InvalidCrawlJobError{Param: ferr.Param}
+	}
+
+	if job.Priority == "" {
+		job.Priority = PriorityBulk
+	}
+	if job.Priority != PriorityBulk && job.Priority != PriorityRefresh {
+		return &InvalidCrawlJobError{Param: "priority"}
+	}
+
+	maxConcurrency := config.GetInt("semaphore.jobConcurrency")
+	if job.Concurrency == 0 {
+		job.Concurrency = maxConcurrency
+	}
+	if job.Concurrency < 0 || job.Concurrency > maxConcurrency {
+		return &InvalidCrawlJobError{Param: "concurrency"}
+	}
+
+	if err := job.JobCallback.Validate(); err != nil {
+		return &InvalidCrawlJobError{Param: "callbackUrl"}
+	}
+
+	return nil
+}
+
+// SubmitCrawl starts job in the background if a job slot is free and returns the
+// key of the job, ErrSitemapJobsFull is returned otherwise. Crawl jobs share the
+// slots, timeout and registry of the sitemap jobs.
+func (h *Handler) SubmitCrawl(config *viper.Viper, job CrawlJob) (string, error) {
+	select {
+	case h.Semaphore <- struct{}{}:
+	default:
+		return "", ErrSitemapJobsFull
+	}
+
+	jobKey, err := wrender.RandomKey(sitemapJobKeyLength, sitemapJobKeyLength)
+	if err != nil {
+		<-h.Semaphore // release semaphore slot
+		return "", err
+	}
+
+	ctx := h.Jobs.Register(
+		internal.CrawlCategory,
+		jobKey,
+		config.GetDuration("semaphore.jobTimeoutInMinutes")*time.Minute,
+	)
+	go h.RenderCrawl(ctx, config, jobKey, job)
+
+	return jobKey, nil
+}
+
+// RenderCrawl queues the seeds of job in the persisted queue of jobKey and
+// renders the queue through the scheduler, queueing the links followed from the
+// rendered pages. The progress is recorded in the job cache of jobKey under the
+// crawl category. ctx is expected to be registered in h.Jobs under jobKey and is
+// released once the job ends.
+func (h *Handler) RenderCrawl(
+	ctx context.Context,
+	config *viper.Viper,
+	jobKey string,
+	job CrawlJob,
+) {
+	defer func() { <-h.Semaphore }() // release semaphore slot
+	defer h.Jobs.Done(internal.CrawlCategory, jobKey)
+
+	jobCaching, err := newJobCaching(h, internal.CrawlCategory, jobKey)
+	if err != nil {
+		err := HandlerError{source: "renderCrawl worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+
+	// The job cache is written first, the queues without job cache are pruned
+	ttl := config.GetDuration("semaphore.jobTimeoutInMinutes") * time.Minute
+	jobCache := wrender.NewSitemapJobCache(internal.JobStatusProcessing, ttl)
+	if err := jobCache.Update(jobCaching, internal.JobStatusProcessing); err != nil {
+		err := HandlerError{source: "renderCrawl worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+
+	queue := wrender.NewBoltJobQueue(h.DB, internal.CrawlCategory, jobKey)
+	if err := queue.Create(job, nil); err != nil {
+		err := HandlerError{source: "renderCrawl worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+	seeds := make([]wrender.QueuedEntry, 0, len(job.Seeds))
+	for _, seed := range job.Seeds {
+		seeds = append(seeds, wrender.QueuedEntry{TargetUrl: seed})
+	}
+	if _, err := queue.Enqueue(seeds, job.MaxPages); err != nil {
+		err := HandlerError{source: "renderCrawl worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+
+	run, err := h.crawlQueueJob(job, queue)
+	if err != nil {
+		err := HandlerError{source: "renderCrawl worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+	h.processJobQueue(ctx, config, run, queue, jobCaching, &jobCache)
+}
+
+// crawlQueueJob returns how the entries of the queue of the crawl job are
+// rendered, the links of each rendered page are queued to the job.
+func (h *Handler) crawlQueueJob(job CrawlJob, queue wrender.BoltJobQueue) (queueJob, error) {
+	match, err := job.UrlFilter.Matcher()
+	if err != nil {
+		return queueJob{}, err
+	}
+	origins := map[string]bool{}
+	for _, seed := range job.Seeds {
+		if seedUrl, err := url.Parse(seed); err == nil {
+			origins[seedUrl.Scheme+"://"+seedUrl.Host] = true
+		}
+	}
+
+	rendered := func(entry wrender.QueuedEntry, result *renderer.RenderResult) error {
+		if entry.Depth >= job.MaxDepth {
+			return nil
+		}
+
+		// Links are resolved against the page the entry was redirected to
+		pageUrl := entry.TargetUrl
+		if location := result.Redirects.Location(); location != "" {
+			pageUrl = location
+		}
+		meta, err := metadata.Extract(result.Content, pageUrl)
+		if err != nil {
+			return err
+		}
+
+		var links []wrender.QueuedEntry
+		for _, link := range meta.Links.Internal {
+			if !crawlableLink(link, origins) || !match(link) {
+				continue
+			}
+			links = append(links, wrender.QueuedEntry{TargetUrl: link, Depth: entry.Depth + 1})
+		}
+		_, err = queue.Enqueue(links, job.MaxPages)
+		return err
+	}
+
+	return queueJob{
+		priority:    job.Priority,
+		concurrency: job.Concurrency,
+		rendered:    rendered,
+		callback:    job.JobCallback,
+	}, nil
+}
+
+// crawlableLink reports whether link has one of the origins and is not a link to
+// a file which is not a page.
+func crawlableLink(link string, origins map[string]bool) bool {
+	linkUrl, err := url.Parse(link)
+	if err != nil || !origins[linkUrl.Scheme+"://"+linkUrl.Host] {
+		return false
+	}
+	return !crawlSkippedExtensions[strings.ToLower(path.Ext(linkUrl.Path))]
+}
@@ -0,0 +1,145 @@
+package upAndRunWorker
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/renderer"
+	"github.com/liuminhaw/wrenderer/wrender"
+	"github.com/spf13/viper"
+)
+
+func TestCrawlableLink(t *testing.T) {
+	origins := map[string]bool{"https://a.com": true}
+
+	tests := []struct {
+		link string
+		want bool
+	}{
+		{"https://a.com/page", true},
+		{"https://a.com/page.html", true},
+		{"https://a.com/", true},
+		{"http://a.com/page", false},
+		{"https://b.com/page", false},
+		{"https://a.com:8443/page", false},
+		{"https://a.com/report.PDF", false},
+		{"https://a.com/logo.png?v=1", false},
+		{"https://a.com/%zz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+			if got := crawlableLink(tt.link, origins); got != tt.want {
+				t.Errorf("crawlableLink(%q) = %v, want %v", tt.link, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCrawlJobNormalize(t *testing.T) {
+	config := viper.New()
+	config.Set("crawl.maxDepth", 3)
+	config.Set("crawl.maxPages", 10)
+	config.Set("semaphore.jobConcurrency", 4)
+
+	job := CrawlJob{Seeds: []string{"https://a.com/"}}
+	if err := job.Normalize(config); err != nil {
+		t.Fatalf("Normalize() error: %v", err)
+	}
+	if job.MaxDepth != 3 || job.MaxPages != 10 || job.Concurrency != 4 || job.Priority != PriorityBulk {
+		t.Errorf("Normalize() defaults = %+v", job)
+	}
+
+	tests := []struct {
+		name  string
+		job   CrawlJob
+		param string
+	}{
+		{"no seed", CrawlJob{}, "seeds"},
+		{"invalid seed", CrawlJob{Seeds: []string{"a.com"}}, "seeds"},
+		{"seeds over max pages", CrawlJob{Seeds: []string{"https://a.com/1", "https://a.com/2"}, MaxPages: 1}, "seeds"},
+		{"depth over max", CrawlJob{Seeds: []string{"https://a.com/"}, MaxDepth: 4}, "maxDepth"},
+		{"pages over max", CrawlJob{Seeds: []string{"https://a.com/"}, MaxPages: 11}, "maxPages"},
+		{"priority", CrawlJob{Seeds: []string{"https://a.com/"}, Priority: PriorityInteractive}, "priority"},
+		{"concurrency", CrawlJob{Seeds: []string{"https://a.com/"}, Concurrency: 5}, "concurrency"},
+		{"filter", CrawlJob{Seeds: []string{"https://a.com/"}, UrlFilter: internal.UrlFilter{Exclude: []string{"regex:("}}}, "exclude"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := tt.job
+			var cerr *InvalidCrawlJobError
+			if err := job.Normalize(config); !errors.As(err, &cerr) || cerr.Param != tt.param {
+				t.Errorf("Normalize() error = %v, want InvalidCrawlJobError on %s", err, tt.param)
+			}
+		})
+	}
+}
+
+func TestCrawlQueueJobLinks(t *testing.T) {
+	h := newTestHandler(t)
+	job := CrawlJob{
+		Seeds:     []string{"https://a.com/"},
+		MaxDepth:  2,
+		MaxPages:  4,
+		UrlFilter: internal.UrlFilter{Exclude: []string{"/private/**"}},
+	}
+	queue := wrender.NewBoltJobQueue(h.DB, internal.CrawlCategory, "abcdef-ghijkl")
+	if err := queue.Create(job, nil); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	if _, err := queue.Enqueue([]wrender.QueuedEntry{{TargetUrl: "https://a.com/"}}, job.MaxPages); err != nil {
+		t.Fatalf("Enqueue() error: %v", err)
+	}
+	seed, _, err := queue.Next()
+	if err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+
+	run, err := h.crawlQueueJob(job, queue)
+	if err != nil {
+		t.Fatalf("crawlQueueJob() error: %v", err)
+	}
+	result := &renderer.RenderResult{
+		StatusCode: 200,
+		Content: []byte(`<html><body>
+<a href="/1">1</a>
+<a href="/private/2">2</a>
+<a href="/3.pdf">3</a>
+<a href="https://b.com/4">4</a>
+<a href="/5">5</a>
+<a href="/6">6</a>
+<a href="/7">7</a>
+</body></html>`),
+	}
+	if err := run.rendered(seed, result); err != nil {
+		t.Fatalf("rendered() error: %v", err)
+	}
+
+	// The links followed are queued one link away, up to the max pages
+	var got []string
+	for {
+		entry, ok, err := queue.Next()
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		if !ok {
+			break
+		}
+		if entry.Depth != 1 {
+			t.Errorf("entry %s depth = %d, want 1", entry.TargetUrl, entry.Depth)
+		}
+		got = append(got, entry.TargetUrl)
+
+		// The links of the pages at the max depth are not followed
+		if err := run.rendered(wrender.QueuedEntry{TargetUrl: entry.TargetUrl, Depth: job.MaxDepth}, result); err != nil {
+			t.Fatalf("rendered() error: %v", err)
+		}
+	}
+	want := []string{"https://a.com/1", "https://a.com/5", "https://a.com/6"}
+	if !slices.Equal(got, want) {
+		t.Errorf("queued links = %v, want %v", got, want)
+	}
+}
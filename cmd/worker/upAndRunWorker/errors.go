@@ -8,5 +8,23 @@ type HandlerError struct {
 }
 
 func (h *HandlerError) Error() string {
-    return fmt.Sprintf("source: %s, err: %v", h.source, h.err)
+	return fmt.Sprintf("source: %s, err: %v", h.source, h.err)
+}
+
+// InvalidSitemapJobError reports an invalid parameter of a sitemap job.
+type InvalidSitemapJobError struct {
+	Param string
+}
+
+func (e *InvalidSitemapJobError) Error() string {
+	return fmt.Sprintf("invalid sitemap job %s", e.Param)
+}
+
+// InvalidCrawlJobError reports an invalid parameter of a crawl job.
+type InvalidCrawlJobError struct {
+	Param string
+}
+
+func (e *InvalidCrawlJobError) Error() string {
+	return fmt.Sprintf("invalid crawl job %s", e.Param)
 }
@@ -0,0 +1,124 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/liuminhaw/wrenderer/renderer"
+	"github.com/liuminhaw/wrenderer/wrender"
+)
+
+// inflightRender is a render in progress, result and err are set before done
+// is closed. The render is cancelled once all of its waiters are gone.
+type inflightRender struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	result  *renderer.RenderResult
+	err     error
+}
+
+// RenderGroup coalesces concurrent renders of the same page, callers asking for
+// a page which is already being rendered wait for and share the in-flight result
+// instead of rendering the page again.
+type RenderGroup struct {
+	mu        sync.Mutex
+	renders   map[string]*inflightRender
+	coalesced atomic.Int64
+}
+
+func NewRenderGroup() *RenderGroup {
+	return &RenderGroup{renders: make(map[string]*inflightRender)}
+}
+
+// Do runs render for the given key unless a render of the same key is already in
+// flight, in which case the result of the in-flight render is returned once it is
+// done. The returned bool reports whether the result is shared with another call.
+// If ctx is done before the render completes, ctx.Err() is returned, and the
+// context passed to render is cancelled when no caller is waiting anymore.
+func (g *RenderGroup) Do(
+	ctx context.Context,
+	key string,
+	render func(ctx context.Context) (*renderer.RenderResult, error),
+) (*renderer.RenderResult, bool, error) {
+	g.mu.Lock()
+	inflight, shared := g.renders[key]
+	if shared {
+		inflight.waiters++
+		g.coalesced.Add(1)
+	} else {
+		renderCtx, cancel := context.WithCancel(context.Background())
+		inflight = &inflightRender{done: make(chan struct{}), cancel: cancel, waiters: 1}
+		g.renders[key] = inflight
+		go g.run(renderCtx, key, inflight, render)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-inflight.done:
+		return inflight.result, shared, inflight.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		inflight.waiters--
+		if inflight.waiters == 0 {
+			// Later calls of the same key start a new render
+			inflight.cancel()
+			g.forget(key, inflight)
+		}
+		g.mu.Unlock()
+		return nil, shared, ctx.Err()
+	}
+}
+
+func (g *RenderGroup) run(
+	ctx context.Context,
+	key string,
+	inflight *inflightRender,
+	render func(ctx context.Context) (*renderer.RenderResult, error),
+) {
+	defer inflight.cancel()
+
+	inflight.result, inflight.err = render(ctx)
+
+	g.mu.Lock()
+	g.forget(key, inflight)
+	g.mu.Unlock()
+	close(inflight.done)
+}
+
+// forget removes the in-flight render of key if it is still the given one, g.mu
+// must be held.
+func (g *RenderGroup) forget(key string, inflight *inflightRender) {
+	if g.renders[key] == inflight {
+		delete(g.renders, key)
+	}
+}
+
+// Coalesced returns the number of calls which shared the result of an in-flight
+// render instead of rendering.
+func (g *RenderGroup) Coalesced() int64 {
+	return g.coalesced.Load()
+}
+
+// Inflight returns the number of renders currently in flight.
+func (g *RenderGroup) Inflight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return len(g.renders)
+}
+
+// RenderKey returns the key identifying the render of url in RenderGroup, which
+// is the page cache path of url. Renders capturing an archive are keyed apart
+// from html only renders.
+func RenderKey(url string, archive bool) (string, error) {
+	render, err := wrender.NewWrender(url, wrender.CachedPagePrefix)
+	if err != nil {
+		return "", err
+	}
+	if archive {
+		return render.CachePath + "#archive", nil
+	}
+	return render.CachePath, nil
+}
@@ -0,0 +1,113 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/renderer"
+)
+
+func TestRenderGroupCoalesce(t *testing.T) {
+	g := NewRenderGroup()
+	var renders atomic.Int32
+	release := make(chan struct{})
+	render := func(ctx context.Context) (*renderer.RenderResult, error) {
+		renders.Add(1)
+		<-release
+		return &renderer.RenderResult{StatusCode: 200}, nil
+	}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int32
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, shared, err := g.Do(context.Background(), "page", render)
+			if err != nil || result.StatusCode != 200 {
+				t.Errorf("Do() = (%v, %v), want the rendered result", result, err)
+			}
+			if shared {
+				sharedCount.Add(1)
+			}
+		}()
+	}
+	// Every caller waits on the render before it completes
+	for g.Coalesced() < callers-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if renders.Load() != 1 || sharedCount.Load() != callers-1 {
+		t.Errorf("renders, shared = %d, %d, want 1, %d", renders.Load(), sharedCount.Load(), callers-1)
+	}
+	if g.Inflight() != 0 {
+		t.Errorf("Inflight() = %d after the render, want 0", g.Inflight())
+	}
+
+	// A later call renders again
+	release = make(chan struct{})
+	close(release)
+	if _, shared, _ := g.Do(context.Background(), "page", render); shared || renders.Load() != 2 {
+		t.Errorf("Do() after the render shared = %v, renders = %d, want a new render", shared, renders.Load())
+	}
+}
+
+func TestRenderGroupCancel(t *testing.T) {
+	g := NewRenderGroup()
+	cancelled := make(chan struct{})
+	render := func(ctx context.Context) (*renderer.RenderResult, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { _, _, err := g.Do(first, "page", render); errs <- err }()
+	for g.Inflight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() { _, _, err := g.Do(second, "page", render); errs <- err }()
+	for g.Coalesced() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The render goes on while a caller is still waiting
+	cancelFirst()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() of the cancelled caller error = %v, want context.Canceled", err)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("render cancelled while a caller is waiting")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancelSecond()
+	<-errs
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("render not cancelled once no caller is waiting")
+	}
+}
+
+func TestRenderKey(t *testing.T) {
+	html, err := RenderKey("https://a.com/page", false)
+	if err != nil {
+		t.Fatalf("RenderKey() error: %v", err)
+	}
+	archive, _ := RenderKey("https://a.com/page", true)
+	other, _ := RenderKey("https://a.com/other", false)
+	if html == archive || html == other {
+		t.Errorf("RenderKey() = %s, archive %s, other page %s, want distinct keys", html, archive, other)
+	}
+}
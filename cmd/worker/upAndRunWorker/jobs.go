@@ -1,48 +1,173 @@
 package upAndRunWorker
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
-	"github.com/liuminhaw/renderer"
-	"github.com/liuminhaw/wrenderer/cmd/shared/localEnv"
+	"github.com/boltdb/bolt"
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/postprocess"
+	"github.com/liuminhaw/wrenderer/renderer"
 	"github.com/liuminhaw/wrenderer/wrender"
 	"github.com/spf13/viper"
 )
 
-func (h *Handler) StartWorkers(vConfig *viper.Viper) {
+// NewRenderScheduler creates the scheduler feeding the render workers from the
+// queue settings in config.
+func NewRenderScheduler(config *viper.Viper) *Scheduler {
+	return NewScheduler(SchedulerOption{
+		Capacity:      config.GetInt("queue.capacity"),
+		Workers:       config.GetInt("queue.workers"),
+		Reserved:      config.GetInt("queue.reservedInteractiveWorkers"),
+		DomainWeights: ParseDomainWeights(config.GetStringSlice("queue.domainWeights")),
+		DomainLimits:  DomainLimits(config),
+	})
+}
+
+// DomainLimits reads the per domain render limits from config.
+func DomainLimits(config *viper.Viper) internal.DomainLimits {
+	return internal.DomainLimits{
+		MaxConcurrent: config.GetInt("domainLimits.maxConcurrentRenders"),
+		RatePerSecond: config.GetFloat64("domainLimits.rendersPerSecond"),
+		Delay:         config.GetDuration("domainLimits.delayInMilliseconds") * time.Millisecond,
+	}
+}
+
+// SitemapOption reads the limits of the sitemap sources followed from config.
+func SitemapOption(config *viper.Viper) internal.SitemapOption {
+	return internal.SitemapOption{
+		MaxDepth: config.GetInt("sitemap.maxDepth"),
+		MaxUrls:  config.GetInt("sitemap.maxUrls"),
+	}
+}
+
+// CallbackOption reads the delivery policy of the job callbacks from config.
+func CallbackOption(config *viper.Viper) wrender.CallbackOption {
+	return wrender.CallbackOption{
+		MaxAttempts: config.GetInt("callback.maxAttempts"),
+		Backoff:     config.GetDuration("callback.backoffInSeconds") * time.Second,
+		Timeout:     config.GetDuration("callback.timeoutInSeconds") * time.Second,
+	}
+}
+
+// RetryPolicy reads the retry policy of the failed renders of the jobs from
+// config.
+func RetryPolicy(config *viper.Viper) wrender.RetryPolicy {
+	return wrender.RetryPolicy{
+		MaxAttempts: config.GetInt("retry.maxAttempts"),
+		Backoff:     config.GetDuration("retry.backoffInSeconds") * time.Second,
+		MaxBackoff:  config.GetDuration("retry.maxBackoffInSeconds") * time.Second,
+	}
+}
+
+// StartWorkers starts the render workers and the error listener, the error
+// listener stops when ctx is done. The render workers are stopped by StopWorkers.
+// An error is returned if the post-processing steps in vConfig are not supported.
+func (h *Handler) StartWorkers(ctx context.Context, vConfig *viper.Viper) error {
 	workersCount := vConfig.GetInt("queue.workers")
 
-	// Render page worker
+	// Render page worker, the renderer option and the post-processing pipeline
+	// are built once for all workers
+	opts := rendererOption(vConfig)
+	pipeline, err := postprocess.NewPipeline(vConfig.GetStringSlice("postprocess.steps"))
+	if err != nil {
+		return err
+	}
+	h.workers = &sync.WaitGroup{}
 	for i := range workersCount {
-		go h.renderPage(vConfig, i)
+		h.workers.Add(1)
+		go h.renderPage(opts, pipeline, i)
 	}
 
 	// Error listening worker
-	go h.ErrorListener()
+	go h.ErrorListener(ctx)
+
+	return nil
 }
 
-func (h *Handler) renderPage(config *viper.Viper, id int) {
+// StopWorkers closes the scheduler and waits for the render workers to finish
+// their current render, ctx.Err() is returned if ctx is done first. Jobs still
+// queued are left unhandled.
+func (h *Handler) StopWorkers(ctx context.Context) error {
+	h.Scheduler.Close()
+	if h.workers == nil {
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		h.workers.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Handler) renderPage(
+	opts renderer.RendererOption,
+	pipeline *postprocess.Pipeline,
+	id int,
+) {
+	defer h.workers.Done()
+
 	h.Logger.Debug("Worker started", slog.Int("id", id))
-	for job := range h.RenderQueue {
-		h.Logger.Debug("Worker start rendering", slog.String("url", job.Url), slog.Int("id", id))
-		render := renderer.NewRenderer(renderer.WithLogger(h.Logger))
-		content, err := render.RenderPage(job.Url, rendererOption(config))
+	for {
+		job, ok := h.Scheduler.Next()
+		if !ok {
+			h.Logger.Debug("Worker stopped", slog.Int("id", id))
+			return
+		}
+
+		if job.Context != nil && job.Context.Err() != nil {
+			h.Logger.Debug("Worker drop cancelled job", slog.String("url", job.Url), slog.Int("id", id))
+			job.Result <- RenderJobResult{Result: nil, Err: job.Context.Err()}
+			h.Scheduler.Done(job)
+			continue
+		}
+
+		h.Logger.Debug(
+			"Worker start rendering",
+			slog.String("url", job.Url),
+			slog.String("priority", job.Priority),
+			slog.Int("id", id),
+		)
+		ctx := job.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		result, err := renderUrl(ctx, h.Pool, opts, pipeline, job.Url, job.Archive)
 		if err != nil {
-			job.Result <- RenderJobResult{Content: nil, Err: err}
+			job.Result <- RenderJobResult{Result: nil, Err: err}
 		} else {
-			job.Result <- RenderJobResult{Content: content, Err: nil}
+			job.Result <- RenderJobResult{Result: result, Err: nil}
 		}
+		h.Scheduler.Done(job)
 	}
 }
 
-func (h *Handler) StartCacheCleaner(interval int) {
+// StartCacheCleaner removes the expired caches every interval minutes until ctx
+// is done.
+func (h *Handler) StartCacheCleaner(ctx context.Context, interval int) {
 	h.Logger.Debug("Cache cleaner started", slog.Int("interval", interval))
 	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			h.Logger.Debug("Cache cleaner stopped")
+			return
+		}
+
 		h.Logger.Debug("Cache cleaner triggered")
 		if err := h.cleanExpiredCache(); err != nil {
 			h.Logger.Error(fmt.Sprintf("Error cleaning cache: %s", err))
@@ -65,17 +190,37 @@ func (h *Handler) cleanExpiredCache() error {
 		return err
 	}
 
-	return nil
+	// Clean the queues of the removed job caches
+	return h.pruneJobQueues()
 }
 
-func rendererOption(config *viper.Viper) *renderer.RendererOption {
-	localEnv.ConfigSetup(config)
+// NewRendererPool creates the browser pool shared by the render workers and the
+// sitemap jobs, sized by the number of render workers in config. The pool
+// connects to the remote browser endpoints in config if any.
+func NewRendererPool(config *viper.Viper, logger *slog.Logger) *renderer.Pool {
+	opts := rendererOption(config)
+	return renderer.NewPool(
+		&opts,
+		renderer.PoolOption{
+			Size:                config.GetInt("queue.workers"),
+			MaxRenders:          config.GetInt("pool.maxRenders"),
+			MaxMemoryMB:         config.GetInt("pool.maxMemoryInMB"),
+			HealthCheckInterval: config.GetDuration("pool.healthCheckIntervalInSeconds") * time.Second,
+		},
+		logger,
+	)
+}
 
-	return &renderer.RendererOption{
+// rendererOption reads the renderer settings from config, which is expected to be
+// set up at startup. The option is built once for the render workers and copied
+// for each render.
+func rendererOption(config *viper.Viper) renderer.RendererOption {
+	return renderer.RendererOption{
 		BrowserOpts: renderer.BrowserConf{
-			IdleType:      config.GetString("renderer.idleType"),
-			Container:     config.GetBool("renderer.container"),
-			ChromiumDebug: config.GetBool("chromiumDebug"),
+			IdleType:        config.GetString("renderer.idleType"),
+			Container:       config.GetBool("renderer.container"),
+			ChromiumDebug:   config.GetBool("chromiumDebug"),
+			RemoteEndpoints: config.GetStringSlice("renderer.remoteEndpoints"),
 		},
 		Headless:     config.GetBool("renderer.headless"),
 		WindowWidth:  config.GetInt("renderer.windowWidth"),
@@ -84,3 +229,116 @@ func rendererOption(config *viper.Viper) *renderer.RendererOption {
 		UserAgent:    config.GetString("renderer.userAgent"),
 	}
 }
+
+// renderUrl renders the given url with opts and applies the post-processing
+// pipeline on the rendered content. An MHTML snapshot of the page is
+// captured into the result if archive is set. The render is abandoned if ctx is
+// done.
+func renderUrl(
+	ctx context.Context,
+	render renderer.PageRenderer,
+	opts renderer.RendererOption,
+	pipeline *postprocess.Pipeline,
+	url string,
+	archive bool,
+) (*renderer.RenderResult, error) {
+	opts.Archive = archive
+	result, err := render.RenderPage(ctx, url, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pageUrl := url
+	if location := result.Redirects.Location(); location != "" {
+		pageUrl = location
+	}
+	result.Content, err = pipeline.Process(result.Content, pageUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// CachePage saves the rendered result of url into the page cache. Error pages are
+// cached following the cache error policy in config. With the follow redirect
+// mode, a redirected page is also cached under the final url of the redirects
+// with the same redirect chain. Nothing is cached and ctx.Err() is returned if ctx is done.
+func CachePage(
+	ctx context.Context,
+	db *bolt.DB,
+	config *viper.Viper,
+	url string,
+	result *renderer.RenderResult,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ttl, ok := PageCacheTtl(config, result.StatusCode)
+	if !ok {
+		return nil
+	}
+
+	caching, err := wrender.NewBoltCaching(db, url, wrender.CachedPagePrefix, false)
+	if err != nil {
+		return err
+	}
+	pageCache := wrender.NewPageCached(url, nil, ttl)
+	pageCache.StatusCode = result.StatusCode
+	pageCache.Redirects = result.Redirects
+	if err := pageCache.Update(caching, result.Content, false); err != nil {
+		return err
+	}
+	// Converted caches of the previous rendered content are outdated
+	if err := DeleteFormatCaches(caching, wrender.ConvertedFormats); err != nil {
+		return err
+	}
+
+	location := result.Redirects.Location()
+	if config.GetString("renderer.redirectMode") != wrender.RedirectModeFollow ||
+		location == "" || location == url {
+		return nil
+	}
+
+	targetCaching, err := wrender.NewBoltCaching(db, location, wrender.CachedPagePrefix, false)
+	if err != nil {
+		return err
+	}
+	targetCache := wrender.NewPageCached(location, nil, ttl)
+	targetCache.StatusCode = result.StatusCode
+	targetCache.Redirects = result.Redirects
+	if err := targetCache.Update(targetCaching, result.Content, false); err != nil {
+		return err
+	}
+
+	return DeleteFormatCaches(targetCaching, wrender.ConvertedFormats)
+}
+
+// DeleteFormatCaches removes the caches of the given output formats belonging to
+// the html page cache of caching.
+func DeleteFormatCaches(caching wrender.BoltCaching, formats []string) error {
+	htmlKey := caching.CachedKey
+	for _, format := range formats {
+		if format == wrender.FormatHtml {
+			continue
+		}
+		caching.CachedKey = wrender.FormatKey(htmlKey, format)
+		if err := caching.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PageCacheTtl returns the cache ttl of a rendered page with the given status code
+// following the cache error policy in config. The returned bool is false if the
+// page should not be cached.
+func PageCacheTtl(config *viper.Viper, statusCode int) (time.Duration, bool) {
+	return wrender.PageCacheTtl(
+		statusCode,
+		config.GetString("cache.errorPolicy"),
+		config.GetDuration("cache.durationInMinutes")*time.Minute,
+		config.GetDuration("cache.errorDurationInMinutes")*time.Minute,
+	)
+}
@@ -0,0 +1,94 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/renderer"
+	"github.com/liuminhaw/wrenderer/wrender"
+	"github.com/spf13/viper"
+)
+
+func TestCachePageFollowRedirect(t *testing.T) {
+	h := newTestHandler(t)
+	config := viper.New()
+	config.Set("renderer.redirectMode", wrender.RedirectModeFollow)
+	config.Set("cache.durationInMinutes", 10)
+
+	url, location := "https://a.com/old", "https://a.com/new"
+	targetCaching, err := wrender.NewBoltCaching(h.DB, location, wrender.CachedPagePrefix, false)
+	if err != nil {
+		t.Fatalf("NewBoltCaching() error: %v", err)
+	}
+	// A markdown cache converted from a previous render of the target
+	markdownCaching := targetCaching
+	markdownCaching.CachedKey = wrender.FormatKey(targetCaching.CachedKey, wrender.FormatMarkdown)
+	if err := markdownCaching.Update(strings.NewReader("outdated")); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+
+	result := &renderer.RenderResult{
+		Content:    []byte("<html></html>"),
+		StatusCode: 200,
+		Redirects: renderer.RedirectChain{
+			{From: url, To: location, Type: "http", StatusCode: 301},
+		},
+	}
+	if err := CachePage(context.Background(), h.DB, config, url, result); err != nil {
+		t.Fatalf("CachePage() error: %v", err)
+	}
+
+	content, err := targetCaching.Read()
+	if err != nil {
+		t.Fatalf("target cache not found: %v", err)
+	}
+	var cached wrender.PageCached
+	if err := json.Unmarshal(content, &cached); err != nil {
+		t.Fatalf("unmarshal target cache: %v", err)
+	}
+	if cached.Redirects.Location() != location || cached.Status() != 200 {
+		t.Errorf("target cache = %+v, want the redirect chain to %s", cached, location)
+	}
+
+	var nerr *wrender.CacheNotFoundError
+	if _, err := markdownCaching.Read(); !errors.As(err, &nerr) {
+		t.Errorf("outdated target markdown cache read error = %v, want CacheNotFoundError", err)
+	}
+}
+
+func TestStartWorkersPostprocessSteps(t *testing.T) {
+	h := newTestHandler(t)
+	config := viper.New()
+	config.Set("postprocess.steps", []string{"unknown"})
+
+	if err := h.StartWorkers(context.Background(), config); err == nil {
+		t.Error("StartWorkers() with an unsupported post-processing step succeeded")
+	}
+}
+
+func TestStopWorkers(t *testing.T) {
+	h := newTestHandler(t)
+	h.Scheduler = NewScheduler(SchedulerOption{Capacity: 1, Workers: 2})
+	config := viper.New()
+	config.Set("queue.workers", 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := h.StartWorkers(ctx, config); err != nil {
+		t.Fatalf("StartWorkers() error: %v", err)
+	}
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stopCancel()
+	if err := h.StopWorkers(stopCtx); err != nil {
+		t.Fatalf("StopWorkers() error: %v", err)
+	}
+	// Jobs are refused once the workers are stopped
+	if _, err := h.Scheduler.TryEnqueue(RenderJob{Url: "https://a.com/"}); !errors.Is(err, ErrSchedulerClosed) {
+		t.Errorf("TryEnqueue() after StopWorkers error = %v, want ErrSchedulerClosed", err)
+	}
+}
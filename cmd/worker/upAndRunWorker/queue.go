@@ -0,0 +1,71 @@
+package upAndRunWorker
+
+import (
+	"sync"
+
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/renderer"
+	"github.com/liuminhaw/wrenderer/wrender"
+)
+
+// jobCategories are the categories of the jobs rendering a persisted queue.
+var jobCategories = []string{
+	internal.SitemapCategory,
+	internal.BatchCategory,
+	internal.CrawlCategory,
+}
+
+// queueJob tells how the entries of the persisted queue of a job are rendered.
+type queueJob struct {
+	priority    string
+	concurrency int
+	// rendered is called with the result of each rendered entry if not nil, the
+	// entries it queues are rendered by the same job
+	rendered func(entry wrender.QueuedEntry, result *renderer.RenderResult) error
+	// callback is notified once the job ends
+	callback wrender.JobCallback
+}
+
+// queueWork tracks the entries of a job queue being rendered, for the renders to
+// wait for the entries queued by the others before ending the job.
+type queueWork struct {
+	mu       sync.Mutex
+	inflight int
+	// changed is closed and replaced when an entry is done
+	changed chan struct{}
+}
+
+func newQueueWork() *queueWork {
+	return &queueWork{changed: make(chan struct{})}
+}
+
+// next takes the next entry of queue. If no entry is queued while other entries
+// are being rendered, the returned channel is closed once one of them is done,
+// otherwise it is nil and the queue is done.
+func (w *queueWork) next(queue wrender.BoltJobQueue) (wrender.QueuedEntry, bool, <-chan struct{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entry, ok, err := queue.Next()
+	if err != nil {
+		return entry, false, nil, err
+	}
+	if ok {
+		w.inflight++
+		return entry, true, nil, nil
+	}
+	if w.inflight == 0 {
+		return entry, false, nil, nil
+	}
+	return entry, false, w.changed, nil
+}
+
+// done marks an entry taken by next as done.
+func (w *queueWork) done() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.inflight--
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
@@ -0,0 +1,136 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrShutdown is the cause of the jobs cancelled by a server shutdown.
+var ErrShutdown = errors.New("server shutting down")
+
+// jobStopGrace is the time given to interrupted jobs to record their status.
+const jobStopGrace = 5 * time.Second
+
+// JobRegistry keeps the cancel functions of the running sitemap jobs, so that a
+// job can be cancelled by its category and key, and all jobs can be interrupted
+// on shutdown.
+type JobRegistry struct {
+	base     context.Context
+	shutdown context.CancelCauseFunc
+
+	mu   sync.Mutex
+	jobs map[string]context.CancelFunc
+	// idle is closed once no job is running
+	idle chan struct{}
+}
+
+// NewJobRegistry creates an empty JobRegistry.
+func NewJobRegistry() *JobRegistry {
+	base, shutdown := context.WithCancelCause(context.Background())
+	idle := make(chan struct{})
+	close(idle)
+
+	return &JobRegistry{
+		base:     base,
+		shutdown: shutdown,
+		jobs:     map[string]context.CancelFunc{},
+		idle:     idle,
+	}
+}
+
+// Register returns the context of the job of jobKey in category with the given
+// timeout. Done must be called once the job ends.
+func (r *JobRegistry) Register(category, jobKey string, timeout time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(r.base, timeout)
+
+	r.mu.Lock()
+	if len(r.jobs) == 0 {
+		r.idle = make(chan struct{})
+	}
+	r.jobs[registryKey(category, jobKey)] = cancel
+	r.mu.Unlock()
+
+	return ctx
+}
+
+// Done releases the context of the job of jobKey in category and removes the job
+// from the registry.
+func (r *JobRegistry) Done(category, jobKey string) {
+	key := registryKey(category, jobKey)
+	r.mu.Lock()
+	cancel, ok := r.jobs[key]
+	delete(r.jobs, key)
+	if ok && len(r.jobs) == 0 {
+		close(r.idle)
+	}
+	r.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+// Cancel cancels the running job of jobKey in category, false is returned if the
+// job is not running.
+func (r *JobRegistry) Cancel(category, jobKey string) bool {
+	r.mu.Lock()
+	cancel, ok := r.jobs[registryKey(category, jobKey)]
+	r.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// Active reports whether the job of jobKey in category is running.
+func (r *JobRegistry) Active(category, jobKey string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.jobs[registryKey(category, jobKey)]
+	return ok
+}
+
+// Running returns the number of running jobs.
+func (r *JobRegistry) Running() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.jobs)
+}
+
+// Stop waits for the running jobs to end until ctx is done, the jobs still
+// running are then cancelled with ErrShutdown as cause and given a short grace
+// period to record their status. ctx.Err() is returned if jobs were cancelled.
+// Jobs registered after Stop are cancelled right away.
+func (r *JobRegistry) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	idle := r.idle
+	r.mu.Unlock()
+
+	select {
+	case <-idle:
+		r.shutdown(ErrShutdown)
+		return nil
+	case <-ctx.Done():
+	}
+
+	r.shutdown(ErrShutdown)
+	r.mu.Lock()
+	idle = r.idle
+	r.mu.Unlock()
+
+	select {
+	case <-idle:
+	case <-time.After(jobStopGrace):
+	}
+	return ctx.Err()
+}
+
+// registryKey returns the key of the job of jobKey in category in the registry.
+func registryKey(category, jobKey string) string {
+	return category + "/" + jobKey
+}
@@ -0,0 +1,92 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/internal"
+)
+
+func TestJobRegistryCategory(t *testing.T) {
+	r := NewJobRegistry()
+	ctx := r.Register(internal.CrawlCategory, "abcdef-ghijkl", time.Minute)
+	defer r.Done(internal.CrawlCategory, "abcdef-ghijkl")
+
+	if r.Active(internal.SitemapCategory, "abcdef-ghijkl") {
+		t.Error("crawl job active under the sitemap category")
+	}
+	if r.Cancel(internal.SitemapCategory, "abcdef-ghijkl") {
+		t.Error("crawl job cancelled under the sitemap category")
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("job context done: %v", ctx.Err())
+	}
+
+	if !r.Cancel(internal.CrawlCategory, "abcdef-ghijkl") {
+		t.Fatal("crawl job not cancelled under its category")
+	}
+	if ctx.Err() == nil {
+		t.Error("job context not done after cancel")
+	}
+	if got := jobStopStatus(ctx); got != internal.JobStatusCancelled {
+		t.Errorf("jobStopStatus() = %q, want %q", got, internal.JobStatusCancelled)
+	}
+}
+
+func TestJobStopStatus(t *testing.T) {
+	r := NewJobRegistry()
+	timedOut := r.Register(internal.SitemapCategory, "timeout", time.Nanosecond)
+	defer r.Done(internal.SitemapCategory, "timeout")
+	<-timedOut.Done()
+	if got := jobStopStatus(timedOut); got != internal.JobStatusTimeout {
+		t.Errorf("jobStopStatus() = %q, want %q", got, internal.JobStatusTimeout)
+	}
+
+	interrupted := r.Register(internal.SitemapCategory, "shutdown", time.Minute)
+	r.shutdown(ErrShutdown)
+	defer r.Done(internal.SitemapCategory, "shutdown")
+	if got := jobStopStatus(interrupted); got != internal.JobStatusInterrupted {
+		t.Errorf("jobStopStatus() = %q, want %q", got, internal.JobStatusInterrupted)
+	}
+}
+
+func TestJobRegistryStop(t *testing.T) {
+	// Jobs ending before the deadline are waited for
+	r := NewJobRegistry()
+	ctx := r.Register(internal.SitemapCategory, "ending", time.Minute)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		r.Done(internal.SitemapCategory, "ending")
+	}()
+	if err := r.Stop(context.Background()); err != nil {
+		t.Errorf("Stop() with an ending job error = %v, want nil", err)
+	}
+	if errors.Is(context.Cause(ctx), ErrShutdown) {
+		t.Error("job ended before the deadline interrupted")
+	}
+
+	// Jobs still running at the deadline are interrupted
+	r = NewJobRegistry()
+	ctx = r.Register(internal.SitemapCategory, "running", time.Minute)
+	go func() {
+		<-ctx.Done()
+		r.Done(internal.SitemapCategory, "running")
+	}()
+	deadline, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Stop(deadline); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop() with a running job error = %v, want context.DeadlineExceeded", err)
+	}
+	if !errors.Is(context.Cause(ctx), ErrShutdown) {
+		t.Errorf("running job cause = %v, want ErrShutdown", context.Cause(ctx))
+	}
+
+	// Jobs registered once stopped are interrupted right away
+	late := r.Register(internal.SitemapCategory, "late", time.Minute)
+	defer r.Done(internal.SitemapCategory, "late")
+	if !errors.Is(context.Cause(late), ErrShutdown) {
+		t.Errorf("job registered after Stop cause = %v, want ErrShutdown", context.Cause(late))
+	}
+}
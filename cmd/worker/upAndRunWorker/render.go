@@ -1,147 +1,92 @@
 package upAndRunWorker
 
 import (
-	"fmt"
+	"context"
 	"log/slog"
-	"time"
+	"sync"
 
 	"github.com/boltdb/bolt"
-	"github.com/liuminhaw/renderer"
-	"github.com/liuminhaw/wrenderer/internal"
-	"github.com/liuminhaw/wrenderer/wrender"
-	"github.com/spf13/viper"
+	"github.com/liuminhaw/wrenderer/renderer"
 )
 
 type RenderJobResult struct {
-	Content []byte
-	Err     error
+	Result *renderer.RenderResult
+	Err    error
 }
 
+// RenderJob is a page render request handled by the render workers. Key is the
+// RenderKey of the job and Priority its priority class in the scheduler. Archive
+// requests an MHTML snapshot of the page along with the rendered html. The job
+// is dropped if Context is done before a worker picks it up.
 type RenderJob struct {
-	Url    string
-	Result chan RenderJobResult
+	Context  context.Context
+	Key      string
+	Url      string
+	Priority string
+	Archive  bool
+	Result   chan RenderJobResult
 }
 
-type Handler struct {
-	Logger      *slog.Logger
-	DB          *bolt.DB
-	RenderQueue chan RenderJob
-	Semaphore   chan struct{}
-	ErrorChan   chan error
-}
-
-func (h *Handler) ErrorListener() {
-	h.Logger.Debug("Error Listener started")
-	for err := range h.ErrorChan {
-		h.Logger.Error(err.Error())
-	}
-}
-
-func (h *Handler) RenderSitemap(config *viper.Viper, url, jobKey string) {
-	defer func() { <-h.Semaphore }() // release semaphore slot
-
-	entries, err := internal.ParseSitemap(url)
-	if err != nil {
-		h.Logger.Info("Error parsing sitemap", slog.String("url", url))
-		err := HandlerError{source: "worker renderSitemap", err: err}
-		h.ErrorChan <- &err
-		return
-	}
-
-	param := fmt.Sprintf("%s/%s", internal.SitemapCategory, jobKey)
-	h.Logger.Debug(fmt.Sprintf("Sitemap Job Caching param: %s", param))
-	jobCaching, err := wrender.NewBoltCaching(h.DB, param, wrender.CachedJobPrefix, false)
+// NewRenderJob creates a render job of url with the given priority.
+func NewRenderJob(ctx context.Context, url string, archive bool, priority string) (RenderJob, error) {
+	key, err := RenderKey(url, archive)
 	if err != nil {
-		err := HandlerError{source: "worker renderSitemap", err: err}
-		h.ErrorChan <- &err
-		return
+		return RenderJob{}, err
 	}
 
-	h.Logger.Info(
-		"Sitemap Job Cache",
-		slog.String("RootBucket", jobCaching.RootBucket),
-		slog.String("HostBucket", jobCaching.HostBucket),
-		slog.String("CachedKey", jobCaching.CachedKey),
-	)
+	return RenderJob{
+		Context:  ctx,
+		Key:      key,
+		Url:      url,
+		Priority: priority,
+		Archive:  archive,
+		Result:   make(chan RenderJobResult, 1),
+	}, nil
+}
 
-	ttl := config.GetDuration("semaphore.jobTimeoutInMinutes") * time.Minute
-	jobCache := wrender.NewSitemapJobCache(internal.JobStatusProcessing, ttl)
-	if err := jobCache.Update(jobCaching, internal.JobStatusProcessing); err != nil {
-		err := HandlerError{source: "renderSitemap worker", err: err}
-		h.ErrorChan <- &err
-		return
+// Wait waits for the result of the job, ctx.Err() is returned if ctx is done
+// first.
+func (job RenderJob) Wait(ctx context.Context) (*renderer.RenderResult, error) {
+	select {
+	case jobResult := <-job.Result:
+		return jobResult.Result, jobResult.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
 
-	h.Logger.Debug(
-		"Sitemap Job Cache updated",
-		slog.String(
-			"path",
-			fmt.Sprintf(
-				"%s/%s/%s",
-				jobCaching.RootBucket,
-				jobCaching.HostBucket,
-				jobCaching.CachedKey,
-			),
-		),
-		slog.String("status", internal.JobStatusProcessing),
-	)
-
-	// Render each url from the sitemap
-	render := renderer.NewRenderer(renderer.WithLogger(h.Logger))
-	for _, entry := range entries {
-		h.Logger.Debug(fmt.Sprintf("Sitemap rendering: %s start", entry.Loc))
-
-		caching, err := wrender.NewBoltCaching(h.DB, entry.Loc, wrender.CachedPagePrefix, false)
-		if err != nil {
-			jobCache.Failed = append(jobCache.Failed, entry.Loc)
-			err := HandlerError{source: "renderSitemap worker", err: err}
-			h.ErrorChan <- &err
-			continue
-		}
-
-		content, err := render.RenderPage(entry.Loc, rendererOption(config))
-		if err != nil {
-			jobCache.Failed = append(jobCache.Failed, entry.Loc)
-			err := HandlerError{source: "renderSitemap worker", err: err}
-			h.ErrorChan <- &err
-			continue
-		}
+type Handler struct {
+	Logger    *slog.Logger
+	DB        *bolt.DB
+	Pool      *renderer.Pool
+	Renders   *RenderGroup
+	Scheduler *Scheduler
+	Jobs      *JobRegistry
+	Semaphore chan struct{}
+	ErrorChan chan error
+
+	// workers tracks the render workers started by StartWorkers
+	workers *sync.WaitGroup
+}
 
-		pageCache := wrender.NewPageCached(
-			entry.Loc,
-			nil,
-			config.GetDuration("cache.durationInMinutes")*time.Minute,
-		)
-		if err := pageCache.Update(caching, content, false); err != nil {
-			jobCache.Failed = append(jobCache.Failed, entry.Loc)
-			err := HandlerError{source: "renderSitemap worker", err: err}
-			h.ErrorChan <- &err
-			continue
+// ErrorListener logs the errors sent to h.ErrorChan until ctx is done, errors
+// already sent are logged before it returns.
+func (h *Handler) ErrorListener(ctx context.Context) {
+	h.Logger.Debug("Error Listener started")
+	for {
+		select {
+		case err := <-h.ErrorChan:
+			h.Logger.Error(err.Error())
+		case <-ctx.Done():
+			for {
+				select {
+				case err := <-h.ErrorChan:
+					h.Logger.Error(err.Error())
+				default:
+					h.Logger.Debug("Error Listener stopped")
+					return
+				}
+			}
 		}
-		h.Logger.Debug(fmt.Sprintf("Sitemap rendering: %s done", entry.Loc))
-	}
-
-	jobStatus := internal.JobStatusCompleted
-	if len(jobCache.Failed) != 0 {
-		jobStatus = internal.JobStatusFailed
-	}
-	if err := jobCache.Update(jobCaching, jobStatus); err != nil {
-		err := HandlerError{source: "renderSitemap worker", err: err}
-		h.ErrorChan <- &err
-		return
 	}
-
-	h.Logger.Debug(
-		"Sitemap Job Cache updated",
-		slog.String(
-			"path",
-			fmt.Sprintf(
-				"%s/%s/%s",
-				jobCaching.RootBucket,
-				jobCaching.HostBucket,
-				jobCaching.CachedKey,
-			),
-		),
-		slog.String("status", internal.JobStatusCompleted),
-	)
 }
@@ -0,0 +1,32 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestErrorListenerDrain(t *testing.T) {
+	h := newTestHandler(t)
+	h.ErrorChan = make(chan error, 2)
+	h.ErrorChan <- errors.New("first")
+	h.ErrorChan <- errors.New("second")
+
+	// Errors sent before the listener stops are logged before it returns
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		h.ErrorListener(ctx)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ErrorListener() not stopped")
+	}
+	if len(h.ErrorChan) != 0 {
+		t.Errorf("%d errors left unlogged", len(h.ErrorChan))
+	}
+}
@@ -0,0 +1,403 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/internal"
+)
+
+// Priority classes of render jobs, from highest to lowest. Interactive renders
+// serve live requests, refresh renders update existing caches and bulk renders
+// prerender whole sitemaps.
+const (
+	PriorityInteractive = "interactive"
+	PriorityRefresh     = "refresh"
+	PriorityBulk        = "bulk"
+)
+
+// Priorities lists the priority classes from highest to lowest.
+var Priorities = []string{PriorityInteractive, PriorityRefresh, PriorityBulk}
+
+var ErrSchedulerClosed = errors.New("render scheduler is closed")
+
+// SupportedPriority reports whether priority is a known priority class.
+func SupportedPriority(priority string) bool {
+	for _, p := range Priorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// SchedulerOption configures Scheduler. Capacity is the number of jobs each
+// priority class can hold, Workers the number of render workers taking jobs
+// from the scheduler, of which Reserved only run interactive jobs. DomainWeights
+// gives the number of jobs taken in a row from a domain before moving to the
+// next one of the same class, domains not listed have a weight of 1. Refresh and
+// bulk jobs of a domain are held while the domain is over DomainLimits, whereas
+// interactive jobs are never held but count toward the limits.
+type SchedulerOption struct {
+	Capacity      int
+	Workers       int
+	Reserved      int
+	DomainWeights map[string]int
+	DomainLimits  internal.DomainLimits
+}
+
+// Scheduler is the render queue shared by on-demand renders and sitemap jobs.
+// Jobs are taken by priority class, and round robin between the domains of the
+// same class weighted by DomainWeights.
+type Scheduler struct {
+	opts    SchedulerOption
+	limiter *internal.DomainLimiter
+
+	mu      sync.Mutex
+	classes map[string]*classQueue
+	// number of running non interactive jobs
+	background int
+	closed     bool
+	// changed is closed and replaced whenever jobs are queued or taken
+	changed chan struct{}
+}
+
+// classQueue holds the queued jobs of a priority class, grouped by domain. The
+// domains are served in the order of hosts starting from next.
+type classQueue struct {
+	size   int
+	hosts  []string
+	queues map[string][]RenderJob
+	next   int
+	// jobs taken in a row from the domain at next
+	served int
+}
+
+// NewScheduler creates a Scheduler with the given options.
+func NewScheduler(opts SchedulerOption) *Scheduler {
+	if opts.Capacity <= 0 {
+		opts.Capacity = 1
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.Reserved < 0 || opts.Reserved >= opts.Workers {
+		opts.Reserved = opts.Workers - 1
+	}
+
+	s := &Scheduler{
+		opts:    opts,
+		limiter: internal.NewDomainLimiter(opts.DomainLimits),
+		classes: map[string]*classQueue{},
+		changed: make(chan struct{}),
+	}
+	for _, priority := range Priorities {
+		s.classes[priority] = &classQueue{queues: map[string][]RenderJob{}}
+	}
+
+	return s
+}
+
+// Enqueue adds the job to the queue of its priority class, waiting while the
+// class is at capacity. ctx.Err() is returned if ctx is done before the job is
+// queued. Jobs with an unknown priority are queued as interactive.
+func (s *Scheduler) Enqueue(ctx context.Context, job RenderJob) error {
+	if !SupportedPriority(job.Priority) {
+		job.Priority = PriorityInteractive
+	}
+	host := jobHost(job.Url)
+
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return ErrSchedulerClosed
+		}
+		class := s.classes[job.Priority]
+		if class.size < s.opts.Capacity {
+			class.push(host, job)
+			s.notify()
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TryEnqueue adds the job to the queue of its priority class without waiting,
+// false is returned if the class is at capacity.
+func (s *Scheduler) TryEnqueue(job RenderJob) (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := s.Enqueue(ctx, job)
+	if errors.Is(err, context.Canceled) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Promote moves the queued job of the given render key to priority if priority
+// is higher than its class, so requests joining a queued render are not held by
+// the lower class.
+func (s *Scheduler) Promote(key, priority string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, lower := range Priorities[priorityRank(priority)+1:] {
+		job, ok := s.classes[lower].remove(key)
+		if !ok {
+			continue
+		}
+		job.Priority = priority
+		s.classes[priority].push(jobHost(job.Url), job)
+		s.notify()
+		return
+	}
+}
+
+// Next waits for and returns the next job to render. Non interactive jobs are
+// only returned while workers are left beside the reserved ones. Done must be
+// called with the job once it is rendered. false is returned once the scheduler
+// is closed.
+func (s *Scheduler) Next() (RenderJob, bool) {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return RenderJob{}, false
+		}
+		job, ok, wait := s.take()
+		if ok {
+			s.notify()
+			s.mu.Unlock()
+			return job, true
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		if wait <= 0 {
+			<-changed
+			continue
+		}
+		// Retry once the limits of a held domain allow it
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Done marks the job returned by Next as finished.
+func (s *Scheduler) Done(job RenderJob) {
+	s.limiter.Release(jobHost(job.Url))
+
+	s.mu.Lock()
+	if job.Priority != PriorityInteractive {
+		s.background--
+	}
+	s.notify()
+	s.mu.Unlock()
+}
+
+// Close stops the scheduler, waiting Next and Enqueue calls return. Queued jobs
+// are left unhandled.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.notify()
+	}
+}
+
+// Queued returns the number of queued jobs of each priority class.
+func (s *Scheduler) Queued() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued := map[string]int{}
+	for priority, class := range s.classes {
+		queued[priority] = class.size
+	}
+	return queued
+}
+
+// take returns the next job by priority, s.mu must be held. If no job can be
+// taken, the returned duration is the shortest wait before a domain held by its
+// limits may be retried, zero if there is none.
+func (s *Scheduler) take() (RenderJob, bool, time.Duration) {
+	var minWait time.Duration
+	acquire := func(host string) bool {
+		ok, wait := s.limiter.Acquire(host)
+		if !ok && wait > 0 && (minWait == 0 || wait < minWait) {
+			minWait = wait
+		}
+		return ok
+	}
+	force := func(host string) bool {
+		s.limiter.Force(host)
+		return true
+	}
+
+	for _, priority := range Priorities {
+		if priority == PriorityInteractive {
+			if job, ok := s.classes[priority].pop(s.opts.DomainWeights, force); ok {
+				return job, true, 0
+			}
+			continue
+		}
+
+		if s.background >= s.opts.Workers-s.opts.Reserved {
+			break
+		}
+		if job, ok := s.classes[priority].pop(s.opts.DomainWeights, acquire); ok {
+			s.background++
+			return job, true, 0
+		}
+	}
+	return RenderJob{}, false, minWait
+}
+
+// notify wakes up the waiting Next and Enqueue calls, s.mu must be held.
+func (s *Scheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (c *classQueue) push(host string, job RenderJob) {
+	if _, ok := c.queues[host]; !ok {
+		c.hosts = append(c.hosts, host)
+	}
+	c.queues[host] = append(c.queues[host], job)
+	c.size++
+}
+
+// pop takes the next job, serving weights[host] jobs of a domain in a row before
+// moving to the next domain. Domains for which acquire returns false are skipped,
+// acquire is called once the job of a domain is about to be taken.
+func (c *classQueue) pop(weights map[string]int, acquire func(host string) bool) (RenderJob, bool) {
+	if c.size == 0 {
+		return RenderJob{}, false
+	}
+
+	taken := -1
+	for i := range c.hosts {
+		if acquire(c.hosts[(c.next+i)%len(c.hosts)]) {
+			taken = (c.next + i) % len(c.hosts)
+			break
+		}
+	}
+	if taken < 0 {
+		return RenderJob{}, false
+	}
+	if taken != c.next {
+		c.next, c.served = taken, 0
+	}
+
+	host := c.hosts[c.next]
+	queue := c.queues[host]
+	job := queue[0]
+	c.queues[host] = queue[1:]
+	c.size--
+	c.served++
+
+	weight := weights[host]
+	if weight <= 0 {
+		weight = 1
+	}
+	switch {
+	case len(c.queues[host]) == 0:
+		c.dropHost(c.next)
+	case c.served >= weight:
+		c.next = (c.next + 1) % len(c.hosts)
+		c.served = 0
+	}
+
+	return job, true
+}
+
+// remove takes the queued job of the given render key out of the queue.
+func (c *classQueue) remove(key string) (RenderJob, bool) {
+	for i, host := range c.hosts {
+		queue := c.queues[host]
+		for j, job := range queue {
+			if job.Key != key {
+				continue
+			}
+			c.queues[host] = append(queue[:j:j], queue[j+1:]...)
+			c.size--
+			if len(c.queues[host]) == 0 {
+				c.dropHost(i)
+			}
+			return job, true
+		}
+	}
+	return RenderJob{}, false
+}
+
+// dropHost removes the domain at index i once its queue is empty.
+func (c *classQueue) dropHost(i int) {
+	delete(c.queues, c.hosts[i])
+	c.hosts = append(c.hosts[:i], c.hosts[i+1:]...)
+	switch {
+	case i < c.next:
+		c.next--
+	case i == c.next:
+		// The following domain moved to index i
+		c.served = 0
+	}
+	if c.next >= len(c.hosts) {
+		c.next = 0
+	}
+}
+
+func priorityRank(priority string) int {
+	for i, p := range Priorities {
+		if p == priority {
+			return i
+		}
+	}
+	return len(Priorities) - 1
+}
+
+func jobHost(jobUrl string) string {
+	u, err := url.Parse(jobUrl)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// ParseDomainWeights parses domain weights given as "domain=weight" entries,
+// invalid entries are skipped.
+func ParseDomainWeights(entries []string) map[string]int {
+	weights := map[string]int{}
+	for _, entry := range entries {
+		domain, weight, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil || w <= 0 {
+			continue
+		}
+		weights[strings.ToLower(strings.TrimSpace(domain))] = w
+	}
+	return weights
+}
@@ -0,0 +1,382 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func newTestJob(t *testing.T, url, priority string) RenderJob {
+	t.Helper()
+
+	job, err := NewRenderJob(context.Background(), url, false, priority)
+	if err != nil {
+		t.Fatalf("NewRenderJob(%q): %v", url, err)
+	}
+	return job
+}
+
+// takeNext takes the next job without waiting, false is returned if no job can
+// be taken.
+func takeNext(s *Scheduler) (RenderJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok, _ := s.take()
+	return job, ok
+}
+
+func TestSchedulerClassOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		queued []RenderJob
+		want   []string
+	}{
+		{
+			name: "interactive before refresh before bulk",
+			queued: []RenderJob{
+				{Url: "https://a.com/bulk", Priority: PriorityBulk},
+				{Url: "https://a.com/refresh", Priority: PriorityRefresh},
+				{Url: "https://a.com/interactive", Priority: PriorityInteractive},
+			},
+			want: []string{
+				"https://a.com/interactive",
+				"https://a.com/refresh",
+				"https://a.com/bulk",
+			},
+		},
+		{
+			name: "fifo within a class",
+			queued: []RenderJob{
+				{Url: "https://a.com/1", Priority: PriorityBulk},
+				{Url: "https://a.com/2", Priority: PriorityBulk},
+				{Url: "https://a.com/3", Priority: PriorityBulk},
+			},
+			want: []string{"https://a.com/1", "https://a.com/2", "https://a.com/3"},
+		},
+		{
+			name: "unknown priority is interactive",
+			queued: []RenderJob{
+				{Url: "https://a.com/bulk", Priority: PriorityBulk},
+				{Url: "https://a.com/unknown", Priority: "unknown"},
+			},
+			want: []string{"https://a.com/unknown", "https://a.com/bulk"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(SchedulerOption{Capacity: 10, Workers: 10})
+			for _, job := range tt.queued {
+				if err := s.Enqueue(context.Background(), job); err != nil {
+					t.Fatalf("Enqueue(%q): %v", job.Url, err)
+				}
+			}
+
+			var got []string
+			for {
+				job, ok := takeNext(s)
+				if !ok {
+					break
+				}
+				got = append(got, job.Url)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("taken %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerReservedWorkers(t *testing.T) {
+	s := NewScheduler(SchedulerOption{Capacity: 10, Workers: 3, Reserved: 1})
+	for _, url := range []string{"https://a.com/1", "https://a.com/2", "https://a.com/3"} {
+		if err := s.Enqueue(context.Background(), RenderJob{Url: url, Priority: PriorityBulk}); err != nil {
+			t.Fatalf("Enqueue(%q): %v", url, err)
+		}
+	}
+
+	// Two workers are left for bulk renders
+	first, ok := takeNext(s)
+	if !ok {
+		t.Fatal("first bulk job not taken")
+	}
+	if _, ok := takeNext(s); !ok {
+		t.Fatal("second bulk job not taken")
+	}
+	if job, ok := takeNext(s); ok {
+		t.Fatalf("bulk job %q taken on the reserved worker", job.Url)
+	}
+
+	// The reserved worker still runs interactive renders
+	interactive := RenderJob{Url: "https://b.com/", Priority: PriorityInteractive}
+	if err := s.Enqueue(context.Background(), interactive); err != nil {
+		t.Fatalf("Enqueue(%q): %v", interactive.Url, err)
+	}
+	job, ok := takeNext(s)
+	if !ok || job.Url != interactive.Url {
+		t.Fatalf("taken %q, %v, want interactive job", job.Url, ok)
+	}
+
+	// A bulk worker is free once a bulk render is done
+	s.Done(first)
+	job, ok = takeNext(s)
+	if !ok || job.Url != "https://a.com/3" {
+		t.Fatalf("taken %q, %v, want https://a.com/3", job.Url, ok)
+	}
+}
+
+func TestSchedulerReservedBounds(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         SchedulerOption
+		wantReserved int
+	}{
+		{"default", SchedulerOption{Workers: 3}, 0},
+		{"within workers", SchedulerOption{Workers: 3, Reserved: 2}, 2},
+		{"every worker", SchedulerOption{Workers: 3, Reserved: 3}, 2},
+		{"negative", SchedulerOption{Workers: 3, Reserved: -1}, 2},
+		{"no worker", SchedulerOption{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(tt.opts)
+			if s.opts.Reserved != tt.wantReserved {
+				t.Errorf("Reserved = %d, want %d", s.opts.Reserved, tt.wantReserved)
+			}
+		})
+	}
+}
+
+func TestSchedulerWeightedRoundRobin(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		urls    []string
+		want    []string
+	}{
+		{
+			name: "equal weights alternate",
+			urls: []string{
+				"https://a.com/1", "https://a.com/2", "https://a.com/3",
+				"https://b.com/1", "https://b.com/2",
+			},
+			want: []string{
+				"https://a.com/1", "https://b.com/1", "https://a.com/2",
+				"https://b.com/2", "https://a.com/3",
+			},
+		},
+		{
+			name:    "weighted domain served in a row",
+			weights: map[string]int{"a.com": 2},
+			urls: []string{
+				"https://a.com/1", "https://a.com/2", "https://a.com/3",
+				"https://b.com/1", "https://b.com/2",
+			},
+			want: []string{
+				"https://a.com/1", "https://a.com/2", "https://b.com/1",
+				"https://a.com/3", "https://b.com/2",
+			},
+		},
+		{
+			name:    "three domains",
+			weights: map[string]int{"c.com": 3},
+			urls: []string{
+				"https://a.com/1", "https://a.com/2",
+				"https://b.com/1",
+				"https://c.com/1", "https://c.com/2", "https://c.com/3", "https://c.com/4",
+			},
+			want: []string{
+				"https://a.com/1", "https://b.com/1", "https://c.com/1",
+				"https://c.com/2", "https://c.com/3", "https://a.com/2",
+				"https://c.com/4",
+			},
+		},
+		{
+			name: "hosts are case insensitive",
+			urls: []string{"https://A.com/1", "https://a.com/2", "https://b.com/1"},
+			want: []string{"https://A.com/1", "https://b.com/1", "https://a.com/2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(SchedulerOption{
+				Capacity:      len(tt.urls),
+				Workers:       len(tt.urls) + 1,
+				DomainWeights: tt.weights,
+			})
+			for _, url := range tt.urls {
+				job := RenderJob{Url: url, Priority: PriorityBulk}
+				if err := s.Enqueue(context.Background(), job); err != nil {
+					t.Fatalf("Enqueue(%q): %v", url, err)
+				}
+			}
+
+			var got []string
+			for range tt.urls {
+				job, ok := takeNext(s)
+				if !ok {
+					t.Fatalf("job not taken after %v", got)
+				}
+				got = append(got, job.Url)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("taken %v, want %v", got, tt.want)
+			}
+			if queued := s.Queued()[PriorityBulk]; queued != 0 {
+				t.Errorf("%d bulk jobs left queued", queued)
+			}
+		})
+	}
+}
+
+func TestSchedulerPromote(t *testing.T) {
+	tests := []struct {
+		name         string
+		queued       []RenderJob
+		promote      string
+		priority     string
+		wantFirst    string
+		wantPriority string
+	}{
+		{
+			name: "bulk to interactive",
+			queued: []RenderJob{
+				{Url: "https://a.com/refresh", Priority: PriorityRefresh},
+				{Url: "https://a.com/bulk", Priority: PriorityBulk},
+			},
+			promote:      "https://a.com/bulk",
+			priority:     PriorityInteractive,
+			wantFirst:    "https://a.com/bulk",
+			wantPriority: PriorityInteractive,
+		},
+		{
+			name: "refresh to interactive",
+			queued: []RenderJob{
+				{Url: "https://a.com/bulk", Priority: PriorityBulk},
+				{Url: "https://a.com/refresh", Priority: PriorityRefresh},
+			},
+			promote:      "https://a.com/refresh",
+			priority:     PriorityInteractive,
+			wantFirst:    "https://a.com/refresh",
+			wantPriority: PriorityInteractive,
+		},
+		{
+			name: "bulk to refresh",
+			queued: []RenderJob{
+				{Url: "https://a.com/refresh", Priority: PriorityRefresh},
+				{Url: "https://a.com/bulk", Priority: PriorityBulk},
+			},
+			promote:      "https://a.com/bulk",
+			priority:     PriorityRefresh,
+			wantFirst:    "https://a.com/refresh",
+			wantPriority: PriorityRefresh,
+		},
+		{
+			name: "never demoted",
+			queued: []RenderJob{
+				{Url: "https://a.com/refresh", Priority: PriorityRefresh},
+				{Url: "https://a.com/bulk", Priority: PriorityBulk},
+			},
+			promote:      "https://a.com/refresh",
+			priority:     PriorityBulk,
+			wantFirst:    "https://a.com/refresh",
+			wantPriority: PriorityRefresh,
+		},
+		{
+			name: "unknown key",
+			queued: []RenderJob{
+				{Url: "https://a.com/bulk", Priority: PriorityBulk},
+			},
+			promote:      "https://a.com/missing",
+			priority:     PriorityInteractive,
+			wantFirst:    "https://a.com/bulk",
+			wantPriority: PriorityBulk,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(SchedulerOption{Capacity: 10, Workers: 10})
+			for _, queued := range tt.queued {
+				job := newTestJob(t, queued.Url, queued.Priority)
+				if err := s.Enqueue(context.Background(), job); err != nil {
+					t.Fatalf("Enqueue(%q): %v", queued.Url, err)
+				}
+			}
+
+			key, err := RenderKey(tt.promote, false)
+			if err != nil {
+				t.Fatalf("RenderKey(%q): %v", tt.promote, err)
+			}
+			s.Promote(key, tt.priority)
+
+			job, ok := takeNext(s)
+			if !ok {
+				t.Fatal("no job taken")
+			}
+			if job.Url != tt.wantFirst || job.Priority != tt.wantPriority {
+				t.Errorf(
+					"taken %q as %s, want %q as %s",
+					job.Url, job.Priority, tt.wantFirst, tt.wantPriority,
+				)
+			}
+
+			total := 0
+			for _, queued := range s.Queued() {
+				total += queued
+			}
+			if total != len(tt.queued)-1 {
+				t.Errorf("%d jobs left queued, want %d", total, len(tt.queued)-1)
+			}
+		})
+	}
+}
+
+func TestSchedulerCapacity(t *testing.T) {
+	s := NewScheduler(SchedulerOption{Capacity: 1, Workers: 2})
+
+	ok, err := s.TryEnqueue(RenderJob{Url: "https://a.com/1", Priority: PriorityBulk})
+	if !ok || err != nil {
+		t.Fatalf("TryEnqueue() = %v, %v, want true", ok, err)
+	}
+	ok, err = s.TryEnqueue(RenderJob{Url: "https://a.com/2", Priority: PriorityBulk})
+	if ok || err != nil {
+		t.Fatalf("TryEnqueue() on a full class = %v, %v, want false", ok, err)
+	}
+	// Classes have their own capacity
+	ok, err = s.TryEnqueue(RenderJob{Url: "https://a.com/3", Priority: PriorityInteractive})
+	if !ok || err != nil {
+		t.Fatalf("TryEnqueue() of another class = %v, %v, want true", ok, err)
+	}
+
+	s.Close()
+	if _, err := s.TryEnqueue(RenderJob{Url: "https://a.com/4"}); err != ErrSchedulerClosed {
+		t.Errorf("TryEnqueue() on a closed scheduler = %v, want ErrSchedulerClosed", err)
+	}
+	if _, ok := s.Next(); ok {
+		t.Error("Next() on a closed scheduler returned a job")
+	}
+}
+
+func TestParseDomainWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    map[string]int
+	}{
+		{"empty", nil, map[string]int{}},
+		{"valid", []string{"a.com=2", " B.com = 3 "}, map[string]int{"a.com": 2, "b.com": 3}},
+		{"invalid skipped", []string{"a.com", "b.com=x", "c.com=0", "d.com=-1", "e.com=1"}, map[string]int{"e.com": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseDomainWeights(tt.entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDomainWeights(%v) = %v, want %v", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,803 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/renderer"
+	"github.com/liuminhaw/wrenderer/wrender"
+	"github.com/spf13/viper"
+)
+
+// sitemapJobKeyLength is the length of both parts of a sitemap job key.
+const sitemapJobKeyLength = 6
+
+// ErrSitemapJobsFull is returned when every sitemap job slot is taken.
+var ErrSitemapJobsFull = errors.New("sitemap jobs at capacity")
+
+// SitemapJob is the parameters of a sitemap job, persisted along with its queue
+// for the job to be resumed after a restart.
+type SitemapJob struct {
+	SitemapUrl string `json:"sitemapUrl,omitempty"`
+	// Urls is the explicit list of urls of a batch job, rendered in place of the
+	// urls of a sitemap
+	Urls []string `json:"urls,omitempty"`
+	// Priority is the scheduler priority class of the renders of the job
+	Priority string `json:"priority"`
+	// Concurrency is the number of urls of the job rendered at a time
+	Concurrency int `json:"concurrency"`
+	// Mode tells which urls of the sitemap are rendered, see the sitemap modes
+	Mode string `json:"mode,omitempty"`
+	// SitemapFilter selects and orders the urls of the sitemap to render
+	internal.SitemapFilter
+	// JobCallback is notified once the job ends
+	wrender.JobCallback
+}
+
+// Normalize validates the parameters of the job and sets the defaults of the
+// parameters left empty. Sitemap jobs render as bulk in the full mode unless
+// asked otherwise, and their concurrency is bounded by the configured one. An
+// InvalidSitemapJobError is returned for invalid parameters. A batch job lists at
+// most sitemap.maxUrls urls.
+func (job *SitemapJob) Normalize(config *viper.Viper) error {
+	maxUrls := config.GetInt("sitemap.maxUrls")
+	switch {
+	case len(job.Urls) > 0:
+		if job.SitemapUrl != "" || (maxUrls > 0 && len(job.Urls) > maxUrls) {
+			return &InvalidSitemapJobError{Param: "urls"}
+		}
+		if _, err := internal.UrlListEntries(job.Urls); err != nil {
+			return &InvalidSitemapJobError{Param: "urls"}
+		}
+	case !internal.ValidUrl(job.SitemapUrl):
+		return &InvalidSitemapJobError{Param: "sitemap url"}
+	}
+
+	if job.Priority == "" {
+		job.Priority = PriorityBulk
+	}
+	if job.Priority != PriorityBulk && job.Priority != PriorityRefresh {
+		return &InvalidSitemapJobError{Param: "priority"}
+	}
+
+	maxConcurrency := config.GetInt("semaphore.jobConcurrency")
+	if job.Concurrency == 0 {
+		job.Concurrency = maxConcurrency
+	}
+	if job.Concurrency < 0 || job.Concurrency > maxConcurrency {
+		return &InvalidSitemapJobError{Param: "concurrency"}
+	}
+
+	if job.Mode == "" {
+		job.Mode = internal.SitemapModeFull
+	}
+	if !internal.SupportedSitemapMode(job.Mode) {
+		return &InvalidSitemapJobError{Param: "mode"}
+	}
+
+	var ferr *internal.SitemapFilterError
+	if err := job.SitemapFilter.Validate(); errors.As(err, &ferr) {
+		return &InvalidSitemapJobError{Param: ferr.Param}
+	}
+
+	if err := job.JobCallback.Validate(); err != nil {
+		return &InvalidSitemapJobError{Param: "callbackUrl"}
+	}
+
+	return nil
+}
+
+// SubmitSitemap starts job in the background if a sitemap job slot is free and
+// returns the key of the job, ErrSitemapJobsFull is returned otherwise. The job
+// is registered in h.Jobs, it runs until done, cancelled or timed out.
+func (h *Handler) SubmitSitemap(config *viper.Viper, job SitemapJob) (string, error) {
+	select {
+	case h.Semaphore <- struct{}{}:
+	default:
+		return "", ErrSitemapJobsFull
+	}
+
+	jobKey, err := wrender.RandomKey(sitemapJobKeyLength, sitemapJobKeyLength)
+	if err != nil {
+		<-h.Semaphore // release semaphore slot
+		return "", err
+	}
+
+	ctx := h.Jobs.Register(
+		job.category(),
+		jobKey,
+		config.GetDuration("semaphore.jobTimeoutInMinutes")*time.Minute,
+	)
+	go h.RenderSitemap(ctx, config, jobKey, job)
+
+	return jobKey, nil
+}
+
+// RenderSitemap queues the urls of the sitemap of job selected by the job filter
+// to render following the job mode in the persisted queue of jobKey, and renders them through the scheduler
+// with the job priority class, up to the job concurrency at a time. The progress
+// is recorded in the job cache of jobKey. The job stops when ctx is done, ctx is
+// expected to be registered in h.Jobs under jobKey and is released once the job
+// ends.
+func (h *Handler) RenderSitemap(
+	ctx context.Context,
+	config *viper.Viper,
+	jobKey string,
+	job SitemapJob,
+) {
+	defer func() { <-h.Semaphore }() // release semaphore slot
+	defer h.Jobs.Done(job.category(), jobKey)
+
+	jobCaching, err := newJobCaching(h, job.category(), jobKey)
+	if err != nil {
+		err := HandlerError{source: "worker renderSitemap", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+
+	h.Logger.Info(
+		"Sitemap Job Cache",
+		slog.String("RootBucket", jobCaching.RootBucket),
+		slog.String("HostBucket", jobCaching.HostBucket),
+		slog.String("CachedKey", jobCaching.CachedKey),
+	)
+
+	// The job cache is written first, the queues without job cache are pruned
+	ttl := config.GetDuration("semaphore.jobTimeoutInMinutes") * time.Minute
+	jobCache := wrender.NewSitemapJobCache(internal.JobStatusProcessing, ttl)
+	if err := jobCache.Update(jobCaching, internal.JobStatusProcessing); err != nil {
+		err := HandlerError{source: "renderSitemap worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+	queue := wrender.NewBoltJobQueue(h.DB, job.category(), jobKey)
+
+	entries, err := job.entries(ctx, config, h.Logger)
+	if err != nil {
+		h.Logger.Info("Error parsing sitemap", slog.String("url", job.SitemapUrl))
+		h.failSitemapJob(ctx, config, job, queue, jobCaching, &jobCache, err)
+		return
+	}
+	entries, err = job.SitemapFilter.Apply(entries)
+	if err != nil {
+		h.failSitemapJob(ctx, config, job, queue, jobCaching, &jobCache, err)
+		return
+	}
+
+	queued, err := h.sitemapJobEntries(entries, job.Mode)
+	if err != nil {
+		err := HandlerError{source: "renderSitemap worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+	if err := queue.Create(job, queued); err != nil {
+		err := HandlerError{source: "renderSitemap worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+
+	h.processJobQueue(ctx, config, job.queueJob(), queue, jobCaching, &jobCache)
+}
+
+// failSitemapJob records the job ended by err before its urls are queued in the
+// job cache, with an empty queue for its results, and notifies the job callback.
+// A job stopped by a shutdown is not resumed, it has no urls to resume from.
+func (h *Handler) failSitemapJob(
+	ctx context.Context,
+	config *viper.Viper,
+	job SitemapJob,
+	queue wrender.BoltJobQueue,
+	jobCaching wrender.BoltCaching,
+	jobCache *wrender.SitemapJobCache,
+	err error,
+) {
+	herr := HandlerError{source: "worker renderSitemap", err: err}
+	h.ErrorChan <- &herr
+
+	jobStatus := internal.JobStatusFailed
+	if ctx.Err() != nil && jobStopStatus(ctx) != internal.JobStatusInterrupted {
+		jobStatus = jobStopStatus(ctx)
+	}
+	finished := time.Now().UTC()
+	jobCache.Finished = &finished
+	jobCache.Reason = err.Error()
+	if err := jobCache.Update(jobCaching, jobStatus); err != nil {
+		err := HandlerError{source: "renderSitemap worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+	if err := queue.Create(job, nil); err != nil {
+		err := HandlerError{source: "renderSitemap worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+	h.notifyJob(config, job.JobCallback, queue, *jobCache)
+}
+
+// category returns the job category, the batch category for the jobs of an
+// explicit list of urls.
+func (job SitemapJob) category() string {
+	if len(job.Urls) > 0 {
+		return internal.BatchCategory
+	}
+	return internal.SitemapCategory
+}
+
+// queueJob returns how the entries of the queue of the job are rendered.
+func (job SitemapJob) queueJob() queueJob {
+	return queueJob{
+		priority:    job.Priority,
+		concurrency: job.Concurrency,
+		callback:    job.JobCallback,
+	}
+}
+
+// entries returns the urls of the batch job, or reads the urls of the sitemap,
+// the child sitemaps skipped are logged to logger.
+func (job SitemapJob) entries(
+	ctx context.Context,
+	config *viper.Viper,
+	logger *slog.Logger,
+) ([]internal.SitemapEntry, error) {
+	if len(job.Urls) > 0 {
+		return internal.UrlListEntries(job.Urls)
+	}
+	opts := SitemapOption(config)
+	opts.Logger = logger
+	return internal.ParseSitemap(ctx, job.SitemapUrl, opts)
+}
+
+// ResumeJobs resumes the sitemap and crawl jobs left unfinished by a previous
+// run, from their persisted queue. Each job waits for a semaphore slot before
+// being resumed, jobs not resumed yet are left for the next run once ctx is done.
+func (h *Handler) ResumeJobs(ctx context.Context, config *viper.Viper) {
+	for _, category := range jobCategories {
+		if !h.resumeJobs(ctx, config, category) {
+			return
+		}
+	}
+}
+
+// resumeJobs resumes the unfinished jobs of category, false is returned if ctx is
+// done before every job is resumed.
+func (h *Handler) resumeJobs(ctx context.Context, config *viper.Viper, category string) bool {
+	jobKeys, err := wrender.ListJobQueues(h.DB, category)
+	if err != nil {
+		err := HandlerError{source: "resumeJobs worker", err: err}
+		h.ErrorChan <- &err
+		return true
+	}
+
+	for _, jobKey := range jobKeys {
+		queue := wrender.NewBoltJobQueue(h.DB, category, jobKey)
+		jobCaching, jobCache, err := h.resumableJob(config, jobKey, queue)
+		if err != nil {
+			err := HandlerError{source: "resumeJobs worker", err: err}
+			h.ErrorChan <- &err
+			continue
+		}
+		if jobCache == nil {
+			continue
+		}
+
+		run, err := h.resumedQueueJob(config, queue)
+		if err != nil {
+			err := HandlerError{source: "resumeJobs worker", err: err}
+			h.ErrorChan <- &err
+			continue
+		}
+		// Entries in progress when the job stopped are rendered again
+		if err := queue.Requeue(); err != nil {
+			err := HandlerError{source: "resumeJobs worker", err: err}
+			h.ErrorChan <- &err
+			continue
+		}
+
+		select {
+		case h.Semaphore <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+		h.Logger.Info(
+			"Resuming job",
+			slog.String("category", category),
+			slog.String("jobKey", jobKey),
+		)
+
+		jobCtx := h.Jobs.Register(category, jobKey, time.Until(jobCache.Expires))
+		go func() {
+			defer func() { <-h.Semaphore }() // release semaphore slot
+			defer h.Jobs.Done(category, jobKey)
+
+			h.processJobQueue(jobCtx, config, run, queue, jobCaching, jobCache)
+		}()
+	}
+
+	return true
+}
+
+// resumedQueueJob reads the parameters of the job of queue persisted by Create.
+func (h *Handler) resumedQueueJob(config *viper.Viper, queue wrender.BoltJobQueue) (queueJob, error) {
+	var run queueJob
+	switch queue.Category {
+	case internal.CrawlCategory:
+		var job CrawlJob
+		if err := queue.Meta(&job); err != nil {
+			return run, err
+		}
+		crawl, err := h.crawlQueueJob(job, queue)
+		if err != nil {
+			return run, err
+		}
+		run = crawl
+	default:
+		var job SitemapJob
+		if err := queue.Meta(&job); err != nil {
+			return run, err
+		}
+		run = job.queueJob()
+	}
+
+	if run.concurrency <= 0 {
+		run.concurrency = config.GetInt("semaphore.jobConcurrency")
+	}
+	return run, nil
+}
+
+// pruneJobQueues removes the persisted queues of the jobs whose job cache is
+// removed.
+func (h *Handler) pruneJobQueues() error {
+	for _, category := range jobCategories {
+		jobKeys, err := wrender.ListJobQueues(h.DB, category)
+		if err != nil {
+			return err
+		}
+
+		for _, jobKey := range jobKeys {
+			jobCaching, err := newJobCaching(h, category, jobKey)
+			if err != nil {
+				return err
+			}
+			_, err = jobCaching.Read()
+			var werr *wrender.CacheNotFoundError
+			switch {
+			case errors.As(err, &werr):
+				queue := wrender.NewBoltJobQueue(h.DB, category, jobKey)
+				if err := queue.Delete(); err != nil {
+					return err
+				}
+			case err != nil:
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// resumableJob returns the job cache of jobKey if the job can be resumed,
+// otherwise nil is returned. Jobs past their timeout are marked as timed out and
+// notified, and the queue of a job without job cache is removed.
+func (h *Handler) resumableJob(
+	config *viper.Viper,
+	jobKey string,
+	queue wrender.BoltJobQueue,
+) (wrender.BoltCaching, *wrender.SitemapJobCache, error) {
+	jobCaching, err := newJobCaching(h, queue.Category, jobKey)
+	if err != nil {
+		return wrender.BoltCaching{}, nil, err
+	}
+
+	var jobCache wrender.SitemapJobCache
+	content, err := jobCaching.Read()
+	var werr *wrender.CacheNotFoundError
+	switch {
+	case errors.As(err, &werr):
+		h.Logger.Debug("Job cache not found, dropping queue", slog.String("jobKey", jobKey))
+		return jobCaching, nil, queue.Delete()
+	case err != nil:
+		return jobCaching, nil, err
+	}
+	if err := json.Unmarshal(content, &jobCache); err != nil {
+		return jobCaching, nil, err
+	}
+
+	if jobCache.Status != internal.JobStatusProcessing &&
+		jobCache.Status != internal.JobStatusInterrupted {
+		return jobCaching, nil, nil
+	}
+	if jobCache.IsExpired() {
+		h.Logger.Info("Job timed out while stopped", slog.String("jobKey", jobKey))
+		finished := time.Now().UTC()
+		jobCache.Finished = &finished
+		if err := jobCache.Update(jobCaching, internal.JobStatusTimeout); err != nil {
+			return jobCaching, nil, err
+		}
+		var job struct{ wrender.JobCallback }
+		if err := queue.Meta(&job); err != nil {
+			return jobCaching, nil, err
+		}
+		h.notifyJob(config, job.JobCallback, queue, jobCache)
+		return jobCaching, nil, nil
+	}
+
+	return jobCaching, &jobCache, nil
+}
+
+// processJobQueue renders the urls of the persisted queue of the job run with up
+// to the job concurrency renders at a time until it is empty or ctx is done, and
+// records the final status in the job cache, the job callback is notified of the
+// final status. The queue is kept along with the job cache for the
+// results of the entries to be reported, and for the job to be resumed if it is
+// interrupted by a shutdown.
+func (h *Handler) processJobQueue(
+	ctx context.Context,
+	config *viper.Viper,
+	run queueJob,
+	queue wrender.BoltJobQueue,
+	jobCaching wrender.BoltCaching,
+	jobCache *wrender.SitemapJobCache,
+) {
+	if err := jobCache.Update(jobCaching, internal.JobStatusProcessing); err != nil {
+		err := HandlerError{source: "renderSitemap worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+
+	h.Logger.Debug(
+		"Sitemap Job Cache updated",
+		slog.String(
+			"path",
+			fmt.Sprintf(
+				"%s/%s/%s",
+				jobCaching.RootBucket,
+				jobCaching.HostBucket,
+				jobCaching.CachedKey,
+			),
+		),
+		slog.String("status", internal.JobStatusProcessing),
+	)
+
+	// Render the queued urls with up to concurrency renders
+	progress := sitemapProgress{caching: jobCaching, cache: jobCache}
+	work := newQueueWork()
+	var wg sync.WaitGroup
+	for range max(run.concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.renderJobQueue(ctx, config, run, queue, work, &progress)
+		}()
+	}
+	wg.Wait()
+
+	jobStatus := internal.JobStatusCompleted
+	switch {
+	case ctx.Err() != nil:
+		jobStatus = jobStopStatus(ctx)
+	case len(jobCache.Failed) != 0:
+		jobStatus = internal.JobStatusFailed
+	}
+	if jobStatus != internal.JobStatusInterrupted {
+		finished := time.Now().UTC()
+		jobCache.Finished = &finished
+	}
+	if err := jobCache.Update(jobCaching, jobStatus); err != nil {
+		err := HandlerError{source: "renderSitemap worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+	h.notifyJob(config, run.callback, queue, *jobCache)
+
+	h.Logger.Debug(
+		"Sitemap Job Cache updated",
+		slog.String(
+			"path",
+			fmt.Sprintf(
+				"%s/%s/%s",
+				jobCaching.RootBucket,
+				jobCaching.HostBucket,
+				jobCaching.CachedKey,
+			),
+		),
+		slog.String("status", jobStatus),
+	)
+}
+
+// jobStopStatus returns the status of a job stopped by its ctx being done.
+func jobStopStatus(ctx context.Context) string {
+	switch {
+	case errors.Is(context.Cause(ctx), ErrShutdown):
+		return internal.JobStatusInterrupted
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return internal.JobStatusTimeout
+	default:
+		return internal.JobStatusCancelled
+	}
+}
+
+// renderJobQueue takes the urls of the persisted queue one at a time and renders
+// them until the queue is empty and no other render of the job may queue urls,
+// or ctx is done. The failures are recorded in progress.
+func (h *Handler) renderJobQueue(
+	ctx context.Context,
+	config *viper.Viper,
+	run queueJob,
+	queue wrender.BoltJobQueue,
+	work *queueWork,
+	progress *sitemapProgress,
+) {
+	for ctx.Err() == nil {
+		entry, ok, idle, err := work.next(queue)
+		if err != nil {
+			err := HandlerError{source: "renderSitemap worker", err: err}
+			h.ErrorChan <- &err
+			return
+		}
+		if !ok {
+			if idle == nil {
+				return
+			}
+			// Wait for the renders in progress, which may queue new urls
+			select {
+			case <-idle:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		h.renderJobEntry(ctx, config, run, queue, entry, progress)
+		work.done()
+	}
+}
+
+// renderJobEntry renders the entry taken from the queue and moves it to its
+// final state. A failed render is rendered again following the retry policy
+// while the failure is retryable, a page rendered with a retryable error status
+// code is a failure, other client error pages complete with their status code. The attempts are recorded in the entry result. An entry abandoned by a
+// cancelled or timed out job is skipped, whereas it is left processing on
+// shutdown to be rendered again on resume.
+func (h *Handler) renderJobEntry(
+	ctx context.Context,
+	config *viper.Viper,
+	run queueJob,
+	queue wrender.BoltJobQueue,
+	entry wrender.QueuedEntry,
+	progress *sitemapProgress,
+) {
+	h.Logger.Debug(fmt.Sprintf("Job rendering: %s start", entry.TargetUrl))
+
+	policy := RetryPolicy(config)
+	var attempts []wrender.JobEntryAttempt
+	var result *renderer.RenderResult
+	var shared bool
+	var statusCode int
+	var err error
+	start := time.Now()
+	for {
+		started := time.Now()
+		result, shared, err = h.renderSitemapEntry(ctx, config, entry.TargetUrl, run.priority)
+		if err != nil && ctx.Err() != nil {
+			h.abandonJobEntry(ctx, queue, entry, attempts, time.Since(start))
+			return
+		}
+		statusCode = 0
+		if err == nil {
+			statusCode = result.StatusCode
+			err = wrender.RenderStatusErr(statusCode)
+		}
+		attempts = append(attempts, wrender.NewJobEntryAttempt(started, statusCode, err))
+		if err == nil || !policy.Retry(len(attempts), err) {
+			break
+		}
+
+		delay := policy.Delay(len(attempts))
+		h.Logger.Info(
+			"Job rendering failed, retrying",
+			slog.String("url", entry.TargetUrl),
+			slog.Int("attempt", len(attempts)),
+			slog.Duration("backoff", delay),
+			slog.String("error", err.Error()),
+		)
+		select {
+		case <-ctx.Done():
+			h.abandonJobEntry(ctx, queue, entry, attempts, time.Since(start))
+			return
+		case <-time.After(delay):
+		}
+	}
+	duration := time.Since(start)
+
+	if err != nil {
+		entryResult := wrender.NewJobEntryResult(
+			entry.TargetUrl,
+			internal.JobStatusFailed,
+			duration,
+			err,
+		)
+		entryResult.StatusCode = statusCode
+		entryResult.Depth = entry.Depth
+		entryResult.Attempts = attempts
+		if err := queue.Fail(entry, entryResult); err != nil {
+			err := HandlerError{source: "renderSitemap worker", err: err}
+			h.ErrorChan <- &err
+		}
+		if err := progress.fail(entry.TargetUrl); err != nil {
+			err := HandlerError{source: "renderSitemap worker", err: err}
+			h.ErrorChan <- &err
+		}
+		err := HandlerError{source: "renderSitemap worker", err: err}
+		h.ErrorChan <- &err
+		return
+	}
+
+	if run.rendered != nil {
+		if err := run.rendered(entry, result); err != nil {
+			err := HandlerError{source: "renderSitemap worker", err: err}
+			h.ErrorChan <- &err
+		}
+	}
+	entryResult := wrender.NewJobEntryResult(
+		entry.TargetUrl,
+		internal.JobStatusSucceeded,
+		duration,
+		nil,
+	)
+	entryResult.StatusCode = statusCode
+	entryResult.Depth = entry.Depth
+	entryResult.Attempts = attempts
+	if err := queue.Complete(entry, entryResult); err != nil {
+		err := HandlerError{source: "renderSitemap worker", err: err}
+		h.ErrorChan <- &err
+	}
+	h.Logger.Debug(
+		fmt.Sprintf("Job rendering: %s done", entry.TargetUrl),
+		slog.Bool("coalesced", shared),
+	)
+}
+
+// abandonJobEntry moves the entry abandoned by the job stopped by ctx to the
+// skipped state, with the job status as reason. The entry is left processing if
+// the job is interrupted by a shutdown.
+func (h *Handler) abandonJobEntry(
+	ctx context.Context,
+	queue wrender.BoltJobQueue,
+	entry wrender.QueuedEntry,
+	attempts []wrender.JobEntryAttempt,
+	duration time.Duration,
+) {
+	status := jobStopStatus(ctx)
+	if status == internal.JobStatusInterrupted {
+		return
+	}
+
+	entryResult := wrender.NewJobEntryResult(
+		entry.TargetUrl,
+		internal.JobStatusSkipped,
+		duration,
+		fmt.Errorf("job %s", status),
+	)
+	entryResult.Depth = entry.Depth
+	entryResult.Attempts = attempts
+	if err := queue.Skip(entry, entryResult); err != nil {
+		err := HandlerError{source: "renderSitemap worker", err: err}
+		h.ErrorChan <- &err
+	}
+}
+
+// sitemapProgress guards the job cache of a sitemap job updated by its
+// concurrent renders.
+type sitemapProgress struct {
+	mu      sync.Mutex
+	caching wrender.BoltCaching
+	cache   *wrender.SitemapJobCache
+}
+
+// fail records url as failed and saves the job cache, for the failures to be
+// kept across restarts.
+func (p *sitemapProgress) fail(url string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.cache.Failed = append(p.cache.Failed, url)
+	return p.cache.Update(p.caching, internal.JobStatusProcessing)
+}
+
+// renderSitemapEntry renders url through the scheduler and saves it to cache,
+// sharing the render with on-demand requests of the same page. The returned bool
+// reports whether the render was shared.
+func (h *Handler) renderSitemapEntry(
+	ctx context.Context,
+	config *viper.Viper,
+	url, priority string,
+) (*renderer.RenderResult, bool, error) {
+	key, err := RenderKey(url, false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	render := func(ctx context.Context) (*renderer.RenderResult, error) {
+		job, err := NewRenderJob(ctx, url, false, priority)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.Scheduler.Enqueue(ctx, job); err != nil {
+			return nil, err
+		}
+		result, err := job.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := CachePage(ctx, h.DB, config, url, result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	return h.Renders.Do(ctx, key, render)
+}
+
+// sitemapJobEntries returns the entries of a sitemap job in the given mode, the
+// entries are queued, or skipped if their page cache is up to date.
+func (h *Handler) sitemapJobEntries(
+	entries []internal.SitemapEntry,
+	mode string,
+) ([]wrender.JobEntryResult, error) {
+	results := make([]wrender.JobEntryResult, 0, len(entries))
+	for _, entry := range entries {
+		result := wrender.JobEntryResult{
+			TargetUrl: entry.Loc,
+			Status:    internal.JobStatusQueued,
+		}
+		if mode != "" && mode != internal.SitemapModeFull {
+			created, cached, err := h.pageCacheCreated(entry.Loc)
+			if err != nil {
+				return nil, err
+			}
+			if !entry.NeedsRender(mode, cached, created) {
+				result.Status = internal.JobStatusSkipped
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// pageCacheCreated returns the creation time of the page cache of url, false is
+// returned if the page has no valid cache.
+func (h *Handler) pageCacheCreated(url string) (time.Time, bool, error) {
+	caching, err := wrender.NewBoltCaching(h.DB, url, wrender.CachedPagePrefix, false)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	content, err := caching.Read()
+	if err != nil {
+		var werr *wrender.CacheNotFoundError
+		if errors.As(err, &werr) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+
+	var cached wrender.PageCached
+	if err := json.Unmarshal(content, &cached); err != nil {
+		return time.Time{}, false, err
+	}
+	if cached.IsExpired() {
+		return time.Time{}, false, nil
+	}
+	return cached.Created, true, nil
+}
+
+func newJobCaching(h *Handler, category, jobKey string) (wrender.BoltCaching, error) {
+	param := fmt.Sprintf("%s/%s", category, jobKey)
+	h.Logger.Debug(fmt.Sprintf("Job Caching param: %s", param))
+	return wrender.NewBoltCaching(h.DB, param, wrender.CachedJobPrefix, false)
+}
@@ -0,0 +1,213 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/renderer"
+	"github.com/liuminhaw/wrenderer/wrender"
+	"github.com/spf13/viper"
+)
+
+// fakeRenderWorkers takes the jobs of the scheduler of h with the given number
+// of workers, the urls ending with /fail render as a server error page. The
+// returned func stops the workers and returns the most renders seen at a time.
+func fakeRenderWorkers(t *testing.T, h *Handler, workers int) func() int {
+	t.Helper()
+
+	var mu sync.Mutex
+	var running, most int
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, ok := h.Scheduler.Next()
+				if !ok {
+					return
+				}
+				mu.Lock()
+				running++
+				most = max(most, running)
+				mu.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+				statusCode := 200
+				if strings.HasSuffix(job.Url, "/fail") {
+					statusCode = 500
+				}
+				job.Result <- RenderJobResult{
+					Result: &renderer.RenderResult{Content: []byte("<html></html>"), StatusCode: statusCode},
+				}
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+				h.Scheduler.Done(job)
+			}
+		}()
+	}
+
+	return func() int {
+		h.Scheduler.Close()
+		wg.Wait()
+		return most
+	}
+}
+
+func TestProcessJobQueueConcurrency(t *testing.T) {
+	h := newTestHandler(t)
+	h.ErrorChan = make(chan error, 16)
+	h.Renders = NewRenderGroup()
+	h.Scheduler = NewScheduler(SchedulerOption{Capacity: 16, Workers: 8})
+	stop := fakeRenderWorkers(t, h, 8)
+	config := viper.New()
+	config.Set("cache.durationInMinutes", 10)
+
+	var entries []wrender.JobEntryResult
+	var wantFailed []string
+	for i := range 8 {
+		url := fmt.Sprintf("https://a.com/%d", i)
+		if i%4 == 0 {
+			url += "/fail"
+			wantFailed = append(wantFailed, url)
+		}
+		entries = append(entries, wrender.JobEntryResult{TargetUrl: url, Status: internal.JobStatusQueued})
+	}
+	queue := wrender.NewBoltJobQueue(h.DB, internal.SitemapCategory, "abcdef-ghijkl")
+	if err := queue.Create(SitemapJob{}, entries); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	jobCaching, err := newJobCaching(h, internal.SitemapCategory, "abcdef-ghijkl")
+	if err != nil {
+		t.Fatalf("newJobCaching() error: %v", err)
+	}
+	jobCache := wrender.NewSitemapJobCache(internal.JobStatusProcessing, time.Minute)
+
+	run := queueJob{priority: PriorityBulk, concurrency: 3}
+	h.processJobQueue(context.Background(), config, run, queue, jobCaching, &jobCache)
+
+	// The renders of the job are bounded by the job concurrency
+	if most := stop(); most != run.concurrency {
+		t.Errorf("renders at a time = %d, want %d", most, run.concurrency)
+	}
+
+	// Every failure is recorded despite the concurrent updates
+	if jobCache.Status != internal.JobStatusFailed {
+		t.Errorf("job status = %s, want %s", jobCache.Status, internal.JobStatusFailed)
+	}
+	failed := slices.Clone(jobCache.Failed)
+	slices.Sort(failed)
+	if !slices.Equal(failed, wantFailed) {
+		t.Errorf("job failed urls = %v, want %v", failed, wantFailed)
+	}
+	progress, err := queue.Progress(jobCache.Created, jobCache.Finished)
+	if err != nil {
+		t.Fatalf("Progress() error: %v", err)
+	}
+	if progress.Succeeded != len(entries)-len(wantFailed) || progress.Failed != len(wantFailed) ||
+		progress.Queued != 0 || progress.Processing != 0 {
+		t.Errorf("job progress = %+v", progress)
+	}
+}
+
+func TestSitemapJobEntries(t *testing.T) {
+	h := newTestHandler(t)
+	config := viper.New()
+	config.Set("cache.durationInMinutes", 10)
+
+	cachedUrl := "https://a.com/cached"
+	result := &renderer.RenderResult{Content: []byte("<html></html>"), StatusCode: 200}
+	if err := CachePage(context.Background(), h.DB, config, cachedUrl, result); err != nil {
+		t.Fatalf("CachePage() error: %v", err)
+	}
+	// The cached page listed as unmodified, then as modified since its render
+	entries := []internal.SitemapEntry{
+		{Loc: cachedUrl, Lastmod: time.Now().Add(-time.Hour)},
+		{Loc: cachedUrl, Lastmod: time.Now().Add(time.Hour)},
+		{Loc: "https://a.com/missing"},
+	}
+
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{internal.SitemapModeFull, []string{internal.JobStatusQueued, internal.JobStatusQueued, internal.JobStatusQueued}},
+		{internal.SitemapModeMissingOnly, []string{internal.JobStatusSkipped, internal.JobStatusSkipped, internal.JobStatusQueued}},
+		{internal.SitemapModeIncremental, []string{internal.JobStatusSkipped, internal.JobStatusQueued, internal.JobStatusQueued}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			results, err := h.sitemapJobEntries(entries, tt.mode)
+			if err != nil {
+				t.Fatalf("sitemapJobEntries() error: %v", err)
+			}
+			var got []string
+			for _, result := range results {
+				got = append(got, result.Status)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("sitemapJobEntries() statuses = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSitemapJobNormalizeUrls(t *testing.T) {
+	config := viper.New()
+	config.Set("sitemap.maxUrls", 2)
+	config.Set("semaphore.jobConcurrency", 4)
+
+	tests := []struct {
+		name  string
+		job   SitemapJob
+		param string
+	}{
+		{"batch", SitemapJob{Urls: []string{"https://a.com/1", "https://a.com/2"}}, ""},
+		{"sitemap", SitemapJob{SitemapUrl: "https://a.com/sitemap.xml"}, ""},
+		{"over max urls", SitemapJob{Urls: []string{"https://a.com/1", "https://a.com/2", "https://a.com/3"}}, "urls"},
+		{"invalid url", SitemapJob{Urls: []string{"https://a.com/1", "a.com/2"}}, "urls"},
+		{"urls and sitemap", SitemapJob{SitemapUrl: "https://a.com/sitemap.xml", Urls: []string{"https://a.com/1"}}, "urls"},
+		{"neither", SitemapJob{}, "sitemap url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := tt.job
+			err := job.Normalize(config)
+			if tt.param == "" {
+				if err != nil {
+					t.Fatalf("Normalize() error: %v", err)
+				}
+				if job.Priority != PriorityBulk || job.Concurrency != 4 || job.Mode != internal.SitemapModeFull {
+					t.Errorf("Normalize() defaults = %+v", job)
+				}
+				return
+			}
+			var serr *InvalidSitemapJobError
+			if !errors.As(err, &serr) || serr.Param != tt.param {
+				t.Errorf("Normalize() error = %v, want InvalidSitemapJobError on %s", err, tt.param)
+			}
+		})
+	}
+}
+
+func TestSitemapJobCategory(t *testing.T) {
+	batch := SitemapJob{Urls: []string{"https://a.com/1"}}
+	if got := batch.category(); got != internal.BatchCategory {
+		t.Errorf("category() of a batch job = %s, want %s", got, internal.BatchCategory)
+	}
+	sitemap := SitemapJob{SitemapUrl: "https://a.com/sitemap.xml"}
+	if got := sitemap.category(); got != internal.SitemapCategory {
+		t.Errorf("category() of a sitemap job = %s, want %s", got, internal.SitemapCategory)
+	}
+}
@@ -0,0 +1,445 @@
+package upAndRunWorker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/wrender"
+	"github.com/spf13/viper"
+)
+
+// warmupHistorySize is the number of runs kept in the history of a warmup.
+const warmupHistorySize = 20
+
+// warmupKeyLength is the length of both parts of a generated warmup id.
+const warmupKeyLength = 4
+
+// Outcomes of a warmup run.
+const (
+	WarmupRunSubmitted = "submitted"
+	WarmupRunSkipped   = "skipped"
+	WarmupRunFailed    = "failed"
+)
+
+// ErrWarmupNotFound is returned for an unknown warmup id.
+var ErrWarmupNotFound = errors.New("warmup not found")
+
+// WarmupSchedule submits the sitemap job on the Cron expression, evaluated in the
+// server time zone, or every IntervalInMinutes.
+type WarmupSchedule struct {
+	Id string `json:"id"`
+	SitemapJob
+	Cron              string `json:"cron,omitempty"`
+	IntervalInMinutes int    `json:"intervalInMinutes,omitempty"`
+	Paused            bool   `json:"paused"`
+	// Configured is set for the warmups read from config, they are created again
+	// on startup if removed
+	Configured bool        `json:"configured"`
+	NextRun    *time.Time  `json:"nextRun,omitempty"`
+	History    []WarmupRun `json:"history"`
+
+	cron *internal.CronSchedule
+}
+
+// WarmupRun is a run of a warmup, JobId is the key of the submitted sitemap job.
+type WarmupRun struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	JobId  string    `json:"jobId,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// warmupConfig is a warmup in the warmup.schedules config list.
+type warmupConfig struct {
+	Id                string
+	SitemapUrl        string
+	Priority          string
+	Concurrency       int
+	Mode              string
+	Include           []string
+	Exclude           []string
+	MinPriority       float64
+	MaxUrls           int
+	Order             string
+	Cron              string
+	IntervalInMinutes int
+}
+
+// Warmups runs the warmup schedules, persisted in the bolt database. A warmup
+// run is skipped while the job of its previous run is still running.
+type Warmups struct {
+	handler *Handler
+	config  *viper.Viper
+	records wrender.BoltRecords
+
+	// persistMu orders the writes of the records, it is taken before mu
+	persistMu sync.Mutex
+	mu        sync.Mutex
+	schedules map[string]*WarmupSchedule
+	// changed is closed and replaced when the schedules change
+	changed chan struct{}
+}
+
+// NewWarmups loads the persisted warmups and the warmups of config, the sitemap
+// jobs are submitted through h.
+func NewWarmups(h *Handler, config *viper.Viper) (*Warmups, error) {
+	w := &Warmups{
+		handler:   h,
+		config:    config,
+		records:   wrender.NewBoltRecords(h.DB, wrender.WarmupPrefix),
+		schedules: map[string]*WarmupSchedule{},
+		changed:   make(chan struct{}),
+	}
+
+	records, err := w.records.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		var schedule WarmupSchedule
+		if err := json.Unmarshal(record, &schedule); err != nil {
+			return nil, fmt.Errorf("load warmups: %w", err)
+		}
+		if err := w.prepare(&schedule); err != nil {
+			h.Logger.Error(
+				"Invalid stored warmup",
+				slog.String("id", schedule.Id),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		w.schedules[schedule.Id] = &schedule
+	}
+
+	var configured []warmupConfig
+	if err := config.UnmarshalKey("warmup.schedules", &configured); err != nil {
+		return nil, fmt.Errorf("load warmups: %w", err)
+	}
+	for _, c := range configured {
+		if c.Id == "" {
+			return nil, fmt.Errorf("load warmups: missing id of %s warmup", c.SitemapUrl)
+		}
+		schedule := WarmupSchedule{
+			Id: c.Id,
+			SitemapJob: SitemapJob{
+				SitemapUrl:  c.SitemapUrl,
+				Priority:    c.Priority,
+				Concurrency: c.Concurrency,
+				Mode:        c.Mode,
+
+				SitemapFilter: internal.SitemapFilter{
+					UrlFilter:   internal.UrlFilter{Include: c.Include, Exclude: c.Exclude},
+					MinPriority: c.MinPriority,
+					MaxUrls:     c.MaxUrls,
+					Order:       c.Order,
+				},
+			},
+			Cron:              c.Cron,
+			IntervalInMinutes: c.IntervalInMinutes,
+			Configured:        true,
+		}
+		// The config defines the warmup, its state is kept
+		if stored, ok := w.schedules[c.Id]; ok {
+			schedule.Paused = stored.Paused
+			schedule.History = stored.History
+		}
+		if err := w.prepare(&schedule); err != nil {
+			return nil, fmt.Errorf("load warmups: %s: %w", c.Id, err)
+		}
+		if err := w.records.Put(schedule.Id, schedule); err != nil {
+			return nil, err
+		}
+		w.schedules[schedule.Id] = &schedule
+	}
+
+	return w, nil
+}
+
+// prepare validates the schedule and sets its next run if not set.
+func (w *Warmups) prepare(schedule *WarmupSchedule) error {
+	if err := schedule.Normalize(w.config); err != nil {
+		return err
+	}
+
+	// Either a cron expression or an interval is set
+	switch {
+	case schedule.Cron != "" && schedule.IntervalInMinutes != 0:
+		return &InvalidSitemapJobError{Param: "schedule"}
+	case schedule.Cron != "":
+		cron, err := internal.ParseCron(schedule.Cron)
+		if err != nil {
+			return &InvalidSitemapJobError{Param: "cron"}
+		}
+		schedule.cron = cron
+	case schedule.IntervalInMinutes <= 0:
+		return &InvalidSitemapJobError{Param: "schedule"}
+	}
+
+	if schedule.History == nil {
+		schedule.History = []WarmupRun{}
+	}
+	if schedule.NextRun == nil {
+		schedule.setNextRun(time.Now())
+	}
+	return nil
+}
+
+// setNextRun sets the next run of the schedule following t.
+func (s *WarmupSchedule) setNextRun(t time.Time) {
+	var next time.Time
+	if s.cron != nil {
+		next = s.cron.Next(t)
+	} else {
+		next = t.Add(time.Duration(s.IntervalInMinutes) * time.Minute)
+	}
+
+	if next.IsZero() {
+		s.NextRun = nil
+		return
+	}
+	next = next.UTC()
+	s.NextRun = &next
+}
+
+// lastJobId returns the key of the job submitted by the last run of the schedule.
+func (s *WarmupSchedule) lastJobId() string {
+	for i := len(s.History) - 1; i >= 0; i-- {
+		if s.History[i].Status == WarmupRunSubmitted {
+			return s.History[i].JobId
+		}
+	}
+	return ""
+}
+
+// Run submits the sitemap jobs of the warmups when they are due, until ctx is
+// done.
+func (w *Warmups) Run(ctx context.Context) {
+	w.handler.Logger.Debug("Warmups started")
+	for {
+		w.mu.Lock()
+		changed := w.changed
+		var next time.Time
+		for _, schedule := range w.schedules {
+			if schedule.Paused || schedule.NextRun == nil {
+				continue
+			}
+			if next.IsZero() || schedule.NextRun.Before(next) {
+				next = *schedule.NextRun
+			}
+		}
+		w.mu.Unlock()
+
+		// Without warmup due, Run waits for a schedule change
+		var due <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			w.handler.Logger.Debug("Warmups stopped")
+			return
+		case <-changed:
+		case <-due:
+			w.runDue(time.Now())
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// dueWarmup is a warmup due to run, with what its run needs.
+type dueWarmup struct {
+	id        string
+	job       SitemapJob
+	lastJobId string
+}
+
+// runDue runs the warmups due at now. The due warmups are collected under the
+// lock, their jobs are submitted and their runs recorded without holding it.
+func (w *Warmups) runDue(now time.Time) {
+	w.mu.Lock()
+	var due []dueWarmup
+	for _, schedule := range w.schedules {
+		if schedule.Paused || schedule.NextRun == nil || schedule.NextRun.After(now) {
+			continue
+		}
+		due = append(due, dueWarmup{
+			id:        schedule.Id,
+			job:       schedule.SitemapJob,
+			lastJobId: schedule.lastJobId(),
+		})
+	}
+	w.mu.Unlock()
+
+	for _, warmup := range due {
+		run := w.submit(warmup)
+		w.handler.Logger.Info(
+			"Warmup run",
+			slog.String("id", warmup.id),
+			slog.String("status", run.Status),
+			slog.String("jobId", run.JobId),
+			slog.String("reason", run.Reason),
+		)
+		if err := w.record(warmup.id, run, now); err != nil {
+			err := HandlerError{source: "warmups worker", err: err}
+			w.handler.ErrorChan <- &err
+		}
+	}
+}
+
+// submit submits the sitemap job of the due warmup unless its previous job is
+// still running.
+func (w *Warmups) submit(warmup dueWarmup) WarmupRun {
+	run := WarmupRun{Time: time.Now().UTC()}
+	if warmup.lastJobId != "" && w.handler.Jobs.Active(internal.SitemapCategory, warmup.lastJobId) {
+		run.Status = WarmupRunSkipped
+		run.Reason = fmt.Sprintf("previous job %s still running", warmup.lastJobId)
+		return run
+	}
+
+	jobId, err := w.handler.SubmitSitemap(w.config, warmup.job)
+	switch {
+	case errors.Is(err, ErrSitemapJobsFull):
+		run.Status = WarmupRunSkipped
+		run.Reason = err.Error()
+	case err != nil:
+		run.Status = WarmupRunFailed
+		run.Reason = err.Error()
+	default:
+		run.Status = WarmupRunSubmitted
+		run.JobId = jobId
+	}
+	return run
+}
+
+// record appends run to the history of the warmup of id, sets its next run
+// following now and persists it. The run is dropped if the warmup was removed
+// meanwhile.
+func (w *Warmups) record(id string, run WarmupRun, now time.Time) error {
+	w.persistMu.Lock()
+	defer w.persistMu.Unlock()
+
+	w.mu.Lock()
+	schedule, ok := w.schedules[id]
+	if !ok {
+		w.mu.Unlock()
+		return nil
+	}
+	schedule.History = append(schedule.History, run)
+	if len(schedule.History) > warmupHistorySize {
+		schedule.History = schedule.History[len(schedule.History)-warmupHistorySize:]
+	}
+	schedule.setNextRun(now)
+	record := *schedule
+	w.mu.Unlock()
+
+	return w.records.Put(id, record)
+}
+
+// List returns the warmups ordered by id.
+func (w *Warmups) List() []WarmupSchedule {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	schedules := make([]WarmupSchedule, 0, len(w.schedules))
+	for _, schedule := range w.schedules {
+		schedules = append(schedules, *schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Id < schedules[j].Id })
+
+	return schedules
+}
+
+// Add validates and persists a new warmup, an id is generated if it is empty. An
+// existing warmup with the same id is replaced, its history is kept.
+func (w *Warmups) Add(schedule WarmupSchedule) (WarmupSchedule, error) {
+	if schedule.Id == "" {
+		id, err := wrender.RandomKey(warmupKeyLength, warmupKeyLength)
+		if err != nil {
+			return WarmupSchedule{}, err
+		}
+		schedule.Id = id
+	}
+	schedule.Configured = false
+	schedule.NextRun = nil
+	schedule.History = nil
+	if err := w.prepare(&schedule); err != nil {
+		return WarmupSchedule{}, err
+	}
+
+	w.persistMu.Lock()
+	defer w.persistMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if stored, ok := w.schedules[schedule.Id]; ok {
+		schedule.History = stored.History
+	}
+	if err := w.records.Put(schedule.Id, schedule); err != nil {
+		return WarmupSchedule{}, err
+	}
+	w.schedules[schedule.Id] = &schedule
+	w.notify()
+
+	return schedule, nil
+}
+
+// SetPaused pauses or resumes the warmup of id, a resumed warmup runs next
+// following the current time.
+func (w *Warmups) SetPaused(id string, paused bool) (WarmupSchedule, error) {
+	w.persistMu.Lock()
+	defer w.persistMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	schedule, ok := w.schedules[id]
+	if !ok {
+		return WarmupSchedule{}, ErrWarmupNotFound
+	}
+	if schedule.Paused && !paused {
+		schedule.setNextRun(time.Now())
+	}
+	schedule.Paused = paused
+	if err := w.records.Put(schedule.Id, schedule); err != nil {
+		return WarmupSchedule{}, err
+	}
+	w.notify()
+
+	return *schedule, nil
+}
+
+// Remove removes the warmup of id, the running job of the warmup is not stopped.
+func (w *Warmups) Remove(id string) error {
+	w.persistMu.Lock()
+	defer w.persistMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.schedules[id]; !ok {
+		return ErrWarmupNotFound
+	}
+	if err := w.records.Delete(id); err != nil {
+		return err
+	}
+	delete(w.schedules, id)
+	w.notify()
+
+	return nil
+}
+
+// notify wakes up Run on a schedule change, w.mu must be held.
+func (w *Warmups) notify() {
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
@@ -0,0 +1,89 @@
+package upAndRunWorker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/wrender"
+	"github.com/spf13/viper"
+)
+
+// newTestWarmups returns warmups on a temporary bolt database, submitting through
+// a handler without free sitemap job slot.
+func newTestWarmups(t *testing.T, schedules ...WarmupSchedule) *Warmups {
+	t.Helper()
+
+	h := newTestHandler(t)
+	h.Semaphore = make(chan struct{})
+	w := &Warmups{
+		handler:   h,
+		config:    viper.New(),
+		records:   wrender.NewBoltRecords(h.DB, wrender.WarmupPrefix),
+		schedules: map[string]*WarmupSchedule{},
+		changed:   make(chan struct{}),
+	}
+	for _, schedule := range schedules {
+		w.schedules[schedule.Id] = &schedule
+	}
+	return w
+}
+
+func TestWarmupsRunDue(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute).UTC()
+	future := now.Add(time.Hour).UTC()
+	w := newTestWarmups(
+		t,
+		WarmupSchedule{Id: "due", IntervalInMinutes: 10, NextRun: &past, History: []WarmupRun{}},
+		WarmupSchedule{Id: "later", IntervalInMinutes: 10, NextRun: &future, History: []WarmupRun{}},
+		WarmupSchedule{Id: "paused", IntervalInMinutes: 10, NextRun: &past, Paused: true, History: []WarmupRun{}},
+	)
+
+	w.runDue(now)
+
+	due := w.schedules["due"]
+	if len(due.History) != 1 || due.History[0].Status != WarmupRunSkipped {
+		t.Fatalf("due history = %+v, want one skipped run", due.History)
+	}
+	if want := now.Add(10 * time.Minute); due.NextRun == nil || !due.NextRun.Equal(want) {
+		t.Errorf("due next run = %v, want %v", due.NextRun, want)
+	}
+	for _, id := range []string{"later", "paused"} {
+		if len(w.schedules[id].History) != 0 {
+			t.Errorf("%s history = %+v, want no run", id, w.schedules[id].History)
+		}
+	}
+
+	records, err := w.records.List()
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("List() returned %d records, want 1", len(records))
+	}
+	var stored WarmupSchedule
+	if err := json.Unmarshal(records[0], &stored); err != nil {
+		t.Fatalf("unmarshal record: %v", err)
+	}
+	if stored.Id != "due" || len(stored.History) != 1 {
+		t.Errorf("stored warmup = %+v, want due with one run", stored)
+	}
+}
+
+func TestWarmupsRecordRemoved(t *testing.T) {
+	w := newTestWarmups(t)
+
+	run := WarmupRun{Time: time.Now().UTC(), Status: WarmupRunSubmitted, JobId: "abcdef-ghijkl"}
+	if err := w.record("removed", run, time.Now()); err != nil {
+		t.Fatalf("record() error: %v", err)
+	}
+
+	records, err := w.records.List()
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("run of a removed warmup persisted: %d records", len(records))
+	}
+}
@@ -6,6 +6,10 @@ import requests
 WRENDERER_TOKEN = 'Your wrenderer api token'
 WRENDERER_DOMAIN = 'your.wrenderer.domain'
 
+# Lambda@Edge limit of the body of a response generated by an origin request
+# function
+MAX_GENERATED_BODY_SIZE = 1024 * 1024
+
 
 def lambda_handler(event, context):
     request = event['Records'][0]['cf']['request']
@@ -29,10 +33,37 @@ def lambda_handler(event, context):
         print(f'Error code: {resp.status_code}, message: {resp.text}')
         return request
 
-    # Update request to rendered result in s3
     resp_data = resp.json()
+
+    # Respond redirect to the bot when wrenderer runs in respond redirect mode
+    if 'location' in resp_data:
+        redirect_response = {
+            'status': str(resp_data['statusCode']),
+            'headers': {
+                'location': [
+                    {
+                        'key': 'Location',
+                        'value': resp_data['location']
+                    }
+                ]
+            }
+        }
+        print('Output result: ' + json.dumps(redirect_response))
+        return redirect_response
+
     rendered_path = resp_data['path']
 
+    # The rendered result in s3 is served with 200, error pages are answered with
+    # their captured status code instead
+    status_code = resp_data['statusCode']
+    if not 200 <= status_code < 300:
+        error_response = status_response(status_code, rendered_path)
+        if error_response is not None:
+            print(f'Output result: status {status_code}, path {rendered_path}')
+            return error_response
+
+    # Update request to rendered result in s3
+
     headers['host'] = [
         {
             'key': 'host',
@@ -55,3 +86,31 @@ def lambda_handler(event, context):
     request['uri'] = f'/{rendered_path}'
     print('Output result: ' + json.dumps(request))
     return request
+
+
+def status_response(status_code, rendered_path):
+    """Generate a response of the rendered page with its captured status code.
+
+    None is returned if the rendered page cannot be read or is too large for a
+    generated response, the request then falls back to the rendered result in s3.
+    """
+    page = requests.get(f'https://{WRENDERER_DOMAIN}/{rendered_path}')
+    if page.status_code != 200:
+        print(f'Error code: {page.status_code}, reading rendered page {rendered_path}')
+        return None
+    if len(page.content) > MAX_GENERATED_BODY_SIZE:
+        print(f'Rendered page {rendered_path} too large for a generated response')
+        return None
+
+    return {
+        'status': str(status_code),
+        'headers': {
+            'content-type': [
+                {
+                    'key': 'Content-Type',
+                    'value': page.headers.get('content-type', 'text/html; charset=utf-8')
+                }
+            ]
+        },
+        'body': page.text
+    }
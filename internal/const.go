@@ -1,12 +1,18 @@
 package internal
 
 const (
-	JobStatusUnknown    = "unknown"
-	JobStatusQueued     = "queued"
-	JobStatusFailed     = "failed"
-	JobStatusProcessing = "processing"
-	JobStatusCompleted  = "completed"
-	JobStatusTimeout    = "timeout"
+	JobStatusUnknown     = "unknown"
+	JobStatusQueued      = "queued"
+	JobStatusFailed      = "failed"
+	JobStatusProcessing  = "processing"
+	JobStatusCompleted   = "completed"
+	JobStatusTimeout     = "timeout"
+	JobStatusCancelled   = "cancelled"
+	JobStatusInterrupted = "interrupted"
+	JobStatusSucceeded   = "succeeded"
+	JobStatusSkipped     = "skipped"
 
 	SitemapCategory = "sitemap"
+	BatchCategory   = "batch"
+	CrawlCategory   = "crawl"
 )
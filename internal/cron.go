@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed standard cron expression with the minute, hour, day
+// of month, month and day of week fields.
+type CronSchedule struct {
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+	// anyDay and anyWeekday are set for the "*" day fields, the day matches both
+	// day fields only if one of them is "*"
+	anyDay     bool
+	anyWeekday bool
+}
+
+// cronSearchLimit bounds the search of the next time of a schedule, which never
+// matches if no time matches within it (e.g. February 30).
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// ParseCron parses a five fields cron expression. Each field accepts "*", values,
+// ranges ("1-5"), steps ("*/15", "0-30/10") and lists of them ("1,15"). Day of
+// week 7 is Sunday as 0.
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("parse cron %q: expected 5 fields", expr)
+	}
+
+	var s CronSchedule
+	var weekdays [8]bool
+	specs := []struct {
+		field    string
+		min, max int
+		set      []bool
+	}{
+		{fields[0], 0, 59, s.minutes[:]},
+		{fields[1], 0, 23, s.hours[:]},
+		{fields[2], 1, 31, s.days[:]},
+		{fields[3], 1, 12, s.months[:]},
+		{fields[4], 0, 7, weekdays[:]},
+	}
+	for _, spec := range specs {
+		if err := parseCronField(spec.field, spec.min, spec.max, spec.set); err != nil {
+			return nil, fmt.Errorf("parse cron %q: %w", expr, err)
+		}
+	}
+	copy(s.weekdays[:], weekdays[:7])
+	s.weekdays[0] = s.weekdays[0] || weekdays[7]
+	s.anyDay = fields[2] == "*"
+	s.anyWeekday = fields[4] == "*"
+
+	return &s, nil
+}
+
+func parseCronField(field string, min, max int, set []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		start, end := min, max
+		if rangePart != "*" {
+			low, high, isRange := strings.Cut(rangePart, "-")
+			var err error
+			start, err = strconv.Atoi(low)
+			if err != nil {
+				return fmt.Errorf("invalid value %q", part)
+			}
+			end = start
+			if isRange {
+				end, err = strconv.Atoi(high)
+				if err != nil {
+					return fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return fmt.Errorf("value out of range %q", part)
+		}
+
+		for i := start; i <= end; i += step {
+			set[i] = true
+		}
+	}
+
+	return nil
+}
+
+// Next returns the first time matching the schedule after t, in the location of
+// t. The zero time is returned if no time matches.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		switch {
+		case !s.months[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hours[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// matchDay follows the cron rule: when both day fields are restricted, a day
+// matching either of them matches.
+func (s *CronSchedule) matchDay(t time.Time) bool {
+	day := s.days[t.Day()]
+	weekday := s.weekdays[t.Weekday()]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DomainLimits caps the render load on a single domain. MaxConcurrent is the
+// number of renders running at once, RatePerSecond the number of renders started
+// per second and Delay the pause between the end of a render and the start of
+// the next one. Zero disables the limit.
+type DomainLimits struct {
+	MaxConcurrent int
+	RatePerSecond float64
+	Delay         time.Duration
+}
+
+// Enabled reports whether any limit is set.
+func (l DomainLimits) Enabled() bool {
+	return l.MaxConcurrent > 0 || l.RatePerSecond > 0 || l.Delay > 0
+}
+
+// Spacing returns the shortest time between the starts of two renders of a
+// domain allowed by RatePerSecond and Delay, Delay counting from the start of a
+// render instead of its end. Zero means the starts are not limited.
+func (l DomainLimits) Spacing() time.Duration {
+	var spacing time.Duration
+	if l.RatePerSecond > 0 {
+		spacing = time.Duration(float64(time.Second) / l.RatePerSecond)
+	}
+	return max(spacing, l.Delay)
+}
+
+// domainSweepInterval is the interval between the removals of the idle domain
+// states of a DomainLimiter.
+const domainSweepInterval = time.Minute
+
+// DomainLimiter applies DomainLimits to each domain separately. The state of a
+// domain without running render and past its limits is removed.
+type DomainLimiter struct {
+	limits DomainLimits
+
+	mu        sync.Mutex
+	domains   map[string]*domainState
+	lastSweep time.Time
+}
+
+type domainState struct {
+	running    int
+	lastStart  time.Time
+	lastFinish time.Time
+}
+
+// NewDomainLimiter creates a DomainLimiter with the given limits.
+func NewDomainLimiter(limits DomainLimits) *DomainLimiter {
+	return &DomainLimiter{limits: limits, domains: map[string]*domainState{}}
+}
+
+// Acquire starts a render of domain if the limits allow it, otherwise it returns
+// false and the time to wait before trying again. A zero wait means the domain is
+// at its concurrency limit, and Release should be waited for instead.
+func (l *DomainLimiter) Acquire(domain string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.state(domain)
+	if l.limits.MaxConcurrent > 0 && state.running >= l.limits.MaxConcurrent {
+		return false, 0
+	}
+	if wait := time.Until(l.nextStart(state)); wait > 0 {
+		return false, wait
+	}
+
+	l.start(state)
+	return true, 0
+}
+
+// Force starts a render of domain regardless of the limits, the render still
+// counts toward the limits of later renders.
+func (l *DomainLimiter) Force(domain string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.start(l.state(domain))
+}
+
+// Release ends a render of domain started by Acquire or Force.
+func (l *DomainLimiter) Release(domain string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.state(domain)
+	if state.running > 0 {
+		state.running--
+	}
+	state.lastFinish = time.Now()
+}
+
+// Wait blocks until a render of domain can start and starts it, ctx.Err() is
+// returned if ctx is done first.
+func (l *DomainLimiter) Wait(ctx context.Context, domain string) error {
+	for {
+		ok, wait := l.Acquire(domain)
+		if ok {
+			return nil
+		}
+		if wait <= 0 {
+			// Poll while the domain is at its concurrency limit
+			wait = 100 * time.Millisecond
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *DomainLimiter) state(domain string) *domainState {
+	if now := time.Now(); now.Sub(l.lastSweep) >= domainSweepInterval {
+		l.sweep(now)
+	}
+
+	state, ok := l.domains[domain]
+	if !ok {
+		state = &domainState{}
+		l.domains[domain] = state
+	}
+	return state
+}
+
+// sweep removes the states of the domains which are idle at now, l.mu must be
+// held.
+func (l *DomainLimiter) sweep(now time.Time) {
+	for domain, state := range l.domains {
+		if state.running == 0 && !now.Before(l.nextStart(state)) {
+			delete(l.domains, domain)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Domains returns the number of domains with a state kept by the limiter.
+func (l *DomainLimiter) Domains() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.domains)
+}
+
+func (l *DomainLimiter) start(state *domainState) {
+	state.running++
+	state.lastStart = time.Now()
+}
+
+// nextStart returns the earliest time the next render of the domain may start.
+func (l *DomainLimiter) nextStart(state *domainState) time.Time {
+	var next time.Time
+	if l.limits.RatePerSecond > 0 && !state.lastStart.IsZero() {
+		next = state.lastStart.Add(time.Duration(float64(time.Second) / l.limits.RatePerSecond))
+	}
+	if l.limits.Delay > 0 && !state.lastFinish.IsZero() {
+		if delayed := state.lastFinish.Add(l.limits.Delay); delayed.After(next) {
+			next = delayed
+		}
+	}
+	return next
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDomainLimitsSpacing(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits DomainLimits
+		want   time.Duration
+	}{
+		{"disabled", DomainLimits{}, 0},
+		{"concurrency only", DomainLimits{MaxConcurrent: 2}, 0},
+		{"rate", DomainLimits{RatePerSecond: 4}, 250 * time.Millisecond},
+		{"delay", DomainLimits{Delay: time.Second}, time.Second},
+		{"rate over delay", DomainLimits{RatePerSecond: 0.5, Delay: time.Second}, 2 * time.Second},
+		{"delay over rate", DomainLimits{RatePerSecond: 10, Delay: time.Second}, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limits.Spacing(); got != tt.want {
+				t.Errorf("Spacing() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDomainLimiterAcquire(t *testing.T) {
+	l := NewDomainLimiter(DomainLimits{MaxConcurrent: 1, RatePerSecond: 1})
+
+	if ok, _ := l.Acquire("a.com"); !ok {
+		t.Fatal("first acquire on a.com refused")
+	}
+	if ok, wait := l.Acquire("a.com"); ok || wait != 0 {
+		t.Errorf("acquire at concurrency limit = (%v, %v), want (false, 0)", ok, wait)
+	}
+	if ok, _ := l.Acquire("b.com"); !ok {
+		t.Error("acquire on b.com refused by the limits of a.com")
+	}
+
+	l.Release("a.com")
+	if ok, wait := l.Acquire("a.com"); ok || wait <= 0 {
+		t.Errorf("acquire within rate limit = (%v, %v), want (false, >0)", ok, wait)
+	}
+}
+
+func TestDomainLimiterSweep(t *testing.T) {
+	l := NewDomainLimiter(DomainLimits{Delay: time.Hour})
+	l.Force("running.com")
+	l.Force("delayed.com")
+	l.Release("delayed.com")
+	l.Force("idle.com")
+	l.Release("idle.com")
+
+	// Make idle.com past its delay, and the sweep due
+	l.domains["idle.com"].lastFinish = time.Now().Add(-2 * time.Hour)
+	l.lastSweep = time.Now().Add(-2 * domainSweepInterval)
+
+	if ok, _ := l.Acquire("new.com"); !ok {
+		t.Fatal("acquire on new.com refused")
+	}
+	if got := l.Domains(); got != 3 {
+		t.Errorf("Domains() = %d, want 3", got)
+	}
+	if _, ok := l.domains["idle.com"]; ok {
+		t.Error("idle.com not evicted")
+	}
+	for _, domain := range []string{"running.com", "delayed.com", "new.com"} {
+		if _, ok := l.domains[domain]; !ok {
+			t.Errorf("%s evicted", domain)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSitemapPriority is the priority of the sitemap urls without one, as
+	// defined by the sitemap protocol.
+	DefaultSitemapPriority = 0.5
+
+	// maxSitemapSize is the maximum uncompressed size of a sitemap source read.
+	maxSitemapSize = 50 << 20
+
+	// SitemapModeFull renders every url of the sitemap.
+	SitemapModeFull = "full"
+	// SitemapModeIncremental renders the urls without valid cache, and the urls
+	// modified since their cache was created following their lastmod.
+	SitemapModeIncremental = "incremental"
+	// SitemapModeMissingOnly renders the urls without valid cache.
+	SitemapModeMissingOnly = "missing-only"
+)
+
+// SupportedSitemapMode reports whether mode is a sitemap render mode.
+func SupportedSitemapMode(mode string) bool {
+	switch mode {
+	case SitemapModeFull, SitemapModeIncremental, SitemapModeMissingOnly:
+		return true
+	}
+	return false
+}
+
+// SitemapEntry is a url listed by a sitemap source. Lastmod is zero when the
+// source does not tell when the url was last modified.
+type SitemapEntry struct {
+	Loc      string
+	Lastmod  time.Time
+	Priority float64
+}
+
+// NeedsRender reports whether the entry is rendered in the given sitemap mode.
+// cached tells if the page has a valid cache, created at the given time. With the
+// incremental mode, an entry without lastmod is rendered only if not cached.
+func (e SitemapEntry) NeedsRender(mode string, cached bool, created time.Time) bool {
+	switch mode {
+	case SitemapModeMissingOnly:
+		return !cached
+	case SitemapModeIncremental:
+		return !cached || e.Lastmod.After(created)
+	default:
+		return true
+	}
+}
+
+// SitemapOption limits the sitemap sources followed by ParseSitemap. MaxDepth is
+// the number of nested sitemap indexes followed from the root sitemap, MaxUrls
+// the number of urls returned, 0 disables a limit. The child sitemaps which
+// cannot be read are logged to Logger, slog.Default() if nil.
+type SitemapOption struct {
+	MaxDepth int
+	MaxUrls  int
+	Logger   *slog.Logger
+}
+
+// ParseSitemap reads the urls listed by the sitemap source of url. Sitemaps,
+// sitemap indexes, text sitemaps (one url per line), RSS and Atom feeds are
+// supported, gzip compressed or not. The child sitemaps of a sitemap index are
+// followed recursively up to opts.MaxDepth, and the urls listed more than once
+// are only returned the first time. A child sitemap which cannot be read is
+// skipped, an error is only returned if the root sitemap cannot be read.
+func ParseSitemap(ctx context.Context, url string, opts SitemapOption) ([]SitemapEntry, error) {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	p := sitemapParser{
+		opts:     opts,
+		sitemaps: map[string]bool{},
+		seen:     map[string]bool{},
+	}
+	if err := p.parse(ctx, url, 0); err != nil {
+		return nil, fmt.Errorf("parse sitemap: %w", err)
+	}
+
+	return p.entries, nil
+}
+
+// UrlListEntries returns the entries of an explicit list of urls, with the
+// default priority. The urls listed more than once are only returned the first
+// time, an error is returned for the first invalid url.
+func UrlListEntries(urls []string) ([]SitemapEntry, error) {
+	entries := make([]SitemapEntry, 0, len(urls))
+	seen := map[string]bool{}
+	for _, url := range urls {
+		httpUrl := strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+		if !httpUrl || !ValidUrl(url) {
+			return nil, fmt.Errorf("invalid url %q", url)
+		}
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		entries = append(entries, SitemapEntry{Loc: url, Priority: DefaultSitemapPriority})
+	}
+
+	return entries, nil
+}
+
+type sitemapParser struct {
+	opts     SitemapOption
+	entries  []SitemapEntry
+	sitemaps map[string]bool
+	seen     map[string]bool
+}
+
+// full reports whether the url count limit is reached.
+func (p *sitemapParser) full() bool {
+	return p.opts.MaxUrls > 0 && len(p.entries) >= p.opts.MaxUrls
+}
+
+func (p *sitemapParser) add(entry SitemapEntry) {
+	if p.full() || !ValidUrl(entry.Loc) || p.seen[entry.Loc] {
+		return
+	}
+	p.seen[entry.Loc] = true
+	p.entries = append(p.entries, entry)
+}
+
+func (p *sitemapParser) parse(ctx context.Context, url string, depth int) error {
+	// Sitemap indexes listing each other are followed once
+	if p.sitemaps[url] {
+		return nil
+	}
+	p.sitemaps[url] = true
+
+	content, err := fetchSitemap(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		p.parseText(trimmed)
+		return nil
+	}
+
+	var doc sitemapDocument
+	if err := xml.Unmarshal(trimmed, &doc); err != nil {
+		return fmt.Errorf("%s: %w", url, err)
+	}
+	switch doc.XMLName.Local {
+	case "urlset":
+		for _, u := range doc.Urls {
+			entry := SitemapEntry{
+				Loc:      strings.TrimSpace(u.Loc),
+				Lastmod:  parseLastmod(u.Lastmod),
+				Priority: DefaultSitemapPriority,
+			}
+			if priority, err := strconv.ParseFloat(strings.TrimSpace(u.Priority), 64); err == nil {
+				entry.Priority = priority
+			}
+			p.add(entry)
+		}
+	case "sitemapindex":
+		if p.opts.MaxDepth > 0 && depth >= p.opts.MaxDepth {
+			return nil
+		}
+		for _, sitemap := range doc.Sitemaps {
+			if p.full() {
+				return nil
+			}
+			child := strings.TrimSpace(sitemap.Loc)
+			if err := p.parse(ctx, child, depth+1); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				p.opts.Logger.Warn(
+					"Child sitemap skipped",
+					slog.String("sitemap", url),
+					slog.String("child", child),
+					slog.String("error", err.Error()),
+				)
+			}
+		}
+	case "rss":
+		for _, item := range doc.Channel.Items {
+			p.add(SitemapEntry{
+				Loc:      strings.TrimSpace(item.Link),
+				Lastmod:  parseLastmod(item.PubDate),
+				Priority: DefaultSitemapPriority,
+			})
+		}
+	case "feed":
+		for _, entry := range doc.Entries {
+			p.add(SitemapEntry{
+				Loc:      entry.link(),
+				Lastmod:  parseLastmod(entry.Updated),
+				Priority: DefaultSitemapPriority,
+			})
+		}
+	default:
+		return fmt.Errorf("%s: unsupported sitemap format %q", url, doc.XMLName.Local)
+	}
+
+	return nil
+}
+
+// parseText reads a text sitemap, lines which are not urls are skipped.
+func (p *sitemapParser) parseText(content []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "http://") && !strings.HasPrefix(line, "https://") {
+			continue
+		}
+		p.add(SitemapEntry{Loc: line, Priority: DefaultSitemapPriority})
+	}
+}
+
+// fetchSitemap reads the content of the sitemap source of url, decompressing it
+// if it is gzip compressed.
+func fetchSitemap(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: unexpected status code %d", url, resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxSitemapSize))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	// .xml.gz files are served as is, without gzip content encoding
+	if bytes.HasPrefix(content, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+		content, err = io.ReadAll(io.LimitReader(reader, maxSitemapSize))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+	}
+
+	return content, nil
+}
+
+// sitemapDocument holds the elements of the supported XML sitemap sources, the
+// root element name tells which one is set.
+type sitemapDocument struct {
+	XMLName xml.Name
+	// sitemap urlset
+	Urls []struct {
+		Loc      string `xml:"loc"`
+		Lastmod  string `xml:"lastmod"`
+		Priority string `xml:"priority"`
+	} `xml:"url"`
+	// sitemap index
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+	// RSS feed
+	Channel struct {
+		Items []struct {
+			Link    string `xml:"link"`
+			PubDate string `xml:"pubDate"`
+		} `xml:"item"`
+	} `xml:"channel"`
+	// Atom feed
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Updated string `xml:"updated"`
+}
+
+// link returns the alternate link of the entry, a link without rel is alternate.
+func (e atomEntry) link() string {
+	for _, link := range e.Links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+	return ""
+}
+
+// lastmodLayouts are the W3C datetime formats of the sitemap protocol and the
+// RFC 822 dates of RSS feeds.
+var lastmodLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// parseLastmod parses a sitemap or feed date, the zero time is returned if the
+// date is missing or invalid.
+func parseLastmod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range lastmodLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
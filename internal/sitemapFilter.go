@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// SitemapOrderSitemap keeps the urls in the order of the sitemap sources.
+	SitemapOrderSitemap = "sitemap"
+	// SitemapOrderPriority orders the urls by descending priority.
+	SitemapOrderPriority = "priority"
+	// SitemapOrderLastmod orders the urls by descending lastmod, the urls without
+	// lastmod come last.
+	SitemapOrderLastmod = "lastmod"
+
+	// regexPatternPrefix marks the url patterns which are regular expressions.
+	regexPatternPrefix = "regex:"
+)
+
+// SitemapFilterError reports an invalid parameter of a sitemap filter.
+type SitemapFilterError struct {
+	Param string
+	err   error
+}
+
+func (e *SitemapFilterError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("invalid sitemap filter %s: %v", e.Param, e.err)
+	}
+	return fmt.Sprintf("invalid sitemap filter %s", e.Param)
+}
+
+func (e *SitemapFilterError) Unwrap() error {
+	return e.err
+}
+
+// UrlFilter selects urls by patterns. Include and Exclude are url patterns: globs
+// matched against the url path, where "*" matches within a path segment and "**"
+// across segments, or regular expressions prefixed with "regex:" matched against
+// the whole url. A url is kept if it matches one of the Include patterns (or
+// Include is empty) and none of the Exclude patterns.
+type UrlFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Matcher compiles the patterns of the filter and returns the function reporting
+// whether a url is kept. A *SitemapFilterError is returned for invalid patterns.
+func (f UrlFilter) Matcher() (func(loc string) bool, error) {
+	include, err := compileUrlPatterns(f.Include)
+	if err != nil {
+		return nil, &SitemapFilterError{Param: "include", err: err}
+	}
+	exclude, err := compileUrlPatterns(f.Exclude)
+	if err != nil {
+		return nil, &SitemapFilterError{Param: "exclude", err: err}
+	}
+
+	return func(loc string) bool {
+		if len(include) > 0 && !matchUrlPatterns(include, loc) {
+			return false
+		}
+		return !matchUrlPatterns(exclude, loc)
+	}, nil
+}
+
+// SitemapFilter selects the urls of a sitemap to render. A url is kept if it is
+// kept by the UrlFilter and has a priority of at least MinPriority. The kept urls
+// are ordered following Order and the first MaxUrls of them are returned, 0
+// disables the limit.
+type SitemapFilter struct {
+	UrlFilter
+	MinPriority float64 `json:"minPriority,omitempty"`
+	MaxUrls     int     `json:"maxUrls,omitempty"`
+	Order       string  `json:"order,omitempty"`
+}
+
+// Validate reports a *SitemapFilterError if a parameter of the filter is invalid.
+func (f SitemapFilter) Validate() error {
+	_, err := f.compile()
+	return err
+}
+
+// Apply returns the entries selected by the filter, in the filter order.
+func (f SitemapFilter) Apply(entries []SitemapEntry) ([]SitemapEntry, error) {
+	match, err := f.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]SitemapEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Priority < f.MinPriority || !match(entry.Loc) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+
+	switch f.Order {
+	case SitemapOrderPriority:
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return filtered[i].Priority > filtered[j].Priority
+		})
+	case SitemapOrderLastmod:
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return filtered[i].Lastmod.After(filtered[j].Lastmod)
+		})
+	}
+
+	if f.MaxUrls > 0 && len(filtered) > f.MaxUrls {
+		filtered = filtered[:f.MaxUrls]
+	}
+	return filtered, nil
+}
+
+func (f SitemapFilter) compile() (func(loc string) bool, error) {
+	switch f.Order {
+	case "", SitemapOrderSitemap, SitemapOrderPriority, SitemapOrderLastmod:
+	default:
+		return nil, &SitemapFilterError{Param: "order"}
+	}
+	if f.MinPriority < 0 || f.MinPriority > 1 {
+		return nil, &SitemapFilterError{Param: "minPriority"}
+	}
+	if f.MaxUrls < 0 {
+		return nil, &SitemapFilterError{Param: "maxUrls"}
+	}
+
+	return f.UrlFilter.Matcher()
+}
+
+// urlPattern is a compiled url pattern, path tells if it matches the url path
+// only.
+type urlPattern struct {
+	re   *regexp.Regexp
+	path bool
+}
+
+func compileUrlPatterns(patterns []string) ([]urlPattern, error) {
+	compiled := make([]urlPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		if expr, ok := strings.CutPrefix(pattern, regexPatternPrefix); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, urlPattern{re: re})
+			continue
+		}
+
+		if pattern == "" {
+			return nil, fmt.Errorf("empty pattern")
+		}
+		compiled = append(compiled, urlPattern{re: globRegexp(pattern), path: true})
+	}
+	return compiled, nil
+}
+
+// globRegexp converts a path glob to an anchored regular expression.
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func matchUrlPatterns(patterns []urlPattern, loc string) bool {
+	var path string
+	if u, err := url.Parse(loc); err == nil {
+		path = u.Path
+		if path == "" {
+			path = "/"
+		}
+	}
+
+	for _, pattern := range patterns {
+		target := loc
+		if pattern.path {
+			target = path
+		}
+		if pattern.re.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		{"/products/*", "/products/shoes", true},
+		{"/products/*", "/products/shoes/red", false},
+		{"/products/*", "/products/", true},
+		{"/products/**", "/products/shoes/red", true},
+		{"/products/**", "/product", false},
+		{"/**/detail", "/a/b/detail", true},
+		{"/page?", "/page1", true},
+		{"/page?", "/page/", false},
+		{"/page?", "/page12", false},
+		{"/a.html", "/a.html", true},
+		{"/a.html", "/aXhtml", false},
+		{"/blog", "/blog/post", false},
+		{"/(x)+", "/(x)+", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.path, func(t *testing.T) {
+			if got := globRegexp(tt.glob).MatchString(tt.path); got != tt.want {
+				t.Errorf("globRegexp(%q) match %q = %v, want %v", tt.glob, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUrlFilterMatcher(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter UrlFilter
+		loc    string
+		want   bool
+	}{
+		{"no pattern", UrlFilter{}, "https://a.com/x", true},
+		{"included", UrlFilter{Include: []string{"/products/**"}}, "https://a.com/products/1", true},
+		{"not included", UrlFilter{Include: []string{"/products/**"}}, "https://a.com/blog/1", false},
+		{"one of includes", UrlFilter{Include: []string{"/blog/*", "/products/*"}}, "https://a.com/blog/1", true},
+		{"excluded", UrlFilter{Exclude: []string{"/products/*/reviews"}}, "https://a.com/products/1/reviews", false},
+		{"exclude over include", UrlFilter{Include: []string{"/products/**"}, Exclude: []string{"/products/old/**"}}, "https://a.com/products/old/1", false},
+		{"root path", UrlFilter{Include: []string{"/"}}, "https://a.com", true},
+		{"query ignored by glob", UrlFilter{Include: []string{"/search"}}, "https://a.com/search?q=1", true},
+		{"regex on url", UrlFilter{Include: []string{`regex:^https://a\.com/`}}, "https://a.com/x", true},
+		{"regex other host", UrlFilter{Include: []string{`regex:^https://a\.com/`}}, "https://b.com/x", false},
+		{"regex on query", UrlFilter{Exclude: []string{`regex:[?&]page=`}}, "https://a.com/list?page=2", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := tt.filter.Matcher()
+			if err != nil {
+				t.Fatalf("Matcher() error: %v", err)
+			}
+			if got := match(tt.loc); got != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.loc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUrlFilterMatcherInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter UrlFilter
+		param  string
+	}{
+		{"empty include", UrlFilter{Include: []string{""}}, "include"},
+		{"invalid regex", UrlFilter{Exclude: []string{"regex:("}}, "exclude"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.filter.Matcher()
+			var ferr *SitemapFilterError
+			if !errors.As(err, &ferr) || ferr.Param != tt.param {
+				t.Errorf("Matcher() error = %v, want SitemapFilterError on %s", err, tt.param)
+			}
+		})
+	}
+}
+
+func TestSitemapFilterApply(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	entries := []SitemapEntry{
+		{Loc: "https://a.com/a", Priority: 0.2, Lastmod: day(3)},
+		{Loc: "https://a.com/b", Priority: 0.9},
+		{Loc: "https://a.com/blog/c", Priority: 0.5, Lastmod: day(5)},
+		{Loc: "https://a.com/d", Priority: 0.9, Lastmod: day(1)},
+	}
+
+	tests := []struct {
+		name   string
+		filter SitemapFilter
+		want   []string
+	}{
+		{"none", SitemapFilter{}, []string{"/a", "/b", "/blog/c", "/d"}},
+		{"min priority", SitemapFilter{MinPriority: 0.5}, []string{"/b", "/blog/c", "/d"}},
+		{"exclude", SitemapFilter{UrlFilter: UrlFilter{Exclude: []string{"/blog/**"}}}, []string{"/a", "/b", "/d"}},
+		{"priority order", SitemapFilter{Order: SitemapOrderPriority}, []string{"/b", "/d", "/blog/c", "/a"}},
+		{"lastmod order", SitemapFilter{Order: SitemapOrderLastmod}, []string{"/blog/c", "/a", "/d", "/b"}},
+		{"max urls", SitemapFilter{Order: SitemapOrderPriority, MaxUrls: 2}, []string{"/b", "/d"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered, err := tt.filter.Apply(entries)
+			if err != nil {
+				t.Fatalf("Apply() error: %v", err)
+			}
+			var got []string
+			for _, entry := range filtered {
+				got = append(got, entry.Loc[len("https://a.com"):])
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSitemapFilterValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter SitemapFilter
+		param  string
+	}{
+		{"order", SitemapFilter{Order: "random"}, "order"},
+		{"negative priority", SitemapFilter{MinPriority: -0.1}, "minPriority"},
+		{"priority over 1", SitemapFilter{MinPriority: 1.5}, "minPriority"},
+		{"max urls", SitemapFilter{MaxUrls: -1}, "maxUrls"},
+		{"pattern", SitemapFilter{UrlFilter: UrlFilter{Include: []string{"regex:["}}}, "include"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ferr *SitemapFilterError
+			if err := tt.filter.Validate(); !errors.As(err, &ferr) || ferr.Param != tt.param {
+				t.Errorf("Validate() error = %v, want SitemapFilterError on %s", err, tt.param)
+			}
+		})
+	}
+
+	if err := (SitemapFilter{Order: SitemapOrderLastmod, MinPriority: 1}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+}
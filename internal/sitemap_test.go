@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLastmod(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2025-01-15", time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{" 2025-01-15 ", time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"2025-01-15T10:30:00Z", time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)},
+		{"2025-01-15T10:30:00+08:00", time.Date(2025, 1, 15, 2, 30, 0, 0, time.UTC)},
+		{"2025-01-15T10:30:00.5Z", time.Date(2025, 1, 15, 10, 30, 0, 500000000, time.UTC)},
+		{"2025-01-15T10:30+01:00", time.Date(2025, 1, 15, 9, 30, 0, 0, time.UTC)},
+		{"Wed, 15 Jan 2025 10:30:00 +0000", time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)},
+		{"Wed, 15 Jan 2025 10:30:00 UTC", time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)},
+		{"", time.Time{}},
+		{"yesterday", time.Time{}},
+		{"2025-13-01", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got := parseLastmod(tt.value)
+			if !got.Equal(tt.want) {
+				t.Errorf("parseLastmod(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+			if !got.IsZero() && got.Location() != time.UTC {
+				t.Errorf("parseLastmod(%q) location = %v, want UTC", tt.value, got.Location())
+			}
+		})
+	}
+}
+
+// newSitemapServer serves the sitemap sources of files by path, with {base}
+// replaced by the server url. The .gz files are served gzip compressed.
+func newSitemapServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		content = strings.ReplaceAll(content, "{base}", server.URL)
+		if !strings.HasSuffix(r.URL.Path, ".gz") {
+			w.Write([]byte(content))
+			return
+		}
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(content))
+		zw.Close()
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestParseSitemap(t *testing.T) {
+	files := map[string]string{
+		"/urlset.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> https://a.com/1 </loc><lastmod>2025-01-15</lastmod><priority>0.8</priority></url>
+  <url><loc>https://a.com/2</loc></url>
+  <url><loc>https://a.com/1</loc></url>
+  <url><loc>not a url</loc></url>
+</urlset>`,
+		"/index.xml": `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>{base}/urlset.xml</loc></sitemap>
+  <sitemap><loc>{base}/nested.xml</loc></sitemap>
+  <sitemap><loc>{base}/index.xml</loc></sitemap>
+</sitemapindex>`,
+		"/nested.xml":  `<sitemapindex><sitemap><loc>{base}/more.xml.gz</loc></sitemap></sitemapindex>`,
+		"/more.xml.gz": `<urlset><url><loc>https://a.com/3</loc></url></urlset>`,
+		"/urls.txt":    "\xef\xbb\xbfhttps://a.com/1\n# comment\n\nhttps://a.com/2\n",
+		"/rss.xml": `<rss version="2.0"><channel>
+  <item><link>https://a.com/post</link><pubDate>Wed, 15 Jan 2025 10:30:00 +0000</pubDate></item>
+</channel></rss>`,
+		"/atom.xml": `<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry><link rel="self" href="https://a.com/self"/><link href="https://a.com/entry"/><updated>2025-01-15T10:30:00Z</updated></entry>
+</feed>`,
+		"/html.xml":   `<html><body></body></html>`,
+		"/broken.xml": `<urlset><url>`,
+		"/partial.xml": `<sitemapindex>
+  <sitemap><loc>{base}/missing.xml</loc></sitemap>
+  <sitemap><loc>{base}/broken.xml</loc></sitemap>
+  <sitemap><loc>{base}/more.xml.gz</loc></sitemap>
+</sitemapindex>`,
+	}
+	server := newSitemapServer(t, files)
+
+	tests := []struct {
+		name    string
+		path    string
+		opts    SitemapOption
+		want    []string
+		wantErr bool
+	}{
+		{"urlset", "/urlset.xml", SitemapOption{}, []string{"https://a.com/1", "https://a.com/2"}, false},
+		{"index", "/index.xml", SitemapOption{}, []string{"https://a.com/1", "https://a.com/2", "https://a.com/3"}, false},
+		{"index depth", "/index.xml", SitemapOption{MaxDepth: 1}, []string{"https://a.com/1", "https://a.com/2"}, false},
+		{"max urls", "/index.xml", SitemapOption{MaxUrls: 1}, []string{"https://a.com/1"}, false},
+		{"failed children", "/partial.xml", SitemapOption{}, []string{"https://a.com/3"}, false},
+		{"gzip", "/more.xml.gz", SitemapOption{}, []string{"https://a.com/3"}, false},
+		{"text", "/urls.txt", SitemapOption{}, []string{"https://a.com/1", "https://a.com/2"}, false},
+		{"rss", "/rss.xml", SitemapOption{}, []string{"https://a.com/post"}, false},
+		{"atom", "/atom.xml", SitemapOption{}, []string{"https://a.com/entry"}, false},
+		{"not found", "/missing.xml", SitemapOption{}, nil, true},
+		{"unsupported", "/html.xml", SitemapOption{}, nil, true},
+		{"invalid xml", "/broken.xml", SitemapOption{}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseSitemap(context.Background(), server.URL+tt.path, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSitemap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Loc)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseSitemap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSitemapEntry(t *testing.T) {
+	server := newSitemapServer(t, map[string]string{
+		"/sitemap.xml": `<urlset>
+  <url><loc>https://a.com/1</loc><lastmod>2025-01-15T10:30:00+08:00</lastmod><priority>0.8</priority></url>
+  <url><loc>https://a.com/2</loc><priority>high</priority></url>
+</urlset>`,
+	})
+
+	entries, err := ParseSitemap(context.Background(), server.URL+"/sitemap.xml", SitemapOption{})
+	if err != nil {
+		t.Fatalf("ParseSitemap() error: %v", err)
+	}
+	want := []SitemapEntry{
+		{Loc: "https://a.com/1", Lastmod: time.Date(2025, 1, 15, 2, 30, 0, 0, time.UTC), Priority: 0.8},
+		{Loc: "https://a.com/2", Priority: DefaultSitemapPriority},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("ParseSitemap() returned %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i].Loc != want[i].Loc || !entries[i].Lastmod.Equal(want[i].Lastmod) ||
+			entries[i].Priority != want[i].Priority {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestSitemapEntryNeedsRender(t *testing.T) {
+	created := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	older := SitemapEntry{Loc: "https://a.com/1", Lastmod: created.Add(-time.Hour)}
+	newer := SitemapEntry{Loc: "https://a.com/1", Lastmod: created.Add(time.Hour)}
+	undated := SitemapEntry{Loc: "https://a.com/1"}
+
+	tests := []struct {
+		name   string
+		entry  SitemapEntry
+		mode   string
+		cached bool
+		want   bool
+	}{
+		{"full cached", older, SitemapModeFull, true, true},
+		{"missing only cached", newer, SitemapModeMissingOnly, true, false},
+		{"missing only not cached", older, SitemapModeMissingOnly, false, true},
+		{"incremental modified", newer, SitemapModeIncremental, true, true},
+		{"incremental unmodified", older, SitemapModeIncremental, true, false},
+		{"incremental not cached", older, SitemapModeIncremental, false, true},
+		{"incremental undated cached", undated, SitemapModeIncremental, true, false},
+		{"incremental undated not cached", undated, SitemapModeIncremental, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.NeedsRender(tt.mode, tt.cached, created); got != tt.want {
+				t.Errorf("NeedsRender(%s, %v) = %v, want %v", tt.mode, tt.cached, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUrlListEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		urls    []string
+		want    []string
+		wantErr bool
+	}{
+		{"listed", []string{"https://a.com/1", "http://b.com/2"}, []string{"https://a.com/1", "http://b.com/2"}, false},
+		{"duplicates", []string{"https://a.com/1", "https://a.com/2", "https://a.com/1"}, []string{"https://a.com/1", "https://a.com/2"}, false},
+		{"relative", []string{"https://a.com/1", "/2"}, nil, true},
+		{"other scheme", []string{"ftp://a.com/1"}, nil, true},
+		{"invalid", []string{"https://a .com/%zz"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := UrlListEntries(tt.urls)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UrlListEntries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Loc)
+				if entry.Priority != DefaultSitemapPriority {
+					t.Errorf("entry %s priority = %v, want %v", entry.Loc, entry.Priority, DefaultSitemapPriority)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("UrlListEntries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// PageMeta is the structured metadata extracted from a rendered page.
+type PageMeta struct {
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Canonical   string            `json:"canonical"`
+	Robots      []string          `json:"robots"`
+	Hreflang    []Hreflang        `json:"hreflang"`
+	OpenGraph   map[string]string `json:"openGraph"`
+	Twitter     map[string]string `json:"twitter"`
+	JsonLd      []json.RawMessage `json:"jsonLd"`
+	Headings    []Heading         `json:"headings"`
+	Links       Links             `json:"links"`
+}
+
+// Hreflang is an alternate language version of the page.
+type Hreflang struct {
+	Lang string `json:"lang"`
+	Href string `json:"href"`
+}
+
+// Heading is a heading element of the page outline, Level is 1 to 6 for h1 to h6.
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+}
+
+// Links holds the unique absolute urls of the page links, internal links have the
+// same host as the page.
+type Links struct {
+	Internal []string `json:"internal"`
+	External []string `json:"external"`
+}
+
+// Extract parses the html content rendered from pageUrl and returns the extracted
+// page metadata. Relative urls are resolved against the page url.
+func Extract(content []byte, pageUrl string) (*PageMeta, error) {
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return nil, fmt.Errorf("extract metadata: %w", err)
+	}
+
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("extract metadata: %w", err)
+	}
+
+	meta := &PageMeta{
+		Robots:    []string{},
+		Hreflang:  []Hreflang{},
+		OpenGraph: map[string]string{},
+		Twitter:   map[string]string{},
+		JsonLd:    []json.RawMessage{},
+		Headings:  []Heading{},
+		Links:     Links{Internal: []string{}, External: []string{}},
+	}
+	e := extractor{meta: meta, base: base, host: base.Host}
+	e.base = e.documentBase(doc)
+	e.walk(doc)
+
+	return meta, nil
+}
+
+type extractor struct {
+	meta *PageMeta
+	base *url.URL
+	host string
+}
+
+func (e *extractor) walk(n *html.Node) {
+	if n.Type == html.ElementNode {
+		switch n.DataAtom {
+		case atom.Title:
+			if e.meta.Title == "" {
+				e.meta.Title = textContent(n)
+			}
+		case atom.Meta:
+			e.metaTag(n)
+		case atom.Link:
+			e.linkTag(n)
+		case atom.Script:
+			if strings.EqualFold(strings.TrimSpace(attr(n, "type")), "application/ld+json") {
+				e.jsonLd(n)
+			}
+			return
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			e.meta.Headings = append(e.meta.Headings, Heading{
+				Level: int(n.Data[1] - '0'),
+				Text:  textContent(n),
+			})
+		case atom.A:
+			e.anchor(n)
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		e.walk(c)
+	}
+}
+
+func (e *extractor) metaTag(n *html.Node) {
+	name := strings.ToLower(attr(n, "name"))
+	property := strings.ToLower(attr(n, "property"))
+	content := strings.TrimSpace(attr(n, "content"))
+
+	switch {
+	case name == "description":
+		e.meta.Description = content
+	case name == "robots":
+		for _, directive := range strings.Split(content, ",") {
+			if directive = strings.TrimSpace(strings.ToLower(directive)); directive != "" {
+				e.meta.Robots = append(e.meta.Robots, directive)
+			}
+		}
+	case strings.HasPrefix(property, "og:"):
+		e.meta.OpenGraph[strings.TrimPrefix(property, "og:")] = content
+	case strings.HasPrefix(name, "twitter:"):
+		e.meta.Twitter[strings.TrimPrefix(name, "twitter:")] = content
+	case strings.HasPrefix(property, "twitter:"):
+		e.meta.Twitter[strings.TrimPrefix(property, "twitter:")] = content
+	}
+}
+
+func (e *extractor) linkTag(n *html.Node) {
+	rels := strings.Fields(strings.ToLower(attr(n, "rel")))
+	href := e.resolve(attr(n, "href"))
+	if href == "" {
+		return
+	}
+
+	if slices.Contains(rels, "canonical") && e.meta.Canonical == "" {
+		e.meta.Canonical = href
+	}
+	if lang := attr(n, "hreflang"); lang != "" && slices.Contains(rels, "alternate") {
+		e.meta.Hreflang = append(e.meta.Hreflang, Hreflang{Lang: lang, Href: href})
+	}
+}
+
+func (e *extractor) jsonLd(n *html.Node) {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			sb.WriteString(c.Data)
+		}
+	}
+	data := strings.TrimSpace(sb.String())
+	if !json.Valid([]byte(data)) {
+		return
+	}
+	e.meta.JsonLd = append(e.meta.JsonLd, json.RawMessage(data))
+}
+
+func (e *extractor) anchor(n *html.Node) {
+	href := e.resolve(attr(n, "href"))
+	if href == "" {
+		return
+	}
+	linkUrl, err := url.Parse(href)
+	if err != nil || (linkUrl.Scheme != "http" && linkUrl.Scheme != "https") {
+		return
+	}
+	linkUrl.Fragment = ""
+	link := linkUrl.String()
+
+	if linkUrl.Host == e.host {
+		if !slices.Contains(e.meta.Links.Internal, link) {
+			e.meta.Links.Internal = append(e.meta.Links.Internal, link)
+		}
+	} else if !slices.Contains(e.meta.Links.External, link) {
+		e.meta.Links.External = append(e.meta.Links.External, link)
+	}
+}
+
+func (e *extractor) documentBase(doc *html.Node) *url.URL {
+	base := findElement(doc, atom.Base)
+	if base == nil || attr(base, "href") == "" {
+		return e.base
+	}
+	baseUrl, err := e.base.Parse(attr(base, "href"))
+	if err != nil {
+		return e.base
+	}
+	return baseUrl
+}
+
+func (e *extractor) resolve(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	refUrl, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return e.base.ResolveReference(refUrl).String()
+}
+
+// textContent returns the whitespace normalized text content of n.
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
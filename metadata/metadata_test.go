@@ -0,0 +1,75 @@
+package metadata
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	content := `<html><head>
+  <title> Shoes |
+    Shop </title>
+  <base href="https://a.com/shop/">
+  <meta name="description" content=" Red shoes ">
+  <meta name="robots" content="NoIndex, follow">
+  <meta property="og:title" content="Shoes">
+  <meta name="twitter:card" content="summary">
+  <link rel="canonical" href="shoes">
+  <link rel="alternate" hreflang="fr" href="/fr/shoes">
+  <script type="application/ld+json">{"@type": "Product"}</script>
+  <script type="application/ld+json">{invalid</script>
+</head><body>
+  <h1>Shoes</h1><h3>Red <em>shoes</em></h3>
+  <a href="red#reviews">Red</a>
+  <a href="https://a.com/shop/red">Red again</a>
+  <a href="https://b.com/">B</a>
+  <a href="mailto:me@a.com">Mail</a>
+</body></html>`
+
+	meta, err := Extract([]byte(content), "https://a.com/shoes")
+	if err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+
+	want := &PageMeta{
+		Title:       "Shoes | Shop",
+		Description: "Red shoes",
+		Canonical:   "https://a.com/shop/shoes",
+		Robots:      []string{"noindex", "follow"},
+		Hreflang:    []Hreflang{{Lang: "fr", Href: "https://a.com/fr/shoes"}},
+		OpenGraph:   map[string]string{"title": "Shoes"},
+		Twitter:     map[string]string{"card": "summary"},
+		JsonLd:      []json.RawMessage{json.RawMessage(`{"@type": "Product"}`)},
+		Headings:    []Heading{{Level: 1, Text: "Shoes"}, {Level: 3, Text: "Red shoes"}},
+		Links: Links{
+			Internal: []string{"https://a.com/shop/red"},
+			External: []string{"https://b.com/"},
+		},
+	}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("Extract() = %+v, want %+v", meta, want)
+	}
+}
+
+func TestExtractEmpty(t *testing.T) {
+	meta, err := Extract([]byte(""), "https://a.com/")
+	if err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+
+	// Empty lists are encoded as [] rather than null
+	data, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatalf("marshal metadata: %v", err)
+	}
+	want := `{"title":"","description":"","canonical":"","robots":[],"hreflang":[],` +
+		`"openGraph":{},"twitter":{},"jsonLd":[],"headings":[],"links":{"internal":[],"external":[]}}`
+	if string(data) != want {
+		t.Errorf("marshalled metadata = %s, want %s", data, want)
+	}
+
+	if _, err := Extract([]byte(""), "://invalid"); err == nil {
+		t.Error("Extract() with an invalid page url succeeded")
+	}
+}
@@ -0,0 +1,353 @@
+package postprocess
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Available post-processing steps for rendered html content
+const (
+	StepStripScripts        = "stripScripts"
+	StepRemoveScriptPreload = "removeScriptPreload"
+	StepAbsoluteUrls        = "absoluteUrls"
+	StepInjectBase          = "injectBase"
+	StepRemoveEventHandlers = "removeEventHandlers"
+	StepMinify              = "minify"
+)
+
+// Step is a single transformation applied on the parsed html document, pageUrl
+// is the url where the document is rendered from.
+type Step func(doc *html.Node, pageUrl *url.URL) error
+
+var steps = map[string]Step{
+	StepStripScripts:        stripScripts,
+	StepRemoveScriptPreload: removeScriptPreload,
+	StepAbsoluteUrls:        absoluteUrls,
+	StepInjectBase:          injectBase,
+	StepRemoveEventHandlers: removeEventHandlers,
+	StepMinify:              minify,
+}
+
+// Supported reports whether the given step name is a supported post-processing step.
+func Supported(name string) bool {
+	_, ok := steps[name]
+	return ok
+}
+
+// Pipeline is a chain of post-processing steps which are applied on the rendered
+// html content in order.
+type Pipeline struct {
+	names []string
+	steps []Step
+}
+
+// NewPipeline creates a Pipeline from the given step names. An error is returned
+// if any of the step names is not supported.
+func NewPipeline(names []string) (*Pipeline, error) {
+	p := &Pipeline{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		step, ok := steps[name]
+		if !ok {
+			return nil, fmt.Errorf("new pipeline: unsupported step %s", name)
+		}
+		p.names = append(p.names, name)
+		p.steps = append(p.steps, step)
+	}
+
+	return p, nil
+}
+
+// Empty reports whether the pipeline has no step to apply.
+func (p *Pipeline) Empty() bool {
+	return len(p.steps) == 0
+}
+
+// Steps returns the step names of the pipeline in order.
+func (p *Pipeline) Steps() []string {
+	return p.names
+}
+
+// Process applies the pipeline steps on the html content rendered from pageUrl
+// and returns the processed html content. The content is returned untouched if
+// the pipeline is empty.
+func (p *Pipeline) Process(content []byte, pageUrl string) ([]byte, error) {
+	if p.Empty() {
+		return content, nil
+	}
+
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return nil, fmt.Errorf("post process: %w", err)
+	}
+
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("post process: %w", err)
+	}
+
+	for i, step := range p.steps {
+		if err := step(doc, base); err != nil {
+			return nil, fmt.Errorf("post process %s: %w", p.names[i], err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, fmt.Errorf("post process: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// stripScripts removes all script elements except JSON-LD structured data.
+func stripScripts(doc *html.Node, _ *url.URL) error {
+	removeNodes(doc, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || n.DataAtom != atom.Script {
+			return false
+		}
+		return !strings.EqualFold(strings.TrimSpace(attr(n, "type")), "application/ld+json")
+	})
+	return nil
+}
+
+// removeScriptPreload removes preload and modulepreload links of scripts.
+func removeScriptPreload(doc *html.Node, _ *url.URL) error {
+	removeNodes(doc, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || n.DataAtom != atom.Link {
+			return false
+		}
+		rels := strings.Fields(strings.ToLower(attr(n, "rel")))
+		for _, rel := range rels {
+			switch rel {
+			case "modulepreload":
+				return true
+			case "preload":
+				if strings.EqualFold(attr(n, "as"), "script") {
+					return true
+				}
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+var urlAttributes = map[string]bool{
+	"href":   true,
+	"src":    true,
+	"action": true,
+	"poster": true,
+}
+
+// absoluteUrls rewrites relative urls in link attributes into absolute urls,
+// resolved against the document base url.
+func absoluteUrls(doc *html.Node, pageUrl *url.URL) error {
+	base := documentBase(doc, pageUrl)
+
+	walk(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode || n.DataAtom == atom.Base {
+			return
+		}
+		for i, a := range n.Attr {
+			switch {
+			case urlAttributes[a.Key]:
+				n.Attr[i].Val = resolveUrl(base, a.Val)
+			case a.Key == "srcset":
+				n.Attr[i].Val = resolveSrcset(base, a.Val)
+			}
+		}
+	})
+	return nil
+}
+
+// injectBase adds a base element pointing to the page url if the document does
+// not have one.
+func injectBase(doc *html.Node, pageUrl *url.URL) error {
+	if findElement(doc, atom.Base) != nil {
+		return nil
+	}
+	head := findElement(doc, atom.Head)
+	if head == nil {
+		return fmt.Errorf("head element not found")
+	}
+
+	base := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Base,
+		Data:     "base",
+		Attr:     []html.Attribute{{Key: "href", Val: pageUrl.String()}},
+	}
+	head.InsertBefore(base, head.FirstChild)
+	return nil
+}
+
+// removeEventHandlers removes inline event handler attributes (onclick, onload...).
+func removeEventHandlers(doc *html.Node, _ *url.URL) error {
+	walk(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		attrs := n.Attr[:0]
+		for _, a := range n.Attr {
+			if !strings.HasPrefix(strings.ToLower(a.Key), "on") {
+				attrs = append(attrs, a)
+			}
+		}
+		n.Attr = attrs
+	})
+	return nil
+}
+
+var whitespaces = regexp.MustCompile(`\s+`)
+
+// minify removes comments and collapses whitespaces of text content, content of
+// whitespace sensitive elements (pre, textarea, script, style) is kept as is.
+func minify(doc *html.Node, _ *url.URL) error {
+	removeNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.CommentNode
+	})
+
+	var collapse func(n *html.Node)
+	collapse = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Pre, atom.Textarea, atom.Script, atom.Style:
+				return
+			}
+		}
+		if n.Type == html.TextNode {
+			// Merge adjacent text nodes left by removed comments
+			for next := n.NextSibling; next != nil && next.Type == html.TextNode; next = n.NextSibling {
+				n.Data += next.Data
+				n.Parent.RemoveChild(next)
+			}
+			n.Data = whitespaces.ReplaceAllString(n.Data, " ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collapse(c)
+		}
+	}
+	collapse(doc)
+
+	// Remove whitespace only text nodes left between elements
+	removeNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.TextNode && n.Data == " " &&
+			n.Parent != nil && n.Parent.DataAtom != atom.Pre && n.Parent.DataAtom != atom.Textarea &&
+			(n.PrevSibling == nil || n.PrevSibling.Type == html.ElementNode) &&
+			(n.NextSibling == nil || n.NextSibling.Type == html.ElementNode) &&
+			!inlineElement(n.PrevSibling) && !inlineElement(n.NextSibling)
+	})
+	return nil
+}
+
+// inlineElement reports whether n is an element where surrounding whitespace
+// is significant for rendering.
+func inlineElement(n *html.Node) bool {
+	if n == nil || n.Type != html.ElementNode {
+		return false
+	}
+	switch n.DataAtom {
+	case atom.A, atom.Abbr, atom.B, atom.Bdi, atom.Bdo, atom.Button, atom.Cite, atom.Code,
+		atom.Em, atom.I, atom.Img, atom.Input, atom.Kbd, atom.Label, atom.Mark, atom.Q,
+		atom.S, atom.Samp, atom.Select, atom.Small, atom.Span, atom.Strong, atom.Sub,
+		atom.Sup, atom.Time, atom.U, atom.Var:
+		return true
+	}
+	return false
+}
+
+func documentBase(doc *html.Node, pageUrl *url.URL) *url.URL {
+	base := findElement(doc, atom.Base)
+	if base == nil {
+		return pageUrl
+	}
+	href := attr(base, "href")
+	if href == "" {
+		return pageUrl
+	}
+	baseUrl, err := pageUrl.Parse(href)
+	if err != nil {
+		return pageUrl
+	}
+	return baseUrl
+}
+
+func resolveUrl(base *url.URL, ref string) string {
+	trimmed := strings.TrimSpace(ref)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return ref
+	}
+	refUrl, err := url.Parse(trimmed)
+	if err != nil {
+		return ref
+	}
+	// Leave non-hierarchical schemes like mailto:, tel:, data:, javascript: untouched
+	if refUrl.Scheme != "" && refUrl.Scheme != "http" && refUrl.Scheme != "https" {
+		return ref
+	}
+	return base.ResolveReference(refUrl).String()
+}
+
+func resolveSrcset(base *url.URL, srcset string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = resolveUrl(base, fields[0])
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func walk(n *html.Node, fn func(*html.Node)) {
+	fn(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+// removeNodes removes all nodes under n which match the given condition.
+func removeNodes(n *html.Node, match func(*html.Node) bool) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if match(c) {
+			n.RemoveChild(c)
+		} else {
+			removeNodes(c, match)
+		}
+		c = next
+	}
+}
@@ -0,0 +1,106 @@
+package postprocess
+
+import (
+	"strings"
+	"testing"
+)
+
+const page = `<html><head><link rel="preload" as="script" href="/app.js"><link rel="modulepreload" href="/mod.js"><link rel="stylesheet" href="/a.css"></head>
+<body onload="init()">
+  <!-- comment -->
+  <a href="/about" onclick="track()">About</a>
+  <img src="img/logo.png" srcset="img/a.png 1x, https://cdn.com/b.png 2x">
+  <a href="mailto:me@a.com">Mail</a> <a href="#top">Top</a>
+  <pre>  keep   spaces </pre>
+  <script>alert(1)</script>
+  <script type="application/ld+json">{"@type":"Thing"}</script>
+</body></html>`
+
+func TestPipelineProcess(t *testing.T) {
+	tests := []struct {
+		step    string
+		want    []string
+		notWant []string
+	}{
+		{
+			StepStripScripts,
+			[]string{`application/ld+json`},
+			[]string{`alert(1)`},
+		},
+		{
+			StepRemoveScriptPreload,
+			[]string{`rel="stylesheet"`},
+			[]string{`/app.js`, `/mod.js`},
+		},
+		{
+			StepAbsoluteUrls,
+			[]string{
+				`href="https://a.com/about"`,
+				`src="https://a.com/blog/img/logo.png"`,
+				`srcset="https://a.com/blog/img/a.png 1x, https://cdn.com/b.png 2x"`,
+				`href="mailto:me@a.com"`,
+				`href="#top"`,
+			},
+			nil,
+		},
+		{
+			StepInjectBase,
+			[]string{`<head><base href="https://a.com/blog/post"/>`},
+			nil,
+		},
+		{
+			StepRemoveEventHandlers,
+			[]string{`<a href="/about">`},
+			[]string{`onload`, `onclick`},
+		},
+		{
+			StepMinify,
+			[]string{`<pre>  keep   spaces </pre>`, `</a> <a href="#top">`},
+			[]string{`comment`, "\n  <a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.step, func(t *testing.T) {
+			pipeline, err := NewPipeline([]string{tt.step})
+			if err != nil {
+				t.Fatalf("NewPipeline() error: %v", err)
+			}
+			content, err := pipeline.Process([]byte(page), "https://a.com/blog/post")
+			if err != nil {
+				t.Fatalf("Process() error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(content), want) {
+					t.Errorf("processed content misses %q:\n%s", want, content)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(string(content), notWant) {
+					t.Errorf("processed content has %q:\n%s", notWant, content)
+				}
+			}
+		})
+	}
+}
+
+func TestNewPipeline(t *testing.T) {
+	pipeline, err := NewPipeline([]string{" minify ", "", StepStripScripts})
+	if err != nil {
+		t.Fatalf("NewPipeline() error: %v", err)
+	}
+	if got := pipeline.Steps(); len(got) != 2 || got[0] != StepMinify || got[1] != StepStripScripts {
+		t.Errorf("Steps() = %v, want [minify stripScripts]", got)
+	}
+
+	if _, err := NewPipeline([]string{"unknown"}); err == nil {
+		t.Error("NewPipeline() with an unsupported step succeeded")
+	}
+
+	// An empty pipeline leaves the content untouched
+	empty, _ := NewPipeline(nil)
+	content, err := empty.Process([]byte("<p>not  parsed"), "https://a.com/")
+	if err != nil || string(content) != "<p>not  parsed" {
+		t.Errorf("Process() with empty pipeline = (%q, %v)", content, err)
+	}
+}
@@ -0,0 +1,470 @@
+package readable
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// Class or id patterns of boilerplate elements which are removed before
+	// extracting the readable content.
+	boilerplatePattern = regexp.MustCompile(
+		`(?i)(^|[-_\s])(nav|navbar|menu|header|footer|sidebar|breadcrumbs?|cookies?|consent|banner|share|social|comments?|advert|ads?|promo|popup|modal|newsletter|subscribe|related|skip)([-_\s]|$)`,
+	)
+	// Class or id patterns of elements which are likely the main content.
+	contentPattern = regexp.MustCompile(`(?i)(article|content|main|post|entry|story|text|body)`)
+
+	whitespaces = regexp.MustCompile(`\s+`)
+	blankLines  = regexp.MustCompile(`\n{3,}`)
+)
+
+// ToText converts the html content rendered from pageUrl into readable plain text
+// with navigation and boilerplate stripped.
+func ToText(content []byte, pageUrl string) ([]byte, error) {
+	root, base, err := mainContent(content, pageUrl)
+	if err != nil {
+		return nil, fmt.Errorf("to text: %w", err)
+	}
+
+	w := &writer{base: base}
+	w.text(root)
+
+	return w.result(), nil
+}
+
+// ToMarkdown converts the html content rendered from pageUrl into Markdown with
+// navigation and boilerplate stripped. Links and images are written with absolute
+// urls resolved against the page url.
+func ToMarkdown(content []byte, pageUrl string) ([]byte, error) {
+	root, base, err := mainContent(content, pageUrl)
+	if err != nil {
+		return nil, fmt.Errorf("to markdown: %w", err)
+	}
+
+	w := &writer{base: base, markdown: true}
+	w.text(root)
+
+	return w.result(), nil
+}
+
+// mainContent parses the html content, strips the boilerplate elements and returns
+// the node most likely holding the main content of the page.
+func mainContent(content []byte, pageUrl string) (*html.Node, *url.URL, error) {
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	stripBoilerplate(doc)
+
+	for _, a := range []atom.Atom{atom.Main, atom.Article} {
+		if n := findElement(doc, func(n *html.Node) bool { return n.DataAtom == a }); n != nil {
+			return n, base, nil
+		}
+	}
+	if n := findElement(doc, func(n *html.Node) bool { return attr(n, "role") == "main" }); n != nil {
+		return n, base, nil
+	}
+
+	body := findElement(doc, func(n *html.Node) bool { return n.DataAtom == atom.Body })
+	if body == nil {
+		return doc, base, nil
+	}
+	if best := bestCandidate(body); best != nil {
+		return best, base, nil
+	}
+	return body, base, nil
+}
+
+// stripBoilerplate removes non-content elements like navigation, header, footer,
+// forms, scripts and elements with boilerplate class or id names.
+func stripBoilerplate(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if isBoilerplate(c) {
+			n.RemoveChild(c)
+		} else {
+			stripBoilerplate(c)
+		}
+		c = next
+	}
+}
+
+func isBoilerplate(n *html.Node) bool {
+	switch n.Type {
+	case html.CommentNode:
+		return true
+	case html.ElementNode:
+	default:
+		return false
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg, atom.Iframe,
+		atom.Nav, atom.Header, atom.Footer, atom.Aside, atom.Form, atom.Button,
+		atom.Input, atom.Select, atom.Textarea, atom.Dialog:
+		return true
+	case atom.Html, atom.Body, atom.Main, atom.Article:
+		return false
+	}
+
+	switch attr(n, "role") {
+	case "navigation", "banner", "contentinfo", "complementary", "search", "dialog":
+		return true
+	}
+	if strings.EqualFold(attr(n, "aria-hidden"), "true") || hasAttr(n, "hidden") {
+		return true
+	}
+
+	names := attr(n, "class") + " " + attr(n, "id")
+	return boilerplatePattern.MatchString(names) && !contentPattern.MatchString(names)
+}
+
+// bestCandidate scores the block elements under n by the length of their non-link
+// text and returns the element with the highest score. nil is returned if no
+// element holds more than half of the text of n.
+func bestCandidate(n *html.Node) *html.Node {
+	total := textLength(n, false)
+	if total == 0 {
+		return nil
+	}
+
+	var best *html.Node
+	var bestScore float64
+	var score func(*html.Node)
+	score = func(c *html.Node) {
+		if c.Type != html.ElementNode {
+			return
+		}
+		switch c.DataAtom {
+		case atom.Div, atom.Section, atom.Td:
+			length := textLength(c, false)
+			if length*2 > total {
+				linkLength := textLength(c, true)
+				s := float64(length) * (1 - float64(linkLength)/float64(length))
+				if contentPattern.MatchString(attr(c, "class") + " " + attr(c, "id")) {
+					s *= 1.25
+				}
+				// Prefer deeper candidates with similar score
+				if s >= bestScore*0.9 {
+					best, bestScore = c, s
+				}
+			}
+		}
+		for child := c.FirstChild; child != nil; child = child.NextSibling {
+			score(child)
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		score(c)
+	}
+
+	return best
+}
+
+// textLength returns the length of the text content under n, only the text inside
+// links is counted if linkOnly is true.
+func textLength(n *html.Node, linkOnly bool) int {
+	var length int
+	var count func(*html.Node, bool)
+	count = func(n *html.Node, inLink bool) {
+		if n.Type == html.TextNode && (!linkOnly || inLink) {
+			length += len(strings.TrimSpace(n.Data))
+		}
+		inLink = inLink || n.DataAtom == atom.A
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			count(c, inLink)
+		}
+	}
+	count(n, false)
+
+	return length
+}
+
+// writer writes the readable text of html nodes, in Markdown syntax if markdown
+// is set.
+type writer struct {
+	base     *url.URL
+	markdown bool
+	buf      strings.Builder
+	inPre    bool
+	lists    []listState
+}
+
+type listState struct {
+	ordered bool
+	index   int
+}
+
+func (w *writer) result() []byte {
+	lines := strings.Split(w.buf.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	output := blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+
+	return []byte(strings.TrimSpace(output) + "\n")
+}
+
+func (w *writer) write(s string) {
+	w.buf.WriteString(s)
+}
+
+// block starts a new block separated by a blank line.
+func (w *writer) block() {
+	w.write("\n\n")
+}
+
+func (w *writer) text(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		if w.inPre {
+			w.write(n.Data)
+			return
+		}
+		text := whitespaces.ReplaceAllString(n.Data, " ")
+		if w.markdown {
+			text = escapeMarkdown(text)
+		}
+		w.write(text)
+		return
+	case html.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.block()
+		if w.markdown {
+			w.write(strings.Repeat("#", int(n.Data[1]-'0')) + " ")
+		}
+		w.children(n)
+		w.block()
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Figure,
+		atom.Figcaption, atom.Dl, atom.Address, atom.Details, atom.Summary:
+		w.block()
+		w.children(n)
+		w.block()
+	case atom.Dt, atom.Dd, atom.Tr:
+		w.write("\n")
+		w.children(n)
+		w.write("\n")
+	case atom.Td, atom.Th:
+		w.children(n)
+		w.write(" | ")
+	case atom.Br:
+		if w.markdown {
+			w.write("  ")
+		}
+		w.write("\n")
+	case atom.Hr:
+		w.block()
+		if w.markdown {
+			w.write("---")
+		}
+		w.block()
+	case atom.Ul, atom.Ol:
+		// Nested lists continue right below the parent list item
+		nested := len(w.lists) > 0
+		w.lists = append(w.lists, listState{ordered: n.DataAtom == atom.Ol})
+		if !nested {
+			w.block()
+		}
+		w.children(n)
+		if !nested {
+			w.block()
+		}
+		w.lists = w.lists[:len(w.lists)-1]
+	case atom.Li:
+		w.listItem(n)
+	case atom.Blockquote:
+		w.block()
+		if w.markdown {
+			inner := &writer{base: w.base, markdown: true}
+			inner.children(n)
+			for _, line := range strings.Split(strings.TrimSpace(string(inner.result())), "\n") {
+				w.write("> " + line + "\n")
+			}
+		} else {
+			w.children(n)
+		}
+		w.block()
+	case atom.Pre:
+		w.block()
+		if w.markdown {
+			w.write("```\n")
+		}
+		w.inPre = true
+		w.children(n)
+		w.inPre = false
+		if w.markdown {
+			w.write("\n```")
+		}
+		w.block()
+	case atom.Code:
+		if w.markdown && !w.inPre {
+			w.write("`" + textContent(n) + "`")
+			return
+		}
+		w.children(n)
+	case atom.Strong, atom.B:
+		w.inline(n, "**")
+	case atom.Em, atom.I:
+		w.inline(n, "*")
+	case atom.A:
+		w.link(n)
+	case atom.Img:
+		w.image(n)
+	default:
+		w.children(n)
+	}
+}
+
+func (w *writer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.text(c)
+	}
+}
+
+func (w *writer) inline(n *html.Node, marker string) {
+	text := textContent(n)
+	if !w.markdown || text == "" {
+		w.children(n)
+		return
+	}
+	w.write(marker + escapeMarkdown(text) + marker)
+}
+
+func (w *writer) listItem(n *html.Node) {
+	w.write("\n")
+	depth := len(w.lists)
+	if depth == 0 {
+		w.children(n)
+		return
+	}
+	list := &w.lists[depth-1]
+	list.index++
+
+	w.write(strings.Repeat("  ", depth-1))
+	if list.ordered {
+		w.write(fmt.Sprintf("%d. ", list.index))
+	} else if w.markdown {
+		w.write("- ")
+	} else {
+		w.write("* ")
+	}
+	w.children(n)
+}
+
+func (w *writer) link(n *html.Node) {
+	href := w.resolve(attr(n, "href"))
+	if !w.markdown || href == "" {
+		w.children(n)
+		return
+	}
+
+	inner := &writer{base: w.base, markdown: true}
+	inner.children(n)
+	text := strings.TrimSpace(string(inner.result()))
+	if text == "" {
+		return
+	}
+	w.write(fmt.Sprintf("[%s](%s)", text, href))
+}
+
+func (w *writer) image(n *html.Node) {
+	alt := strings.TrimSpace(attr(n, "alt"))
+	if !w.markdown {
+		w.write(alt)
+		return
+	}
+	src := w.resolve(attr(n, "src"))
+	if src == "" {
+		return
+	}
+	w.write(fmt.Sprintf("![%s](%s)", escapeMarkdown(alt), src))
+}
+
+func (w *writer) resolve(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "javascript:") {
+		return ""
+	}
+	refUrl, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return w.base.ResolveReference(refUrl).String()
+}
+
+var markdownSpecials = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"[", `\[`,
+	"]", `\]`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownSpecials.Replace(s)
+}
+
+// textContent returns the whitespace normalized text content of n.
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+
+	return strings.TrimSpace(whitespaces.ReplaceAllString(sb.String(), " "))
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func findElement(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
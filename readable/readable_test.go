@@ -0,0 +1,82 @@
+package readable
+
+import (
+	"strings"
+	"testing"
+)
+
+const page = `<html><body>
+<nav><a href="/">Home</a></nav>
+<div class="cookie-banner">We use cookies</div>
+<main>
+  <h1>Red   shoes</h1>
+  <p>Our <strong>best</strong> shoes, see <a href="/shop/red">the shop</a> or <a href="#top">top</a>.</p>
+  <ul><li>Size 40</li><li>Size_41<ol><li>Wide</li></ol></li></ul>
+  <p><img src="img/red.png" alt="Red shoe"></p>
+  <pre>  a := 1</pre>
+  <script>track()</script>
+</main>
+<footer>Copyright</footer>
+</body></html>`
+
+func TestToMarkdown(t *testing.T) {
+	got, err := ToMarkdown([]byte(page), "https://a.com/shoes/red")
+	if err != nil {
+		t.Fatalf("ToMarkdown() error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"# Red shoes",
+		"",
+		"Our **best** shoes, see [the shop](https://a.com/shop/red) or top.",
+		"",
+		"- Size 40",
+		"- Size\\_41",
+		"  1. Wide",
+		"",
+		"![Red shoe](https://a.com/shoes/img/red.png)",
+		"",
+		"```",
+		"  a := 1",
+		"```",
+		"",
+	}, "\n")
+	if string(got) != want {
+		t.Errorf("ToMarkdown() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestToText(t *testing.T) {
+	got, err := ToText([]byte(page), "https://a.com/shoes/red")
+	if err != nil {
+		t.Fatalf("ToText() error: %v", err)
+	}
+
+	for _, boilerplate := range []string{"Home", "cookies", "Copyright", "track()", "**", "https://"} {
+		if strings.Contains(string(got), boilerplate) {
+			t.Errorf("ToText() has %q:\n%s", boilerplate, got)
+		}
+	}
+	for _, text := range []string{"Red shoes\n", "Our best shoes, see the shop or top.", "* Size 40", "  1. Wide", "Red shoe"} {
+		if !strings.Contains(string(got), text) {
+			t.Errorf("ToText() misses %q:\n%s", text, got)
+		}
+	}
+}
+
+func TestMainContentCandidate(t *testing.T) {
+	// Without main or article element, the block holding most of the text is kept
+	content := `<html><body>
+<div class="links"><a href="/a">A link list</a> <a href="/b">Another link</a></div>
+<div class="post-body"><p>The story of the page, long enough to be the main content of the page.</p></div>
+</body></html>`
+
+	got, err := ToText([]byte(content), "https://a.com/")
+	if err != nil {
+		t.Fatalf("ToText() error: %v", err)
+	}
+	want := "The story of the page, long enough to be the main content of the page.\n"
+	if string(got) != want {
+		t.Errorf("ToText() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,10 @@
+// Package renderer renders pages with Chromium through chromedp.
+//
+// The package is the in-repo fork of github.com/liuminhaw/renderer v0.11.0,
+// which wrenderer depended on before. It was brought into the repository to
+// capture the status code and the redirect chain of the main document, which
+// the upstream renderer does not expose, and has since been extended with MHTML
+// archives, the shared browser pool, remote DevTools endpoints and the pool
+// memory limits. Changes to rendering are made here, the upstream module is no
+// longer used.
+package renderer
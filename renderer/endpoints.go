@@ -0,0 +1,165 @@
+package renderer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var ErrNoHealthyEndpoint = errors.New("no healthy remote browser endpoint")
+
+const endpointCheckTimeout = 5 * time.Second
+
+// Endpoints is a list of remote browser DevTools endpoints, either websocket
+// (ws://host:port/devtools/browser/...) or http (http://host:port) urls. Endpoints
+// are handed out in round robin order, an endpoint which failed is skipped until
+// it passes the health check again.
+type Endpoints struct {
+	logger *slog.Logger
+	client *http.Client
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type endpoint struct {
+	url     string
+	healthy bool
+}
+
+// NewEndpoints creates Endpoints from the given endpoint urls, all endpoints are
+// considered healthy until they fail.
+func NewEndpoints(urls []string, logger *slog.Logger) *Endpoints {
+	e := &Endpoints{
+		logger: logger,
+		client: &http.Client{Timeout: endpointCheckTimeout},
+		stop:   make(chan struct{}),
+	}
+	for _, u := range urls {
+		e.endpoints = append(e.endpoints, &endpoint{url: u, healthy: true})
+	}
+
+	return e
+}
+
+// Len returns the number of endpoints.
+func (e *Endpoints) Len() int {
+	return len(e.endpoints)
+}
+
+// Next returns the next healthy endpoint url. ErrNoHealthyEndpoint is returned if
+// all endpoints are unhealthy.
+func (e *Endpoints) Next() (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for range e.endpoints {
+		ep := e.endpoints[e.next]
+		e.next = (e.next + 1) % len(e.endpoints)
+		if ep.healthy {
+			return ep.url, nil
+		}
+	}
+	return "", ErrNoHealthyEndpoint
+}
+
+// MarkFailed marks the endpoint of the given url as unhealthy.
+func (e *Endpoints) MarkFailed(endpointUrl string) {
+	e.setHealthy(endpointUrl, false)
+}
+
+func (e *Endpoints) setHealthy(endpointUrl string, healthy bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, ep := range e.endpoints {
+		if ep.url != endpointUrl || ep.healthy == healthy {
+			continue
+		}
+		ep.healthy = healthy
+		e.logger.Info(
+			"Remote browser endpoint health changed",
+			slog.String("endpoint", ep.url),
+			slog.Bool("healthy", healthy),
+		)
+	}
+}
+
+// StartHealthCheck checks the health of all endpoints every interval until Stop
+// is called.
+func (e *Endpoints) StartHealthCheck(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				e.Check()
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the health check started by StartHealthCheck.
+func (e *Endpoints) Stop() {
+	e.stopOnce.Do(func() { close(e.stop) })
+}
+
+// Check probes the /json/version DevTools http endpoint of every endpoint and
+// updates their health.
+func (e *Endpoints) Check() {
+	for _, ep := range e.endpoints {
+		err := e.probe(ep.url)
+		if err != nil {
+			e.logger.Debug(
+				"Remote browser endpoint health check failed",
+				slog.String("endpoint", ep.url),
+				slog.String("error", err.Error()),
+			)
+		}
+		e.setHealthy(ep.url, err == nil)
+	}
+}
+
+func (e *Endpoints) probe(endpointUrl string) error {
+	u, err := url.Parse(endpointUrl)
+	if err != nil {
+		return fmt.Errorf("probe endpoint: %w", err)
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	u.Path = "/json/version"
+	u.RawQuery = ""
+
+	ctx, cancel := context.WithTimeout(context.Background(), endpointCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("probe endpoint: %w", err)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("probe endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("probe endpoint: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package renderer
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEndpointsNext(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	e := NewEndpoints([]string{"ws://a:9222", "ws://b:9222", "ws://c:9222"}, logger)
+
+	next := func() string {
+		t.Helper()
+		endpoint, err := e.Next()
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		return endpoint
+	}
+	if got := []string{next(), next(), next(), next()}; strings.Join(got, " ") != "ws://a:9222 ws://b:9222 ws://c:9222 ws://a:9222" {
+		t.Errorf("Next() order = %v, want round robin", got)
+	}
+
+	// Failed endpoints are skipped
+	e.MarkFailed("ws://b:9222")
+	if got := []string{next(), next()}; strings.Join(got, " ") != "ws://c:9222 ws://a:9222" {
+		t.Errorf("Next() with failed endpoint = %v, want b skipped", got)
+	}
+
+	e.MarkFailed("ws://a:9222")
+	e.MarkFailed("ws://c:9222")
+	if _, err := e.Next(); !errors.Is(err, ErrNoHealthyEndpoint) {
+		t.Errorf("Next() without healthy endpoint error = %v, want ErrNoHealthyEndpoint", err)
+	}
+}
+
+func TestEndpointsCheck(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/json/version" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	// The websocket endpoint is probed over http
+	healthyWs := "ws" + strings.TrimPrefix(healthy.URL, "http") + "/devtools/browser/abc"
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	e := NewEndpoints([]string{failing.URL, healthyWs}, logger)
+	e.MarkFailed(healthyWs)
+
+	e.Check()
+	for range 2 {
+		endpoint, err := e.Next()
+		if err != nil || endpoint != healthyWs {
+			t.Errorf("Next() after check = (%s, %v), want %s", endpoint, err, healthyWs)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package renderer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processTreeMemory returns the resident memory in bytes used by the process of
+// pid and all of its descendant processes, read from the /proc filesystem. An
+// error is returned on systems without /proc.
+func processTreeMemory(pid int) (uint64, error) {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return 0, fmt.Errorf("process tree memory: %w", err)
+	}
+	if len(stats) == 0 {
+		return 0, fmt.Errorf("process tree memory: /proc not available")
+	}
+
+	children := make(map[int][]int)
+	for _, stat := range stats {
+		data, err := os.ReadFile(stat)
+		if err != nil {
+			continue
+		}
+		// The command name in the second field may contain spaces, fields after
+		// the closing parenthesis are: state, ppid...
+		fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		childPid, err := strconv.Atoi(filepath.Base(filepath.Dir(stat)))
+		if err != nil {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], childPid)
+	}
+
+	pageSize := uint64(os.Getpagesize())
+	var total uint64
+	pending := []int{pid}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = append(pending[1:], children[current]...)
+
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/statm", current))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) < 2 {
+			continue
+		}
+		resident, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		total += resident * pageSize
+	}
+
+	return total, nil
+}
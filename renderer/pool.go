@@ -0,0 +1,268 @@
+package renderer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+var ErrPoolClosed = errors.New("renderer pool is closed")
+
+const healthCheckTimeout = 5 * time.Second
+
+// PoolOption configures the browser instances managed by Pool. A browser is
+// recycled after MaxRenders renders or when the memory of its process tree grows
+// over MaxMemoryMB, zero disables the limit. Idle browsers are health checked
+// before use if they were not checked within HealthCheckInterval.
+type PoolOption struct {
+	Size                int
+	MaxRenders          int
+	MaxMemoryMB         int
+	HealthCheckInterval time.Duration
+}
+
+// Pool is a managed pool of warm browser instances shared by the page renders.
+// Each render runs in a new tab of a browser taken from the pool, the browser is
+// returned to the pool after the tab is closed.
+type Pool struct {
+	renderer *Renderer
+	logger   *slog.Logger
+	opts     *RendererOption
+	poolOpts PoolOption
+	// endpoints of remote browsers, nil if browsers are launched locally
+	endpoints *Endpoints
+
+	browsers  chan *pooledBrowser
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// pooledBrowser is a browser instance of the pool, ctx is nil if the browser is
+// not started.
+type pooledBrowser struct {
+	id        int
+	ctx       context.Context
+	cancel    context.CancelFunc
+	renders   int
+	lastCheck time.Time
+}
+
+// NewPool creates a Pool of poolOpts.Size browsers launched with the browser
+// options of opts, or connected to the remote endpoints of opts with failover
+// between the endpoints. Browsers are started right away, a browser which fails
+// to start is started again when it is taken for a render.
+func NewPool(opts *RendererOption, poolOpts PoolOption, logger *slog.Logger) *Pool {
+	if poolOpts.Size <= 0 {
+		poolOpts.Size = 1
+	}
+
+	p := &Pool{
+		renderer: NewRenderer(WithLogger(logger)),
+		logger:   logger,
+		opts:     opts,
+		poolOpts: poolOpts,
+		browsers: make(chan *pooledBrowser, poolOpts.Size),
+		done:     make(chan struct{}),
+	}
+	if len(opts.BrowserOpts.RemoteEndpoints) > 0 {
+		p.endpoints = NewEndpoints(opts.BrowserOpts.RemoteEndpoints, logger)
+		p.endpoints.StartHealthCheck(poolOpts.HealthCheckInterval)
+		p.renderer.endpoints = p.endpoints
+	}
+	for i := range poolOpts.Size {
+		b := &pooledBrowser{id: i}
+		if err := p.start(b); err != nil {
+			p.logger.Error(
+				"Failed to start pool browser",
+				slog.Int("browser", b.id),
+				slog.String("error", err.Error()),
+			)
+		}
+		p.browsers <- b
+	}
+
+	return p
+}
+
+// RenderPage renders the given url in a new tab of a pooled browser. The browser
+// options (headless, window size, user agent...) of the pool are used instead of
+// the ones in opts. The tab is closed and ctx.Err() is returned if ctx is done
+// before the render completes.
+func (p *Pool) RenderPage(
+	ctx context.Context,
+	urlStr string,
+	opts *RendererOption,
+) (*RenderResult, error) {
+	b, err := p.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pool render page: %w", err)
+	}
+
+	tabCtx, tabCancel := chromedp.NewContext(b.ctx, p.renderer.contextOptions(opts)...)
+	stop := context.AfterFunc(ctx, tabCancel)
+	result, err := p.renderer.render(tabCtx, urlStr, opts)
+	stop()
+	tabCancel()
+	b.renders++
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("pool render page: %w", ctx.Err())
+	} else if err != nil {
+		// Check the browser before next use in case it caused the failure
+		b.lastCheck = time.Time{}
+	}
+
+	go p.release(b)
+
+	return result, err
+}
+
+// Close stops all browsers of the pool, renders in progress are waited before
+// their browsers are stopped.
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		for range p.poolOpts.Size {
+			b := <-p.browsers
+			p.stop(b)
+		}
+		if p.endpoints != nil {
+			p.endpoints.Stop()
+		}
+	})
+}
+
+// acquire takes a browser from the pool, waiting until one is available or ctx
+// is done. The browser is restarted if it is not running or fails the health
+// check.
+func (p *Pool) acquire(ctx context.Context) (*pooledBrowser, error) {
+	select {
+	case <-p.done:
+		return nil, ErrPoolClosed
+	default:
+	}
+
+	var b *pooledBrowser
+	select {
+	case b = <-p.browsers:
+	case <-p.done:
+		return nil, ErrPoolClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if err := p.ensureHealthy(b); err != nil {
+		p.browsers <- b
+		return nil, err
+	}
+	return b, nil
+}
+
+// release recycles the browser if it reached the renders or memory limit, and
+// returns it to the pool.
+func (p *Pool) release(b *pooledBrowser) {
+	var reason string
+	switch {
+	case b.ctx == nil || b.ctx.Err() != nil:
+		reason = "crashed"
+	case p.poolOpts.MaxRenders > 0 && b.renders >= p.poolOpts.MaxRenders:
+		reason = "max renders reached"
+	case p.poolOpts.MaxMemoryMB > 0:
+		if memory, err := p.memory(b); err == nil && memory > uint64(p.poolOpts.MaxMemoryMB)<<20 {
+			reason = "max memory reached"
+		}
+	}
+
+	if reason != "" {
+		p.logger.Info(
+			"Recycling pool browser",
+			slog.Int("browser", b.id),
+			slog.Int("renders", b.renders),
+			slog.String("reason", reason),
+		)
+		p.stop(b)
+		if err := p.start(b); err != nil {
+			p.logger.Error(
+				"Failed to restart pool browser",
+				slog.Int("browser", b.id),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
+	p.browsers <- b
+}
+
+// ensureHealthy makes sure the browser is running and responding, the browser is
+// restarted otherwise.
+func (p *Pool) ensureHealthy(b *pooledBrowser) error {
+	if b.ctx != nil && b.ctx.Err() == nil {
+		if time.Since(b.lastCheck) < p.poolOpts.HealthCheckInterval {
+			return nil
+		}
+		err := p.healthCheck(b)
+		if err == nil {
+			return nil
+		}
+		p.logger.Info(
+			"Pool browser failed health check",
+			slog.Int("browser", b.id),
+			slog.String("error", err.Error()),
+		)
+	}
+
+	p.stop(b)
+	return p.start(b)
+}
+
+// healthCheck checks the browser responds to the devtools protocol.
+func (p *Pool) healthCheck(b *pooledBrowser) error {
+	ctx, cancel := context.WithTimeout(b.ctx, healthCheckTimeout)
+	defer cancel()
+
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		_, _, _, _, _, err := browser.GetVersion().Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return fmt.Errorf("health check: %w", err)
+	}
+	b.lastCheck = time.Now()
+	return nil
+}
+
+func (p *Pool) start(b *pooledBrowser) error {
+	ctx, cancel, err := p.renderer.startBrowser(p.opts)
+	if err != nil {
+		return err
+	}
+
+	b.ctx, b.cancel = ctx, cancel
+	b.renders = 0
+	b.lastCheck = time.Now()
+	p.logger.Debug("Pool browser started", slog.Int("browser", b.id))
+	return nil
+}
+
+func (p *Pool) stop(b *pooledBrowser) {
+	if b.ctx == nil {
+		return
+	}
+	b.cancel()
+	b.ctx, b.cancel = nil, nil
+}
+
+// memory returns the memory used by the process tree of the browser, remote
+// browsers have no local process to measure.
+func (p *Pool) memory(b *pooledBrowser) (uint64, error) {
+	process := chromedp.FromContext(b.ctx).Browser.Process()
+	if process == nil {
+		return 0, fmt.Errorf("browser process not found")
+	}
+	return processTreeMemory(process.Pid)
+}
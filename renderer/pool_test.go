@@ -0,0 +1,76 @@
+package renderer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestPoolAcquire(t *testing.T) {
+	// A pool with its single browser taken by a render in progress
+	p := &Pool{
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		poolOpts: PoolOption{Size: 1},
+		browsers: make(chan *pooledBrowser, 1),
+		done:     make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire() of a busy pool error = %v, want context.DeadlineExceeded", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := p.acquire(context.Background())
+		acquired <- err
+	}()
+	close(p.done)
+	select {
+	case err := <-acquired:
+		if !errors.Is(err, ErrPoolClosed) {
+			t.Errorf("acquire() waiting on a closed pool error = %v, want ErrPoolClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acquire() still waiting after the pool is closed")
+	}
+
+	if _, err := p.acquire(context.Background()); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("acquire() of a closed pool error = %v, want ErrPoolClosed", err)
+	}
+}
+
+func TestProcessTreeMemory(t *testing.T) {
+	if _, err := os.Stat("/proc/self/statm"); err != nil {
+		t.Skip("/proc not available")
+	}
+
+	self, err := processTreeMemory(os.Getpid())
+	if err != nil || self == 0 {
+		t.Fatalf("processTreeMemory() = (%d, %v), want the test process memory", self, err)
+	}
+
+	// The memory of the child processes is counted with their parent
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("start child process: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	child, err := processTreeMemory(cmd.Process.Pid)
+	if err != nil || child == 0 {
+		t.Fatalf("processTreeMemory() of the child = (%d, %v)", child, err)
+	}
+	tree, err := processTreeMemory(os.Getpid())
+	if err != nil || tree < child {
+		t.Errorf("processTreeMemory() with child = (%d, %v), want at least the child memory %d", tree, err, child)
+	}
+}
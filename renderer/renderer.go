@@ -0,0 +1,489 @@
+package renderer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	IdleTypeAuto            = "auto"
+	IdleTypeNetworkIdle     = "networkIdle"
+	IdleTypeInteractiveTime = "InteractiveTime"
+
+	// StatusCodeMetaName is the meta tag name which can be used by the rendered
+	// page to override the status code of the main document, e.g.
+	// <meta name="prerender-status-code" content="404">
+	StatusCodeMetaName = "prerender-status-code"
+)
+
+type BrowserConf struct {
+	IdleType      string
+	Container     bool
+	ChromiumDebug bool
+	// RemoteEndpoints are DevTools endpoints of remote browsers to connect to
+	// instead of launching a local browser, see Endpoints.
+	RemoteEndpoints []string
+}
+
+type RendererOption struct {
+	BrowserOpts  BrowserConf
+	Headless     bool
+	WindowWidth  int
+	WindowHeight int
+	Timeout      int
+	UserAgent    string
+	// Archive captures an MHTML snapshot of the rendered page with all of its
+	// subresources into RenderResult.Archive.
+	Archive bool
+}
+
+const (
+	RedirectTypeHttp        = "http"
+	RedirectTypeMetaRefresh = "metaRefresh"
+	RedirectTypeScript      = "script"
+)
+
+// Redirect is a single hop of the redirect chain followed by the main document.
+// StatusCode is only set for http redirects.
+type Redirect struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Type       string `json:"type"`
+	StatusCode int    `json:"statusCode,omitempty"`
+}
+
+// RedirectChain is the list of redirects the main document went through while
+// rendering, in the order they happened.
+type RedirectChain []Redirect
+
+// Location returns the final url of the redirect chain, or an empty string if
+// no redirect happened.
+func (c RedirectChain) Location() string {
+	if len(c) == 0 {
+		return ""
+	}
+	return c[len(c)-1].To
+}
+
+// StatusCode returns the status code to answer with when responding the redirect
+// chain as a single redirect. The status code of the first http redirect is used,
+// client side redirects (meta refresh or script) are answered with 302 Found.
+func (c RedirectChain) StatusCode() int {
+	if len(c) == 0 {
+		return 0
+	}
+	if c[0].Type == RedirectTypeHttp && c[0].StatusCode != 0 {
+		return c[0].StatusCode
+	}
+	return http.StatusFound
+}
+
+// RenderResult holds the rendered html content along with the information of the
+// main document response captured during rendering. Archive holds the MHTML
+// snapshot of the page if requested by RendererOption.Archive.
+type RenderResult struct {
+	Content    []byte
+	StatusCode int
+	Redirects  RedirectChain
+	Archive    []byte
+}
+
+type Renderer struct {
+	logger    *slog.Logger
+	endpoints *Endpoints
+}
+
+func NewRenderer(opts ...func(*Renderer)) *Renderer {
+	r := &Renderer{
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func WithLogger(logger *slog.Logger) func(*Renderer) {
+	return func(r *Renderer) {
+		r.logger = logger
+	}
+}
+
+// WithEndpoints sets the remote browser endpoints to connect to, the remote
+// endpoints of RendererOption are ignored if set.
+func WithEndpoints(endpoints *Endpoints) func(*Renderer) {
+	return func(r *Renderer) {
+		r.endpoints = endpoints
+	}
+}
+
+// PageRenderer renders a page into a RenderResult, it is implemented by both
+// Renderer and Pool.
+type PageRenderer interface {
+	RenderPage(ctx context.Context, urlStr string, opts *RendererOption) (*RenderResult, error)
+}
+
+// RenderPage renders the given url in a new browser instance and returns the
+// rendered html content. The status code of the main document and the redirects
+// it went through (http, meta refresh or script initiated) are captured in the
+// result. If the rendered page contains a prerender-status-code meta tag with a
+// valid status code, it will be used as the result status code instead. The
+// browser is closed and ctx.Err() is returned if ctx is done before the render
+// completes.
+func (r *Renderer) RenderPage(
+	ctx context.Context,
+	urlStr string,
+	opts *RendererOption,
+) (*RenderResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("render page: %w", err)
+	}
+
+	browserCtx, cancel, err := r.startBrowser(opts)
+	if err != nil {
+		return nil, fmt.Errorf("render page: %w", err)
+	}
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	result, err := r.render(browserCtx, urlStr, opts)
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("render page: %w", ctx.Err())
+	}
+	return result, err
+}
+
+// startBrowser launches a local browser, or connects to a remote browser if
+// remote endpoints are configured. Remote endpoints failing to connect are marked
+// as failed and the next healthy endpoint is tried.
+func (r *Renderer) startBrowser(opts *RendererOption) (context.Context, context.CancelFunc, error) {
+	endpoints := r.endpoints
+	if endpoints == nil && len(opts.BrowserOpts.RemoteEndpoints) > 0 {
+		endpoints = NewEndpoints(opts.BrowserOpts.RemoteEndpoints, r.logger)
+	}
+
+	if endpoints == nil {
+		allocCtx, allocCancel := chromedp.NewExecAllocator(
+			context.Background(),
+			r.allocatorOptions(opts)...,
+		)
+		ctx, cancel := chromedp.NewContext(allocCtx, r.contextOptions(opts)...)
+		if err := chromedp.Run(ctx); err != nil {
+			cancel()
+			allocCancel()
+			return nil, nil, fmt.Errorf("start browser: %w", err)
+		}
+		return ctx, func() { cancel(); allocCancel() }, nil
+	}
+
+	var lastErr error
+	for range endpoints.Len() {
+		endpoint, err := endpoints.Next()
+		if err != nil {
+			return nil, nil, fmt.Errorf("start browser: %w", err)
+		}
+
+		allocCtx, allocCancel := chromedp.NewRemoteAllocator(context.Background(), endpoint)
+		ctx, cancel := chromedp.NewContext(allocCtx, r.contextOptions(opts)...)
+		if err := chromedp.Run(ctx); err != nil {
+			cancel()
+			allocCancel()
+			r.logger.Info(
+				"Failed to connect remote browser",
+				slog.String("endpoint", endpoint),
+				slog.String("error", err.Error()),
+			)
+			endpoints.MarkFailed(endpoint)
+			lastErr = err
+			continue
+		}
+		r.logger.Debug("Remote browser connected", slog.String("endpoint", endpoint))
+		return ctx, func() { cancel(); allocCancel() }, nil
+	}
+
+	return nil, nil, fmt.Errorf("start browser: %w", lastErr)
+}
+
+// render renders the given url in the browser tab of ctx, the tab is created if
+// it is not started yet.
+func (r *Renderer) render(ctx context.Context, urlStr string, opts *RendererOption) (*RenderResult, error) {
+	// Start the tab before navigating to get the main frame id
+	if err := chromedp.Run(ctx); err != nil {
+		return nil, fmt.Errorf("render page: %w", err)
+	}
+	mainFrame := cdp.FrameID(chromedp.FromContext(ctx).Target.TargetID)
+
+	tracker := newPageTracker(mainFrame, opts.BrowserOpts.IdleType)
+	chromedp.ListenTarget(ctx, tracker.listen)
+
+	// Launch flags do not apply to remote browsers, emulate them in the tab
+	if r.endpoints != nil || len(opts.BrowserOpts.RemoteEndpoints) > 0 {
+		if err := chromedp.Run(ctx, r.emulation(opts)...); err != nil {
+			return nil, fmt.Errorf("render page: %w", err)
+		}
+	}
+
+	ctx, timeoutCancel := context.WithTimeout(ctx, time.Duration(opts.Timeout)*time.Second)
+	defer timeoutCancel()
+
+	r.logger.Debug("Renderer navigating", slog.String("url", urlStr))
+	resp, err := chromedp.RunResponse(ctx, chromedp.Navigate(urlStr))
+	if err != nil {
+		return nil, fmt.Errorf("render page: %w", err)
+	}
+
+	select {
+	case <-tracker.idle:
+		r.logger.Debug("Renderer page idle", slog.String("url", urlStr))
+	case <-ctx.Done():
+		return nil, fmt.Errorf("render page: wait for idle: %w", ctx.Err())
+	}
+
+	var html, metaStatus string
+	err = chromedp.Run(
+		ctx,
+		chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+		chromedp.Evaluate(metaContentScript(StatusCodeMetaName), &metaStatus),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("render page: %w", err)
+	}
+
+	result := &RenderResult{
+		Content:    []byte(html),
+		StatusCode: tracker.documentStatus(int(resp.Status)),
+		Redirects:  tracker.redirectChain(),
+	}
+	if code, ok := metaStatusCode(metaStatus); ok {
+		r.logger.Debug(
+			"Status code overridden by meta tag",
+			slog.String("url", urlStr),
+			slog.Int("status", code),
+		)
+		result.StatusCode = code
+	}
+
+	if opts.Archive {
+		var snapshot string
+		err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			snapshot, err = page.CaptureSnapshot().WithFormat(page.CaptureSnapshotFormatMhtml).Do(ctx)
+			return err
+		}))
+		if err != nil {
+			return nil, fmt.Errorf("render page: capture snapshot: %w", err)
+		}
+		result.Archive = []byte(snapshot)
+	}
+
+	return result, nil
+}
+
+func (r *Renderer) emulation(opts *RendererOption) []chromedp.Action {
+	actions := []chromedp.Action{
+		chromedp.EmulateViewport(int64(opts.WindowWidth), int64(opts.WindowHeight)),
+	}
+	if opts.UserAgent != "" {
+		actions = append(actions, emulation.SetUserAgentOverride(opts.UserAgent))
+	}
+	return actions
+}
+
+func (r *Renderer) contextOptions(opts *RendererOption) []chromedp.ContextOption {
+	var ctxOpts []chromedp.ContextOption
+	if opts.BrowserOpts.ChromiumDebug {
+		ctxOpts = append(ctxOpts, chromedp.WithDebugf(func(format string, args ...any) {
+			r.logger.Debug(fmt.Sprintf(format, args...))
+		}))
+	}
+	return ctxOpts
+}
+
+func (r *Renderer) allocatorOptions(opts *RendererOption) []chromedp.ExecAllocatorOption {
+	allocOpts := []chromedp.ExecAllocatorOption{
+		chromedp.NoFirstRun,
+		chromedp.NoDefaultBrowserCheck,
+		chromedp.DisableGPU,
+		chromedp.Flag("headless", opts.Headless),
+		chromedp.Flag("hide-scrollbars", opts.Headless),
+		chromedp.Flag("mute-audio", opts.Headless),
+		chromedp.WindowSize(opts.WindowWidth, opts.WindowHeight),
+	}
+	if opts.BrowserOpts.Container {
+		allocOpts = append(
+			allocOpts,
+			chromedp.NoSandbox,
+			chromedp.Flag("disable-dev-shm-usage", true),
+			chromedp.Flag("no-zygote", true),
+			chromedp.Flag("single-process", true),
+		)
+	}
+	if opts.UserAgent != "" {
+		allocOpts = append(allocOpts, chromedp.UserAgent(opts.UserAgent))
+	}
+
+	return allocOpts
+}
+
+// pageTracker listens to the target events of the main frame to detect when the
+// page becomes idle and to record the redirects and the response status of the
+// main document.
+type pageTracker struct {
+	mainFrame  cdp.FrameID
+	idleEvents []string
+	idle       chan struct{}
+
+	mu          sync.Mutex
+	loaderID    cdp.LoaderID
+	idleOnce    sync.Once
+	redirects   RedirectChain
+	documentUrl string
+	status      int
+}
+
+func newPageTracker(mainFrame cdp.FrameID, idleType string) *pageTracker {
+	var idleEvents []string
+	switch idleType {
+	case IdleTypeNetworkIdle:
+		idleEvents = []string{IdleTypeNetworkIdle}
+	case IdleTypeInteractiveTime:
+		idleEvents = []string{IdleTypeInteractiveTime}
+	default:
+		idleEvents = []string{IdleTypeNetworkIdle, IdleTypeInteractiveTime}
+	}
+
+	return &pageTracker{
+		mainFrame:  mainFrame,
+		idleEvents: idleEvents,
+		idle:       make(chan struct{}),
+	}
+}
+
+func (t *pageTracker) listen(ev any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch e := ev.(type) {
+	case *page.EventLifecycleEvent:
+		if e.FrameID != t.mainFrame {
+			return
+		}
+		if e.Name == "init" {
+			t.loaderID = e.LoaderID
+			return
+		}
+		if e.LoaderID != t.loaderID {
+			return
+		}
+		for _, name := range t.idleEvents {
+			if e.Name == name {
+				t.idleOnce.Do(func() { close(t.idle) })
+			}
+		}
+	case *network.EventRequestWillBeSent:
+		if e.FrameID != t.mainFrame || e.Type != network.ResourceTypeDocument {
+			return
+		}
+		if e.RedirectResponse != nil {
+			t.redirects = append(t.redirects, Redirect{
+				From:       e.RedirectResponse.URL,
+				To:         e.Request.URL,
+				Type:       RedirectTypeHttp,
+				StatusCode: int(e.RedirectResponse.Status),
+			})
+		}
+	case *network.EventResponseReceived:
+		if e.FrameID != t.mainFrame || e.Type != network.ResourceTypeDocument {
+			return
+		}
+		t.documentUrl = e.Response.URL
+		t.status = int(e.Response.Status)
+	case *page.EventFrameRequestedNavigation:
+		if e.FrameID != t.mainFrame || t.documentUrl == "" {
+			return
+		}
+		var redirectType string
+		switch e.Reason {
+		case page.ClientNavigationReasonMetaTagRefresh, page.ClientNavigationReasonHTTPHeaderRefresh:
+			redirectType = RedirectTypeMetaRefresh
+		case page.ClientNavigationReasonScriptInitiated:
+			redirectType = RedirectTypeScript
+		default:
+			return
+		}
+		if sameDocument(t.documentUrl, e.URL) {
+			return
+		}
+		t.redirects = append(t.redirects, Redirect{
+			From: t.documentUrl,
+			To:   e.URL,
+			Type: redirectType,
+		})
+	}
+}
+
+func (t *pageTracker) redirectChain() RedirectChain {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append(RedirectChain(nil), t.redirects...)
+}
+
+// documentStatus returns the response status of the last main document loaded,
+// fallback is returned if no document response was captured.
+func (t *pageTracker) documentStatus(fallback int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.status == 0 {
+		return fallback
+	}
+	return t.status
+}
+
+// sameDocument reports whether the two urls only differ in fragment.
+func sameDocument(a, b string) bool {
+	aUrl, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	bUrl, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	aUrl.Fragment, bUrl.Fragment = "", ""
+
+	return aUrl.String() == bUrl.String()
+}
+
+// metaStatusCode returns the status code set by the content of the status code
+// meta tag, false is returned if the content is not a valid status code.
+func metaStatusCode(content string) (int, bool) {
+	code, err := strconv.Atoi(strings.TrimSpace(content))
+	if err != nil || code < 100 || code > 599 {
+		return 0, false
+	}
+	return code, true
+}
+
+func metaContentScript(name string) string {
+	return fmt.Sprintf(
+		`(() => { const m = document.querySelector('meta[name=%q]'); return m ? m.getAttribute('content') : ''; })()`,
+		name,
+	)
+}
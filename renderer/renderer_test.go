@@ -0,0 +1,130 @@
+package renderer
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+)
+
+// documentResponse returns the response event of a document of frame.
+func documentResponse(frame cdp.FrameID, url string, status int64) *network.EventResponseReceived {
+	return &network.EventResponseReceived{
+		FrameID:  frame,
+		Type:     network.ResourceTypeDocument,
+		Response: &network.Response{URL: url, Status: status},
+	}
+}
+
+func TestPageTrackerDocumentStatus(t *testing.T) {
+	const mainFrame = cdp.FrameID("main")
+
+	tracker := newPageTracker(mainFrame, "")
+	if got := tracker.documentStatus(200); got != 200 {
+		t.Errorf("documentStatus() without response = %d, want the fallback 200", got)
+	}
+
+	tracker.listen(documentResponse(mainFrame, "https://a.com/missing", 404))
+	// Responses of other frames and of subresources are not the page status
+	tracker.listen(documentResponse("iframe", "https://b.com/", 200))
+	tracker.listen(&network.EventResponseReceived{
+		FrameID:  mainFrame,
+		Type:     network.ResourceTypeScript,
+		Response: &network.Response{URL: "https://a.com/app.js", Status: 200},
+	})
+	if got := tracker.documentStatus(200); got != 404 {
+		t.Errorf("documentStatus() = %d, want 404", got)
+	}
+}
+
+func TestMetaStatusCode(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int
+		wantOk  bool
+	}{
+		{"404", 404, true},
+		{" 503 ", 503, true},
+		{"", 0, false},
+		{"not found", 0, false},
+		{"99", 0, false},
+		{"600", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := metaStatusCode(tt.content)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("metaStatusCode(%q) = (%d, %v), want (%d, %v)", tt.content, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestPageTrackerRedirects(t *testing.T) {
+	const mainFrame = cdp.FrameID("main")
+
+	tracker := newPageTracker(mainFrame, "")
+	tracker.listen(&network.EventRequestWillBeSent{
+		FrameID:          mainFrame,
+		Type:             network.ResourceTypeDocument,
+		Request:          &network.Request{URL: "https://a.com/new"},
+		RedirectResponse: &network.Response{URL: "http://a.com/old", Status: 301},
+	})
+	// Redirects of other frames are not redirects of the page
+	tracker.listen(&network.EventRequestWillBeSent{
+		FrameID:          "iframe",
+		Type:             network.ResourceTypeDocument,
+		Request:          &network.Request{URL: "https://b.com/new"},
+		RedirectResponse: &network.Response{URL: "https://b.com/old", Status: 302},
+	})
+	tracker.listen(documentResponse(mainFrame, "https://a.com/new", 200))
+	// Fragment navigations stay on the same document
+	tracker.listen(&page.EventFrameRequestedNavigation{
+		FrameID: mainFrame,
+		Reason:  page.ClientNavigationReasonScriptInitiated,
+		URL:     "https://a.com/new#top",
+	})
+	tracker.listen(&page.EventFrameRequestedNavigation{
+		FrameID: mainFrame,
+		Reason:  page.ClientNavigationReasonMetaTagRefresh,
+		URL:     "https://a.com/final",
+	})
+
+	want := RedirectChain{
+		{From: "http://a.com/old", To: "https://a.com/new", Type: RedirectTypeHttp, StatusCode: 301},
+		{From: "https://a.com/new", To: "https://a.com/final", Type: RedirectTypeMetaRefresh},
+	}
+	got := tracker.redirectChain()
+	if !slices.Equal(got, want) {
+		t.Fatalf("redirectChain() = %+v, want %+v", got, want)
+	}
+	if got.Location() != "https://a.com/final" || got.StatusCode() != 301 {
+		t.Errorf("Location(), StatusCode() = %s, %d, want https://a.com/final, 301", got.Location(), got.StatusCode())
+	}
+}
+
+func TestRedirectChainStatusCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain RedirectChain
+		want  int
+	}{
+		{"no redirect", nil, 0},
+		{"http", RedirectChain{{Type: RedirectTypeHttp, StatusCode: 308}}, 308},
+		{"script", RedirectChain{{Type: RedirectTypeScript}}, 302},
+		{"meta refresh first", RedirectChain{
+			{Type: RedirectTypeMetaRefresh},
+			{Type: RedirectTypeHttp, StatusCode: 301},
+		}, 302},
+	}
+
+	for _, tt := range tests {
+		if got := tt.chain.StatusCode(); got != tt.want {
+			t.Errorf("%s: StatusCode() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := (RedirectChain{}).Location(); got != "" {
+		t.Errorf("Location() without redirect = %q, want empty", got)
+	}
+}
@@ -64,10 +64,10 @@ func (c BoltCaching) Update(reader io.Reader) error {
 			return err
 		}
 
-        data, err := io.ReadAll(reader)
-        if err != nil {
-            return err
-        }
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
 
 		return hostBucket.Put(CacheContent(c.CachedKey), data)
 	})
@@ -0,0 +1,468 @@
+package wrender
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/liuminhaw/wrenderer/internal"
+)
+
+// QueuedJobPrefix is the root bucket of the persisted job queues.
+//
+// boltdb queue: {QueuedJobPrefix}: bucket, {category}: bucket, {job key}: bucket,
+// {queued|processing|succeeded|failed|skipped}: bucket, {sequence}: key
+const QueuedJobPrefix = "queue"
+
+// jobMetaKey is the key of the job parameters in the job bucket.
+const jobMetaKey = "job"
+
+// seenBucket is the bucket of the urls added by Enqueue to a job, keyed by url.
+const seenBucket = "seen"
+
+// QueuedEntry is an entry of a persisted job queue. Seq is the position of the
+// entry in the queue.
+type QueuedEntry struct {
+	Seq       uint64 `json:"-"`
+	TargetUrl string `json:"targetUrl"`
+	Depth     int    `json:"depth,omitempty"`
+}
+
+// BoltJobQueue persists the entries of a job in bolt database, entries move from
+// the queued to the processing state when taken, and to the succeeded or failed
+// state with their result once done. This follows the job cache states of the
+// Lambda sitemap jobs.
+type BoltJobQueue struct {
+	DB       *bolt.DB
+	Category string
+	Key      string
+}
+
+// NewBoltJobQueue creates the BoltJobQueue of the job of key in category.
+func NewBoltJobQueue(db *bolt.DB, category, key string) BoltJobQueue {
+	return BoltJobQueue{DB: db, Category: category, Key: key}
+}
+
+// ListJobQueues returns the keys of the jobs of category with a persisted queue.
+func ListJobQueues(db *bolt.DB, category string) ([]string, error) {
+	var keys []string
+	err := db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(QueuedJobPrefix))
+		if root == nil {
+			return nil
+		}
+		categoryBucket := root.Bucket([]byte(category))
+		if categoryBucket == nil {
+			return nil
+		}
+		return categoryBucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list job queues: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Create persists the job parameters meta and the entries of the job in order.
+// The entries are put in the state of their Status, the entries which are not
+// skipped are queued.
+func (q BoltJobQueue) Create(meta any, entries []JobEntryResult) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("create job queue: %w", err)
+	}
+
+	err = q.DB.Update(func(tx *bolt.Tx) error {
+		job, err := q.createJobBucket(tx)
+		if err != nil {
+			return err
+		}
+		if err := job.Put([]byte(jobMetaKey), data); err != nil {
+			return err
+		}
+
+		// The sequence of the queued bucket orders the entries of all states
+		queued := job.Bucket([]byte(internal.JobStatusQueued))
+		skipped := job.Bucket([]byte(internal.JobStatusSkipped))
+		for _, entry := range entries {
+			seq, err := queued.NextSequence()
+			if err != nil {
+				return err
+			}
+			if entry.Status != internal.JobStatusSkipped {
+				if err := putEntry(queued, seq, QueuedEntry{TargetUrl: entry.TargetUrl}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := skipped.Put(sequenceKey(seq), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("create job queue: %w", err)
+	}
+
+	return nil
+}
+
+// Enqueue queues the entries whose url was not enqueued to the job before, until
+// the job has limit entries in total, 0 disables the limit. The number of entries
+// queued is returned.
+func (q BoltJobQueue) Enqueue(entries []QueuedEntry, limit int) (int, error) {
+	var added int
+	err := q.DB.Update(func(tx *bolt.Tx) error {
+		job := q.jobBucket(tx)
+		if job == nil {
+			return &CacheNotFoundError{err: fmt.Errorf("job queue %s not found", q.Key)}
+		}
+		seen, err := job.CreateBucketIfNotExists([]byte(seenBucket))
+		if err != nil {
+			return err
+		}
+
+		queued := job.Bucket([]byte(internal.JobStatusQueued))
+		for _, entry := range entries {
+			// The sequence counts the entries of all states
+			if limit > 0 && queued.Sequence() >= uint64(limit) {
+				return nil
+			}
+			if seen.Get([]byte(entry.TargetUrl)) != nil {
+				continue
+			}
+			if err := seen.Put([]byte(entry.TargetUrl), []byte{1}); err != nil {
+				return err
+			}
+
+			seq, err := queued.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := putEntry(queued, seq, entry); err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("job queue enqueue: %w", err)
+	}
+
+	return added, nil
+}
+
+// Meta reads the job parameters persisted by Create into meta.
+func (q BoltJobQueue) Meta(meta any) error {
+	err := q.DB.View(func(tx *bolt.Tx) error {
+		job := q.jobBucket(tx)
+		if job == nil {
+			return &CacheNotFoundError{err: fmt.Errorf("job queue %s not found", q.Key)}
+		}
+		return json.Unmarshal(job.Get([]byte(jobMetaKey)), meta)
+	})
+	if err != nil {
+		return fmt.Errorf("job queue meta: %w", err)
+	}
+
+	return nil
+}
+
+// Next moves the first queued entry to the processing state and returns it, false
+// is returned if no entry is queued.
+func (q BoltJobQueue) Next() (QueuedEntry, bool, error) {
+	var entry QueuedEntry
+	var found bool
+	err := q.DB.Update(func(tx *bolt.Tx) error {
+		job := q.jobBucket(tx)
+		if job == nil {
+			return nil
+		}
+
+		queued := job.Bucket([]byte(internal.JobStatusQueued))
+		k, v := queued.Cursor().First()
+		if k == nil {
+			return nil
+		}
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return err
+		}
+		entry.Seq = binary.BigEndian.Uint64(k)
+		found = true
+
+		if err := queued.Delete(k); err != nil {
+			return err
+		}
+		return job.Bucket([]byte(internal.JobStatusProcessing)).Put(k, v)
+	})
+	if err != nil {
+		return QueuedEntry{}, false, fmt.Errorf("job queue next: %w", err)
+	}
+
+	return entry, found, nil
+}
+
+// Complete moves the processing entry to the succeeded state with its result.
+func (q BoltJobQueue) Complete(entry QueuedEntry, result JobEntryResult) error {
+	return q.finish(entry, internal.JobStatusSucceeded, result)
+}
+
+// Fail moves the processing entry to the failed state with its result.
+func (q BoltJobQueue) Fail(entry QueuedEntry, result JobEntryResult) error {
+	return q.finish(entry, internal.JobStatusFailed, result)
+}
+
+// Skip moves the processing entry to the skipped state with its result.
+func (q BoltJobQueue) Skip(entry QueuedEntry, result JobEntryResult) error {
+	return q.finish(entry, internal.JobStatusSkipped, result)
+}
+
+// Requeue moves the entries left in the processing state back to the queued
+// state, in their original order.
+func (q BoltJobQueue) Requeue() error {
+	err := q.DB.Update(func(tx *bolt.Tx) error {
+		job := q.jobBucket(tx)
+		if job == nil {
+			return nil
+		}
+
+		processing := job.Bucket([]byte(internal.JobStatusProcessing))
+		queued := job.Bucket([]byte(internal.JobStatusQueued))
+		var keys [][]byte
+		err := processing.ForEach(func(k, v []byte) error {
+			keys = append(keys, k)
+			return queued.Put(k, v)
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := processing.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("job queue requeue: %w", err)
+	}
+
+	return nil
+}
+
+// Count returns the number of entries in the given state.
+func (q BoltJobQueue) Count(state string) (int, error) {
+	var count int
+	err := q.DB.View(func(tx *bolt.Tx) error {
+		job := q.jobBucket(tx)
+		if job == nil {
+			return nil
+		}
+		if bucket := job.Bucket([]byte(state)); bucket != nil {
+			count = bucket.Stats().KeyN
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("job queue count: %w", err)
+	}
+
+	return count, nil
+}
+
+// Progress counts the entries of the job in each state, for a job started and
+// finished at the given times.
+func (q BoltJobQueue) Progress(started time.Time, finished *time.Time) (JobProgress, error) {
+	progress := JobProgress{Started: started, Finished: finished}
+	counts := map[string]*int{
+		internal.JobStatusQueued:     &progress.Queued,
+		internal.JobStatusProcessing: &progress.Processing,
+		internal.JobStatusSucceeded:  &progress.Succeeded,
+		internal.JobStatusFailed:     &progress.Failed,
+		internal.JobStatusSkipped:    &progress.Skipped,
+	}
+	for state, count := range counts {
+		n, err := q.Count(state)
+		if err != nil {
+			return JobProgress{}, err
+		}
+		*count = n
+		progress.Total += n
+	}
+
+	return progress, nil
+}
+
+// Delete removes the queue of the job.
+func (q BoltJobQueue) Delete() error {
+	err := q.DB.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(QueuedJobPrefix))
+		if root == nil {
+			return nil
+		}
+		categoryBucket := root.Bucket([]byte(q.Category))
+		if categoryBucket == nil || categoryBucket.Bucket([]byte(q.Key)) == nil {
+			return nil
+		}
+		return categoryBucket.DeleteBucket([]byte(q.Key))
+	})
+	if err != nil {
+		return fmt.Errorf("delete job queue: %w", err)
+	}
+
+	return nil
+}
+
+// finish moves the processing entry to the given state with its result.
+func (q BoltJobQueue) finish(entry QueuedEntry, state string, result JobEntryResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("job queue finish: %w", err)
+	}
+
+	err = q.DB.Update(func(tx *bolt.Tx) error {
+		job := q.jobBucket(tx)
+		if job == nil {
+			return nil
+		}
+
+		key := sequenceKey(entry.Seq)
+		if err := job.Bucket([]byte(internal.JobStatusProcessing)).Delete(key); err != nil {
+			return err
+		}
+		// Queues created before the succeeded state was kept lack its bucket
+		bucket, err := job.CreateBucketIfNotExists([]byte(state))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	})
+	if err != nil {
+		return fmt.Errorf("job queue finish: %w", err)
+	}
+
+	return nil
+}
+
+// Results returns the entries of the job from offset, up to limit entries, along
+// with the total number of entries. The entries are listed in queue order with
+// the state they are in.
+func (q BoltJobQueue) Results(offset, limit int) ([]JobEntryResult, int, error) {
+	results := []JobEntryResult{}
+	var total int
+	err := q.DB.View(func(tx *bolt.Tx) error {
+		job := q.jobBucket(tx)
+		if job == nil {
+			return nil
+		}
+
+		// Merge the states buckets by sequence to keep the queue order
+		cursors := map[string]*bolt.Cursor{}
+		keys := map[string][]byte{}
+		values := map[string][]byte{}
+		for _, state := range JobEntryStates {
+			bucket := job.Bucket([]byte(state))
+			if bucket == nil {
+				continue
+			}
+			cursors[state] = bucket.Cursor()
+			keys[state], values[state] = cursors[state].First()
+		}
+
+		for {
+			var state string
+			for _, s := range JobEntryStates {
+				if keys[s] == nil {
+					continue
+				}
+				if state == "" || bytes.Compare(keys[s], keys[state]) < 0 {
+					state = s
+				}
+			}
+			if state == "" {
+				return nil
+			}
+
+			if total >= offset && len(results) < limit {
+				var result JobEntryResult
+				if err := json.Unmarshal(values[state], &result); err != nil {
+					return err
+				}
+				result.Status = state
+				results = append(results, result)
+			}
+			total++
+			keys[state], values[state] = cursors[state].Next()
+		}
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("job queue results: %w", err)
+	}
+
+	return results, total, nil
+}
+
+func (q BoltJobQueue) createJobBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	root, err := tx.CreateBucketIfNotExists([]byte(QueuedJobPrefix))
+	if err != nil {
+		return nil, err
+	}
+	categoryBucket, err := root.CreateBucketIfNotExists([]byte(q.Category))
+	if err != nil {
+		return nil, err
+	}
+	job, err := categoryBucket.CreateBucketIfNotExists([]byte(q.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, state := range JobEntryStates {
+		if _, err := job.CreateBucketIfNotExists([]byte(state)); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+func (q BoltJobQueue) jobBucket(tx *bolt.Tx) *bolt.Bucket {
+	root := tx.Bucket([]byte(QueuedJobPrefix))
+	if root == nil {
+		return nil
+	}
+	categoryBucket := root.Bucket([]byte(q.Category))
+	if categoryBucket == nil {
+		return nil
+	}
+	return categoryBucket.Bucket([]byte(q.Key))
+}
+
+func putEntry(bucket *bolt.Bucket, seq uint64, entry QueuedEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(sequenceKey(seq), data)
+}
+
+// sequenceKey encodes seq in big endian so that keys sort in queue order.
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
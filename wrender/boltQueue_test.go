@@ -0,0 +1,334 @@
+package wrender
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/liuminhaw/wrenderer/internal"
+)
+
+// newTestJobQueue returns an empty job queue in a temporary bolt database.
+func newTestJobQueue(t *testing.T) BoltJobQueue {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "queue.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("open bolt db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	queue := NewBoltJobQueue(db, internal.CrawlCategory, "abcdef-ghijkl")
+	if err := queue.Create(struct{}{}, nil); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	return queue
+}
+
+func queuedEntries(urls ...string) []QueuedEntry {
+	entries := make([]QueuedEntry, 0, len(urls))
+	for _, url := range urls {
+		entries = append(entries, QueuedEntry{TargetUrl: url})
+	}
+	return entries
+}
+
+// nextUrls takes the queued entries until the queue is empty.
+func nextUrls(t *testing.T, queue BoltJobQueue) []string {
+	t.Helper()
+
+	var urls []string
+	for {
+		entry, ok, err := queue.Next()
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		if !ok {
+			return urls
+		}
+		urls = append(urls, entry.TargetUrl)
+	}
+}
+
+func assertCount(t *testing.T, queue BoltJobQueue, state string, want int) {
+	t.Helper()
+
+	got, err := queue.Count(state)
+	if err != nil {
+		t.Fatalf("Count(%s) error: %v", state, err)
+	}
+	if got != want {
+		t.Errorf("Count(%s) = %d, want %d", state, got, want)
+	}
+}
+
+func TestBoltJobQueueEnqueue(t *testing.T) {
+	tests := []struct {
+		name    string
+		batches [][]string
+		limit   int
+		added   []int
+		want    []string
+	}{
+		{
+			name:    "all new",
+			batches: [][]string{{"https://a.com/1", "https://a.com/2"}},
+			added:   []int{2},
+			want:    []string{"https://a.com/1", "https://a.com/2"},
+		},
+		{
+			name:    "duplicates within a batch",
+			batches: [][]string{{"https://a.com/1", "https://a.com/1", "https://a.com/2"}},
+			added:   []int{2},
+			want:    []string{"https://a.com/1", "https://a.com/2"},
+		},
+		{
+			name: "duplicates across batches",
+			batches: [][]string{
+				{"https://a.com/1", "https://a.com/2"},
+				{"https://a.com/2", "https://a.com/3"},
+			},
+			added: []int{2, 1},
+			want:  []string{"https://a.com/1", "https://a.com/2", "https://a.com/3"},
+		},
+		{
+			name: "limit",
+			batches: [][]string{
+				{"https://a.com/1", "https://a.com/2"},
+				{"https://a.com/3", "https://a.com/4"},
+			},
+			limit: 3,
+			added: []int{2, 1},
+			want:  []string{"https://a.com/1", "https://a.com/2", "https://a.com/3"},
+		},
+		{
+			name:    "limit reached",
+			batches: [][]string{{"https://a.com/1", "https://a.com/2"}, {"https://a.com/3"}},
+			limit:   2,
+			added:   []int{2, 0},
+			want:    []string{"https://a.com/1", "https://a.com/2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := newTestJobQueue(t)
+			for i, batch := range tt.batches {
+				added, err := queue.Enqueue(queuedEntries(batch...), tt.limit)
+				if err != nil {
+					t.Fatalf("Enqueue() error: %v", err)
+				}
+				if added != tt.added[i] {
+					t.Errorf("Enqueue() batch %d added %d, want %d", i, added, tt.added[i])
+				}
+			}
+
+			if got := nextUrls(t, queue); !slices.Equal(got, tt.want) {
+				t.Errorf("queued urls = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoltJobQueueEnqueueMissing(t *testing.T) {
+	queue := newTestJobQueue(t)
+	queue.Key = "missing"
+
+	_, err := queue.Enqueue(queuedEntries("https://a.com/1"), 0)
+	var werr *CacheNotFoundError
+	if !errors.As(err, &werr) {
+		t.Errorf("Enqueue() error = %v, want CacheNotFoundError", err)
+	}
+}
+
+func TestBoltJobQueueFinished(t *testing.T) {
+	queue := newTestJobQueue(t)
+	if _, err := queue.Enqueue(queuedEntries("https://a.com/1", "https://a.com/2", "https://a.com/3"), 0); err != nil {
+		t.Fatalf("Enqueue() error: %v", err)
+	}
+
+	var entries []QueuedEntry
+	for range 3 {
+		entry, ok, err := queue.Next()
+		if err != nil || !ok {
+			t.Fatalf("Next() = (%v, %v), want an entry", ok, err)
+		}
+		entries = append(entries, entry)
+	}
+	assertCount(t, queue, internal.JobStatusQueued, 0)
+	assertCount(t, queue, internal.JobStatusProcessing, 3)
+
+	complete := NewJobEntryResult(entries[0].TargetUrl, internal.JobStatusSucceeded, 0, nil)
+	if err := queue.Complete(entries[0], complete); err != nil {
+		t.Fatalf("Complete() error: %v", err)
+	}
+	fail := NewJobEntryResult(entries[1].TargetUrl, internal.JobStatusFailed, 0, errors.New("render failed"))
+	if err := queue.Fail(entries[1], fail); err != nil {
+		t.Fatalf("Fail() error: %v", err)
+	}
+
+	assertCount(t, queue, internal.JobStatusProcessing, 1)
+	assertCount(t, queue, internal.JobStatusSucceeded, 1)
+	assertCount(t, queue, internal.JobStatusFailed, 1)
+}
+
+func TestBoltJobQueueProgress(t *testing.T) {
+	queue := newTestJobQueue(t)
+	if _, err := queue.Enqueue(queuedEntries("https://a.com/1", "https://a.com/2", "https://a.com/3", "https://a.com/4"), 0); err != nil {
+		t.Fatalf("Enqueue() error: %v", err)
+	}
+	for i := range 3 {
+		entry, _, err := queue.Next()
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		switch i {
+		case 0:
+			err = queue.Complete(entry, NewJobEntryResult(entry.TargetUrl, internal.JobStatusSucceeded, 0, nil))
+		case 1:
+			err = queue.Fail(entry, NewJobEntryResult(entry.TargetUrl, internal.JobStatusFailed, 0, errors.New("render failed")))
+		}
+		if err != nil {
+			t.Fatalf("finish entry error: %v", err)
+		}
+	}
+
+	started := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	progress, err := queue.Progress(started, nil)
+	if err != nil {
+		t.Fatalf("Progress() error: %v", err)
+	}
+	want := JobProgress{Total: 4, Queued: 1, Processing: 1, Succeeded: 1, Failed: 1, Started: started}
+	if !reflect.DeepEqual(progress, want) {
+		t.Errorf("Progress() = %+v, want %+v", progress, want)
+	}
+}
+
+func TestBoltJobQueueRequeue(t *testing.T) {
+	queue := newTestJobQueue(t)
+	urls := []string{"https://a.com/1", "https://a.com/2", "https://a.com/3", "https://a.com/4"}
+	if _, err := queue.Enqueue(queuedEntries(urls...), 0); err != nil {
+		t.Fatalf("Enqueue() error: %v", err)
+	}
+
+	// Take the first two entries, leaving them processing as on a shutdown
+	for range 2 {
+		if _, _, err := queue.Next(); err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+	}
+	if err := queue.Requeue(); err != nil {
+		t.Fatalf("Requeue() error: %v", err)
+	}
+	assertCount(t, queue, internal.JobStatusProcessing, 0)
+	assertCount(t, queue, internal.JobStatusQueued, 4)
+
+	if got := nextUrls(t, queue); !slices.Equal(got, urls) {
+		t.Errorf("urls after requeue = %v, want %v", got, urls)
+	}
+}
+
+func TestBoltJobQueueResults(t *testing.T) {
+	queue := newTestJobQueue(t)
+	urls := []string{"https://a.com/1", "https://a.com/2", "https://a.com/3", "https://a.com/4", "https://a.com/5"}
+	if _, err := queue.Enqueue(queuedEntries(urls...), 0); err != nil {
+		t.Fatalf("Enqueue() error: %v", err)
+	}
+
+	// 1 succeeded, 2 failed, 3 processing, 4 and 5 queued
+	first, _, _ := queue.Next()
+	second, _, _ := queue.Next()
+	if _, _, err := queue.Next(); err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if err := queue.Complete(first, NewJobEntryResult(first.TargetUrl, "", 0, nil)); err != nil {
+		t.Fatalf("Complete() error: %v", err)
+	}
+	if err := queue.Fail(second, NewJobEntryResult(second.TargetUrl, "", 0, errors.New("failed"))); err != nil {
+		t.Fatalf("Fail() error: %v", err)
+	}
+
+	states := []string{
+		internal.JobStatusSucceeded,
+		internal.JobStatusFailed,
+		internal.JobStatusProcessing,
+		internal.JobStatusQueued,
+		internal.JobStatusQueued,
+	}
+	tests := []struct {
+		name   string
+		offset int
+		limit  int
+		want   []int
+	}{
+		{"all", 0, 10, []int{0, 1, 2, 3, 4}},
+		{"first page", 0, 2, []int{0, 1}},
+		{"middle page", 2, 2, []int{2, 3}},
+		{"last page", 4, 2, []int{4}},
+		{"past the end", 6, 2, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, total, err := queue.Results(tt.offset, tt.limit)
+			if err != nil {
+				t.Fatalf("Results() error: %v", err)
+			}
+			if total != len(urls) {
+				t.Errorf("Results() total = %d, want %d", total, len(urls))
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("Results() returned %d entries, want %d", len(results), len(tt.want))
+			}
+			for i, idx := range tt.want {
+				if results[i].TargetUrl != urls[idx] || results[i].Status != states[idx] {
+					t.Errorf(
+						"Results()[%d] = (%s, %s), want (%s, %s)",
+						i, results[i].TargetUrl, results[i].Status, urls[idx], states[idx],
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestBoltJobQueueCreate(t *testing.T) {
+	queue := newTestJobQueue(t)
+	queue.Key = "created"
+	entries := []JobEntryResult{
+		{TargetUrl: "https://a.com/1"},
+		{TargetUrl: "https://a.com/2", Status: internal.JobStatusSkipped, Reason: "cached"},
+		{TargetUrl: "https://a.com/3"},
+	}
+	if err := queue.Create(map[string]string{"mode": "missing"}, entries); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+
+	var meta map[string]string
+	if err := queue.Meta(&meta); err != nil {
+		t.Fatalf("Meta() error: %v", err)
+	}
+	if meta["mode"] != "missing" {
+		t.Errorf("Meta() = %v, want mode missing", meta)
+	}
+	assertCount(t, queue, internal.JobStatusQueued, 2)
+	assertCount(t, queue, internal.JobStatusSkipped, 1)
+
+	results, _, err := queue.Results(0, 10)
+	if err != nil {
+		t.Fatalf("Results() error: %v", err)
+	}
+	wantStates := []string{internal.JobStatusQueued, internal.JobStatusSkipped, internal.JobStatusQueued}
+	for i, result := range results {
+		if result.TargetUrl != entries[i].TargetUrl || result.Status != wantStates[i] {
+			t.Errorf(
+				"Results()[%d] = (%s, %s), want (%s, %s)",
+				i, result.TargetUrl, result.Status, entries[i].TargetUrl, wantStates[i],
+			)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package wrender
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// WarmupPrefix is the bucket of the scheduled sitemap warmups.
+//
+// boltdb records: {bucket}: bucket, {record key}: key
+const WarmupPrefix = "warmups"
+
+// BoltRecords stores JSON encoded records by key in a bolt database bucket.
+type BoltRecords struct {
+	DB     *bolt.DB
+	Bucket string
+}
+
+// NewBoltRecords creates the BoltRecords of bucket.
+func NewBoltRecords(db *bolt.DB, bucket string) BoltRecords {
+	return BoltRecords{DB: db, Bucket: bucket}
+}
+
+// Put stores record under key, replacing the previous record of key.
+func (r BoltRecords) Put(key string, record any) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("put record: %w", err)
+	}
+
+	err = r.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(r.Bucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+	if err != nil {
+		return fmt.Errorf("put record: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the record of key.
+func (r BoltRecords) Delete(key string) error {
+	err := r.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(r.Bucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(key))
+	})
+	if err != nil {
+		return fmt.Errorf("delete record: %w", err)
+	}
+
+	return nil
+}
+
+// List returns the encoded records of the bucket in key order.
+func (r BoltRecords) List() ([][]byte, error) {
+	var records [][]byte
+	err := r.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(r.Bucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			records = append(records, append([]byte(nil), v...))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list records: %w", err)
+	}
+
+	return records, nil
+}
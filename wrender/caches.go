@@ -4,9 +4,27 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/liuminhaw/wrenderer/internal"
+	"github.com/liuminhaw/wrenderer/renderer"
+)
+
+const (
+	// ErrorPolicyCache caches error pages the same way as successful pages.
+	ErrorPolicyCache = "cache"
+	// ErrorPolicySkip does not cache error pages.
+	ErrorPolicySkip = "skip"
+	// ErrorPolicyShort caches error pages with a shorter ttl.
+	ErrorPolicyShort = "short"
+
+	// RedirectModeFollow serves the rendered page of the redirect target and
+	// caches it under both the requested and the target url.
+	RedirectModeFollow = "follow"
+	// RedirectModeRespond answers redirected pages with a redirect response
+	// pointing to the redirect target.
+	RedirectModeRespond = "respond"
 )
 
 type CacheContent []byte
@@ -16,17 +34,28 @@ type CacheContentInfo struct {
 	Path    string
 }
 
+// CacheObjectInfo is the path and last modified time of a cached object.
+type CacheObjectInfo struct {
+	Path     string
+	Modified time.Time
+}
+
 type Caches interface {
 	IsExpired() bool
 }
 
 // PageCached stores the source url, rendered content, creation time,
-// and expiration time of the generated page cache.
+// and expiration time of the generated page cache. StatusCode and Redirects
+// record the main document response captured while rendering, Format is set
+// for content converted from the rendered html (text or markdown).
 type PageCached struct {
-	Url     string    `json:"url"`
-	Content []byte    `json:"content"`
-	Created time.Time `json:"created"`
-	Expires time.Time `json:"expires"`
+	Url        string                 `json:"url"`
+	Format     string                 `json:"format,omitempty"`
+	Content    []byte                 `json:"content"`
+	Created    time.Time              `json:"created"`
+	Expires    time.Time              `json:"expires"`
+	StatusCode int                    `json:"statusCode,omitempty"`
+	Redirects  renderer.RedirectChain `json:"redirects,omitempty"`
 }
 
 func NewPageCached(url string, content []byte, ttl time.Duration) PageCached {
@@ -48,6 +77,43 @@ func (p PageCached) IsExpired() bool {
 	return time.Now().UTC().After(p.Expires)
 }
 
+// Status returns the status code of the cached page. Caches created before the
+// status code was recorded are treated as 200 OK.
+func (p PageCached) Status() int {
+	if p.StatusCode == 0 {
+		return http.StatusOK
+	}
+	return p.StatusCode
+}
+
+// IsErrorStatus reports whether the given status code is a client or server error.
+func IsErrorStatus(statusCode int) bool {
+	return statusCode >= http.StatusBadRequest
+}
+
+// PageCacheTtl returns the ttl to use for caching a rendered page with the given
+// status code according to the error policy. Successful pages always use ttl,
+// error pages use errorTtl with ErrorPolicyShort. The returned bool is false if
+// the page should not be cached at all.
+func PageCacheTtl(
+	statusCode int,
+	policy string,
+	ttl, errorTtl time.Duration,
+) (time.Duration, bool) {
+	if !IsErrorStatus(statusCode) {
+		return ttl, true
+	}
+
+	switch policy {
+	case ErrorPolicySkip:
+		return 0, false
+	case ErrorPolicyShort:
+		return errorTtl, true
+	default:
+		return ttl, true
+	}
+}
+
 func (p *PageCached) Update(caching Caching, content []byte, compressed bool) error {
 	if compressed {
 		p.Content = content
@@ -68,10 +134,12 @@ func (p *PageCached) Update(caching Caching, content []byte, compressed bool) er
 }
 
 type PageCachedInfo struct {
-	Path    string    `json:"path"`
-	Url     string    `json:"url"`
-	Created time.Time `json:"created"`
-	Expires time.Time `json:"expires"`
+	Path       string    `json:"path"`
+	Url        string    `json:"url"`
+	Format     string    `json:"format,omitempty"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+	StatusCode int       `json:"statusCode"`
 }
 
 func PagesCachesConversion(cachesInfo []CacheContentInfo) ([]PageCachedInfo, error) {
@@ -83,10 +151,12 @@ func PagesCachesConversion(cachesInfo []CacheContentInfo) ([]PageCachedInfo, err
 			return nil, err
 		}
 		pCachesInfo = append(pCachesInfo, PageCachedInfo{
-			Path:    info.Path,
-			Url:     pCache.Url,
-			Created: pCache.Created,
-			Expires: pCache.Expires,
+			Path:       info.Path,
+			Url:        pCache.Url,
+			Format:     pCache.Format,
+			Created:    pCache.Created,
+			Expires:    pCache.Expires,
+			StatusCode: pCache.Status(),
 		})
 	}
 
@@ -97,7 +167,11 @@ type SitemapJobCache struct {
 	Status  string    `json:"status"`
 	Created time.Time `json:"created"`
 	Expires time.Time `json:"expires"`
-	Failed  []string  `json:"failed,omitempty"`
+	// Finished is set once the job ends, an interrupted job is not finished
+	Finished *time.Time `json:"finished,omitempty"`
+	Failed   []string   `json:"failed,omitempty"`
+	// Reason is the failure of a job which could not queue its entries
+	Reason string `json:"reason,omitempty"`
 }
 
 func NewSitemapJobCache(status string, ttl time.Duration) SitemapJobCache {
@@ -124,9 +198,23 @@ func (c *SitemapJobCache) Update(caching Caching, status string) error {
 	return caching.Update(bytes.NewReader(data))
 }
 
+// SqsJobPayload is the SQS message of an entry of a job. Attempts is the history
+// of the failed renders of the entry, carried along when the entry is queued
+// again to be retried.
 type SqsJobPayload struct {
-	TargetUrl string `json:"targetUrl"`
-	RandomKey string `json:"randomKey"`
+	TargetUrl string            `json:"targetUrl"`
+	RandomKey string            `json:"randomKey"`
+	Category  string            `json:"category,omitempty"`
+	Attempts  []JobEntryAttempt `json:"attempts,omitempty"`
+}
+
+// JobCategory returns the category of the job of the entry, the messages sent
+// without category belong to sitemap jobs.
+func (p SqsJobPayload) JobCategory() string {
+	if p.Category == "" {
+		return internal.SitemapCategory
+	}
+	return p.Category
 }
 
 type SqsJobCache struct {
@@ -0,0 +1,43 @@
+package wrender
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPageCacheTtl(t *testing.T) {
+	ttl, errorTtl := time.Hour, time.Minute
+	tests := []struct {
+		name       string
+		statusCode int
+		policy     string
+		want       time.Duration
+		wantOk     bool
+	}{
+		{"success", 200, ErrorPolicySkip, ttl, true},
+		{"redirect", 301, ErrorPolicyShort, ttl, true},
+		{"cached error", 404, ErrorPolicyCache, ttl, true},
+		{"skipped error", 404, ErrorPolicySkip, 0, false},
+		{"short error", 500, ErrorPolicyShort, errorTtl, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := PageCacheTtl(tt.statusCode, tt.policy, ttl, errorTtl)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("PageCacheTtl(%d, %q) = (%v, %v), want (%v, %v)",
+					tt.statusCode, tt.policy, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestPageCachedStatus(t *testing.T) {
+	// Caches created before the status code was recorded
+	if got := (PageCached{}).Status(); got != 200 {
+		t.Errorf("Status() without status code = %d, want 200", got)
+	}
+	if got := (PageCached{StatusCode: 410}).Status(); got != 410 {
+		t.Errorf("Status() = %d, want 410", got)
+	}
+}
@@ -0,0 +1,192 @@
+package wrender
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/liuminhaw/wrenderer/internal"
+)
+
+const (
+	// CallbackSignatureHeader holds the HMAC-SHA256 of the timestamp and the body
+	// of a callback request, as sha256=<hex>.
+	CallbackSignatureHeader = "X-Wrenderer-Signature"
+	// CallbackTimestampHeader holds the unix time a callback request is signed.
+	CallbackTimestampHeader = "X-Wrenderer-Timestamp"
+)
+
+// ErrInvalidCallback is returned by Validate for an invalid job callback.
+var ErrInvalidCallback = errors.New("invalid callback")
+
+// JobCallback is the url notified with the summary of a job once the job is
+// completed, failed or timed out. The requests are signed with Secret if set.
+// The callback is persisted along with the job parameters, which are not
+// returned in responses.
+type JobCallback struct {
+	Url    string `json:"callbackUrl,omitempty"`
+	Secret string `json:"callbackSecret,omitempty"`
+}
+
+// NewJobCallback creates the callback of url signed with secret, the requests
+// are not signed if secret is empty.
+func NewJobCallback(url, secret string) JobCallback {
+	return JobCallback{Url: url, Secret: secret}
+}
+
+// Validate returns ErrInvalidCallback if Url is not an absolute http(s) url, or
+// if Secret is set without Url. An empty callback is valid and disabled.
+func (c JobCallback) Validate() error {
+	if c.Url == "" {
+		if c.Secret != "" {
+			return ErrInvalidCallback
+		}
+		return nil
+	}
+
+	callbackUrl, err := url.Parse(c.Url)
+	if err != nil || callbackUrl.Host == "" ||
+		(callbackUrl.Scheme != "http" && callbackUrl.Scheme != "https") {
+		return ErrInvalidCallback
+	}
+	return nil
+}
+
+// Enabled reports whether the job has a callback.
+func (c JobCallback) Enabled() bool {
+	return c.Url != ""
+}
+
+// JobSummary is the body of the callback request of a job.
+type JobSummary struct {
+	JobId    string `json:"jobId"`
+	Category string `json:"category"`
+	Status   string `json:"status"`
+	// Reason is the failure of a job which could not queue its entries
+	Reason   string      `json:"reason,omitempty"`
+	Progress JobProgress `json:"progress"`
+	// Failed lists the urls which failed to render
+	Failed []string  `json:"failed"`
+	Sent   time.Time `json:"sent"`
+}
+
+// CallbackNotified reports whether a job ended with status is notified.
+func CallbackNotified(status string) bool {
+	return status == internal.JobStatusCompleted ||
+		status == internal.JobStatusFailed ||
+		status == internal.JobStatusTimeout
+}
+
+// CallbackOption is the delivery policy of the job callbacks. A request is sent up
+// to MaxAttempts times, waiting Backoff before the first retry and twice as long
+// before each next one. Each request is given up after Timeout.
+type CallbackOption struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	Timeout     time.Duration
+}
+
+// CallbackError is the failure of a callback request.
+type CallbackError struct {
+	StatusCode int
+	err        error
+}
+
+func (e *CallbackError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("callback: %v", e.err)
+	}
+	return fmt.Sprintf("callback: unexpected status code %d", e.StatusCode)
+}
+
+func (e *CallbackError) Unwrap() error {
+	return e.err
+}
+
+// retryable reports whether the request may succeed if sent again, requests are
+// retried on network errors, on timeouts and rate limits, and on server errors.
+func (e *CallbackError) retryable() bool {
+	return e.StatusCode == 0 ||
+		e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode >= http.StatusInternalServerError
+}
+
+// Send posts summary as JSON to the callback url following opts, the retryable
+// failures are retried with an exponential backoff until the attempts are used
+// up or ctx is done. The error of the last attempt is returned.
+func (c JobCallback) Send(ctx context.Context, summary JobSummary, opts CallbackOption) error {
+	if summary.Failed == nil {
+		summary.Failed = []string{}
+	}
+	summary.Sent = time.Now().UTC()
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("callback: %w", err)
+	}
+
+	backoff := opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err := c.post(ctx, body, opts.Timeout)
+		var cerr *CallbackError
+		if err == nil || !errors.As(err, &cerr) || !cerr.retryable() ||
+			attempt >= opts.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends one callback request of body.
+func (c JobCallback) post(ctx context.Context, body []byte, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("callback: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(CallbackTimestampHeader, timestamp)
+		req.Header.Set(CallbackSignatureHeader, "sha256="+c.sign(timestamp, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &CallbackError{err: err}
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &CallbackError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" with the secret.
+func (c JobCallback) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(c.Secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,176 @@
+package wrender
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/liuminhaw/wrenderer/internal"
+)
+
+// callbackRequest is a request received by a test callback receiver.
+type callbackRequest struct {
+	timestamp string
+	signature string
+	body      []byte
+}
+
+// newCallbackServer returns a callback receiver answering the given status codes
+// in turn, 200 once they are used up, and the requests it received.
+func newCallbackServer(t *testing.T, codes ...int) (*httptest.Server, func() []callbackRequest) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []callbackRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, callbackRequest{
+			timestamp: r.Header.Get(CallbackTimestampHeader),
+			signature: r.Header.Get(CallbackSignatureHeader),
+			body:      body,
+		})
+		if len(requests) <= len(codes) {
+			w.WriteHeader(codes[len(requests)-1])
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []callbackRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(requests)
+	}
+}
+
+func TestJobCallbackValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		callback JobCallback
+		wantErr  bool
+	}{
+		{"disabled", JobCallback{}, false},
+		{"http", NewJobCallback("http://a.com/cb", ""), false},
+		{"https signed", NewJobCallback("https://a.com/cb", "secret"), false},
+		{"secret without url", NewJobCallback("", "secret"), true},
+		{"relative", NewJobCallback("/cb", ""), true},
+		{"other scheme", NewJobCallback("ftp://a.com/cb", ""), true},
+		{"invalid", NewJobCallback("http://a .com/%zz", ""), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.callback.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidCallback) {
+				t.Errorf("Validate() error = %v, want ErrInvalidCallback", err)
+			}
+		})
+	}
+}
+
+func TestJobCallbackSign(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp string
+		body      string
+	}{
+		{"secret", "1700000000", `{"jobId":"abcdef-ghijkl"}`},
+		{"other", "1700000000", `{"jobId":"abcdef-ghijkl"}`},
+		{"secret", "1700000001", `{"jobId":"abcdef-ghijkl"}`},
+		{"secret", "1700000000", ``},
+	}
+
+	seen := map[string]bool{}
+	for _, tt := range tests {
+		mac := hmac.New(sha256.New, []byte(tt.secret))
+		mac.Write([]byte(tt.timestamp + "." + tt.body))
+		want := hex.EncodeToString(mac.Sum(nil))
+
+		got := JobCallback{Secret: tt.secret}.sign(tt.timestamp, []byte(tt.body))
+		if got != want {
+			t.Errorf("sign(%q, %q) with %q = %s, want %s", tt.timestamp, tt.body, tt.secret, got, want)
+		}
+		if seen[got] {
+			t.Errorf("sign(%q, %q) with %q repeats a signature", tt.timestamp, tt.body, tt.secret)
+		}
+		seen[got] = true
+	}
+}
+
+func TestJobCallbackSend(t *testing.T) {
+	summary := JobSummary{
+		JobId:    "abcdef-ghijkl",
+		Category: internal.SitemapCategory,
+		Status:   internal.JobStatusCompleted,
+	}
+	opts := CallbackOption{MaxAttempts: 3}
+
+	tests := []struct {
+		name     string
+		secret   string
+		codes    []int
+		attempts int
+		wantErr  bool
+	}{
+		{"unsigned", "", nil, 1, false},
+		{"signed", "secret", nil, 1, false},
+		{"retried", "secret", []int{503, 429}, 3, false},
+		{"attempts used", "", []int{500, 502, 503}, 3, true},
+		{"client error", "", []int{400}, 1, true},
+		{"request timeout", "", []int{408}, 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newCallbackServer(t, tt.codes...)
+			callback := NewJobCallback(server.URL, tt.secret)
+
+			err := callback.Send(context.Background(), summary, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var cerr *CallbackError
+			if err != nil && !errors.As(err, &cerr) {
+				t.Errorf("Send() error = %v, want CallbackError", err)
+			}
+
+			received := requests()
+			if len(received) != tt.attempts {
+				t.Fatalf("received %d requests, want %d", len(received), tt.attempts)
+			}
+			for _, req := range received {
+				var got JobSummary
+				if err := json.Unmarshal(req.body, &got); err != nil {
+					t.Fatalf("unmarshal summary: %v", err)
+				}
+				if got.JobId != summary.JobId || got.Failed == nil || got.Sent.IsZero() {
+					t.Errorf("received summary = %+v", got)
+				}
+
+				if tt.secret == "" {
+					if req.signature != "" || req.timestamp != "" {
+						t.Errorf("unsigned request has signature headers")
+					}
+					continue
+				}
+				want := "sha256=" + JobCallback{Secret: tt.secret}.sign(req.timestamp, req.body)
+				if req.timestamp == "" || req.signature != want {
+					t.Errorf("signature = %q, want %q", req.signature, want)
+				}
+			}
+		})
+	}
+}
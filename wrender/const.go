@@ -1,6 +1,8 @@
 package wrender
 
 const (
-	HtmlContentType  = "text/html"
-	PlainContentType = "text/plain"
+	HtmlContentType     = "text/html"
+	PlainContentType    = "text/plain"
+	MarkdownContentType = "text/markdown"
+	MhtmlContentType    = "multipart/related"
 )
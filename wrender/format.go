@@ -0,0 +1,95 @@
+package wrender
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/liuminhaw/wrenderer/archive"
+	"github.com/liuminhaw/wrenderer/readable"
+)
+
+// Output formats of the rendered page, text and markdown formats are converted
+// from the rendered html, mhtml and singlefile formats are archive snapshots
+// captured while rendering. Each format is cached separately.
+const (
+	FormatHtml       = "html"
+	FormatText       = "text"
+	FormatMarkdown   = "markdown"
+	FormatMhtml      = "mhtml"
+	FormatSingleFile = "singlefile"
+)
+
+var (
+	// Formats is the list of supported output formats of the rendered page.
+	Formats = []string{FormatHtml, FormatText, FormatMarkdown, FormatMhtml, FormatSingleFile}
+	// ConvertedFormats is the list of output formats converted from the rendered
+	// html, which are outdated once the page is rendered again.
+	ConvertedFormats = []string{FormatText, FormatMarkdown}
+)
+
+// SupportedFormat reports whether the given format is a supported output format.
+// An empty format is treated as html.
+func SupportedFormat(format string) bool {
+	return format == "" || slices.Contains(Formats, format)
+}
+
+// IsArchiveFormat reports whether the given format is an archive snapshot of the
+// page, which is captured while rendering instead of converted from the html.
+func IsArchiveFormat(format string) bool {
+	return format == FormatMhtml || format == FormatSingleFile
+}
+
+// FormatKey returns the cache key of the given format derived from the html page
+// cache key, the html key is returned as is for html format.
+func FormatKey(key, format string) string {
+	if format == "" || format == FormatHtml {
+		return key
+	}
+	return fmt.Sprintf("%s.%s", key, format)
+}
+
+// FormatContentType returns the content type of the given output format.
+func FormatContentType(format string) string {
+	switch format {
+	case FormatText:
+		return PlainContentType
+	case FormatMarkdown:
+		return MarkdownContentType
+	case FormatMhtml:
+		return MhtmlContentType
+	default:
+		return HtmlContentType
+	}
+}
+
+// ConvertFormat converts the rendered html content of pageUrl into the given
+// output format. The content is returned untouched for html format.
+func ConvertFormat(content []byte, pageUrl, format string) ([]byte, error) {
+	switch format {
+	case "", FormatHtml:
+		return content, nil
+	case FormatText:
+		return readable.ToText(content, pageUrl)
+	case FormatMarkdown:
+		return readable.ToMarkdown(content, pageUrl)
+	default:
+		return nil, fmt.Errorf("convert format: unsupported format %s", format)
+	}
+}
+
+// ArchiveContent returns the archive of the given format from the MHTML snapshot
+// captured while rendering.
+func ArchiveContent(snapshot []byte, format string) ([]byte, error) {
+	if len(snapshot) == 0 {
+		return nil, fmt.Errorf("archive content: empty snapshot")
+	}
+
+	switch format {
+	case FormatMhtml:
+		return snapshot, nil
+	case FormatSingleFile:
+		return archive.SingleFile(snapshot)
+	default:
+		return nil, fmt.Errorf("archive content: unsupported format %s", format)
+	}
+}
@@ -0,0 +1,46 @@
+package wrender
+
+import "testing"
+
+func TestConvertFormat(t *testing.T) {
+	content := []byte(`<html><body><main><h1>Title</h1></main></body></html>`)
+
+	for _, format := range []string{"", FormatHtml} {
+		got, err := ConvertFormat(content, "https://a.com/", format)
+		if err != nil || string(got) != string(content) {
+			t.Errorf("ConvertFormat(%q) = (%s, %v), want the html untouched", format, got, err)
+		}
+	}
+	if got, err := ConvertFormat(content, "https://a.com/", FormatMarkdown); err != nil || string(got) != "# Title\n" {
+		t.Errorf("ConvertFormat(markdown) = (%q, %v), want %q", got, err, "# Title\n")
+	}
+	if got, err := ConvertFormat(content, "https://a.com/", FormatText); err != nil || string(got) != "Title\n" {
+		t.Errorf("ConvertFormat(text) = (%q, %v), want %q", got, err, "Title\n")
+	}
+	if _, err := ConvertFormat(content, "https://a.com/", FormatMhtml); err == nil {
+		t.Error("ConvertFormat(mhtml) succeeded, archives are not converted")
+	}
+
+	if got := FormatKey("abc", FormatMarkdown); got != "abc.markdown" {
+		t.Errorf("FormatKey(markdown) = %s, want abc.markdown", got)
+	}
+	if got := FormatKey("abc", FormatHtml); got != "abc" {
+		t.Errorf("FormatKey(html) = %s, want abc", got)
+	}
+}
+
+func TestArchiveContent(t *testing.T) {
+	if _, err := ArchiveContent(nil, FormatMhtml); err == nil {
+		t.Error("ArchiveContent() of an empty snapshot succeeded")
+	}
+	snapshot := []byte("MIME-Version: 1.0\r\n")
+	if got, err := ArchiveContent(snapshot, FormatMhtml); err != nil || string(got) != string(snapshot) {
+		t.Errorf("ArchiveContent(mhtml) = (%q, %v), want the snapshot", got, err)
+	}
+	if _, err := ArchiveContent(snapshot, FormatMarkdown); err == nil {
+		t.Error("ArchiveContent(markdown) succeeded, markdown is not an archive")
+	}
+	if !IsArchiveFormat(FormatSingleFile) || IsArchiveFormat(FormatText) {
+		t.Error("IsArchiveFormat() misclassifies the archive formats")
+	}
+}
@@ -0,0 +1,92 @@
+package wrender
+
+import (
+	"time"
+
+	"github.com/liuminhaw/wrenderer/internal"
+)
+
+// JobProgress is the progress of a job from the number of its entries in each
+// state.
+type JobProgress struct {
+	Total      int        `json:"total"`
+	Queued     int        `json:"queued"`
+	Processing int        `json:"processing"`
+	Succeeded  int        `json:"succeeded"`
+	Failed     int        `json:"failed"`
+	Skipped    int        `json:"skipped"`
+	Started    time.Time  `json:"started"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	Eta        *time.Time `json:"eta,omitempty"`
+}
+
+// EstimateEta sets Eta from the pace of the entries done since Started, Eta is
+// left unset until an entry is done or once no entry is left. Skipped entries are
+// not rendered and do not count toward the pace.
+func (p *JobProgress) EstimateEta(now time.Time) {
+	done := p.Succeeded + p.Failed
+	left := p.Queued + p.Processing
+	if done == 0 || left == 0 || p.Finished != nil {
+		p.Eta = nil
+		return
+	}
+
+	elapsed := now.Sub(p.Started)
+	eta := now.Add(elapsed * time.Duration(left) / time.Duration(done)).UTC()
+	p.Eta = &eta
+}
+
+// JobEntryResult is the state of an entry of a job, with the render duration and
+// the failure reason once the entry is done. Depth is the number of links
+// followed from a seed url to the entry of a crawl job. Attempts is the history
+// of the renders of the entry, the failed renders are retried following the
+// retry policy of the job.
+type JobEntryResult struct {
+	TargetUrl              string            `json:"targetUrl"`
+	Status                 string            `json:"status"`
+	StatusCode             int               `json:"statusCode,omitempty"`
+	Depth                  int               `json:"depth,omitempty"`
+	Reason                 string            `json:"reason,omitempty"`
+	DurationInMilliseconds int64             `json:"durationInMilliseconds,omitempty"`
+	Finished               *time.Time        `json:"finished,omitempty"`
+	Attempts               []JobEntryAttempt `json:"attempts,omitempty"`
+}
+
+// NewJobEntryResult creates the result of an entry of targetUrl done with the
+// given status, the reason is taken from err if not nil.
+func NewJobEntryResult(
+	targetUrl, status string,
+	duration time.Duration,
+	err error,
+) JobEntryResult {
+	finished := time.Now().UTC()
+	result := JobEntryResult{
+		TargetUrl:              targetUrl,
+		Status:                 status,
+		DurationInMilliseconds: duration.Milliseconds(),
+		Finished:               &finished,
+	}
+	if err != nil {
+		result.Reason = err.Error()
+	}
+
+	return result
+}
+
+// JobResults is a page of the entry results of a job.
+type JobResults struct {
+	Page     int              `json:"page"`
+	PageSize int              `json:"pageSize"`
+	Total    int              `json:"total"`
+	Entries  []JobEntryResult `json:"entries"`
+}
+
+// JobEntryStates are the states of the entries of a job, in the order they are
+// listed.
+var JobEntryStates = []string{
+	internal.JobStatusSucceeded,
+	internal.JobStatusFailed,
+	internal.JobStatusSkipped,
+	internal.JobStatusProcessing,
+	internal.JobStatusQueued,
+}
@@ -0,0 +1,40 @@
+package wrender
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobProgressEstimateEta(t *testing.T) {
+	started := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	now := started.Add(10 * time.Minute)
+	finished := now
+
+	tests := []struct {
+		name     string
+		progress JobProgress
+		want     time.Duration
+		wantEta  bool
+	}{
+		{"none done", JobProgress{Queued: 4}, 0, false},
+		{"half done", JobProgress{Succeeded: 2, Queued: 1, Processing: 1}, 10 * time.Minute, true},
+		{"failures count", JobProgress{Succeeded: 1, Failed: 3, Queued: 2}, 5 * time.Minute, true},
+		{"skipped not counted", JobProgress{Succeeded: 1, Skipped: 9, Queued: 2}, 20 * time.Minute, true},
+		{"none left", JobProgress{Succeeded: 4}, 0, false},
+		{"finished", JobProgress{Succeeded: 2, Queued: 2, Finished: &finished}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			progress := tt.progress
+			progress.Started = started
+			progress.EstimateEta(now)
+			if (progress.Eta != nil) != tt.wantEta {
+				t.Fatalf("EstimateEta() eta = %v, want set %v", progress.Eta, tt.wantEta)
+			}
+			if tt.wantEta && !progress.Eta.Equal(now.Add(tt.want)) {
+				t.Errorf("EstimateEta() eta = %v, want %v", progress.Eta, now.Add(tt.want))
+			}
+		})
+	}
+}
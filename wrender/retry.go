@@ -0,0 +1,111 @@
+package wrender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RenderStatusError reports a page rendered with an error status code.
+type RenderStatusError struct {
+	StatusCode int
+}
+
+func (e *RenderStatusError) Error() string {
+	return fmt.Sprintf("page rendered with status code %d", e.StatusCode)
+}
+
+// RenderStatusErr returns a RenderStatusError for a page rendered with a
+// retryable error status code, 408, 429 or 5xx, nil otherwise. The other client
+// error pages are rendered as the site answers them, following the cache error
+// policy, and are not render failures.
+func RenderStatusErr(statusCode int) error {
+	if !retryableStatus(statusCode) {
+		return nil
+	}
+	return &RenderStatusError{StatusCode: statusCode}
+}
+
+// retryableStatus reports whether a page rendered with statusCode may be rendered
+// with another status code later.
+func retryableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= http.StatusInternalServerError
+}
+
+// RetryableRenderError reports whether a failed render may succeed if rendered
+// again. Pages rendered with a client error status code are permanent failures,
+// except for 408 and 429. Server error status codes and the errors without status
+// code, such as timeouts, network or browser errors, are retryable. A cancelled
+// render is not retried.
+func RetryableRenderError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var serr *RenderStatusError
+	if errors.As(err, &serr) {
+		return retryableStatus(serr.StatusCode)
+	}
+	return true
+}
+
+// RetryPolicy is the retry policy of the failed renders of a job. An entry is
+// rendered up to MaxAttempts times, waiting Backoff before the first retry and
+// twice as long before each next one, up to MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Retry reports whether an entry failed with err after the given number of
+// attempts is rendered again.
+func (p RetryPolicy) Retry(attempts int, err error) bool {
+	return attempts < p.MaxAttempts && RetryableRenderError(err)
+}
+
+// Delay returns the backoff before the next attempt of an entry after the given
+// number of attempts.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.Backoff
+	for range max(attempts-1, 0) {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 {
+		delay = min(delay, p.MaxBackoff)
+	}
+	return delay
+}
+
+// JobEntryAttempt is an attempt to render an entry of a job, with the status code
+// of the rendered page and the failure reason if the attempt failed.
+type JobEntryAttempt struct {
+	Started                time.Time `json:"started"`
+	DurationInMilliseconds int64     `json:"durationInMilliseconds"`
+	StatusCode             int       `json:"statusCode,omitempty"`
+	Reason                 string    `json:"reason,omitempty"`
+	// Retryable tells whether the failure of the attempt is retryable
+	Retryable bool `json:"retryable,omitempty"`
+}
+
+// NewJobEntryAttempt records an attempt started at started, ended with the page
+// status code and err.
+func NewJobEntryAttempt(started time.Time, statusCode int, err error) JobEntryAttempt {
+	attempt := JobEntryAttempt{
+		Started:                started.UTC(),
+		DurationInMilliseconds: time.Since(started).Milliseconds(),
+		StatusCode:             statusCode,
+	}
+	if err != nil {
+		attempt.Reason = err.Error()
+		attempt.Retryable = RetryableRenderError(err)
+	}
+	return attempt
+}
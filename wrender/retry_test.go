@@ -0,0 +1,109 @@
+package wrender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryableRenderError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"not found", &RenderStatusError{StatusCode: 404}, false},
+		{"bad request", &RenderStatusError{StatusCode: 400}, false},
+		{"forbidden", &RenderStatusError{StatusCode: 403}, false},
+		{"request timeout", RenderStatusErr(408), true},
+		{"too many requests", RenderStatusErr(429), true},
+		{"server error", RenderStatusErr(500), true},
+		{"bad gateway", RenderStatusErr(502), true},
+		{"wrapped status", fmt.Errorf("render: %w", &RenderStatusError{StatusCode: 410}), false},
+		{"timeout", context.DeadlineExceeded, true},
+		{"browser error", errors.New("browser crashed"), true},
+		{"cancelled", context.Canceled, false},
+		{"wrapped cancel", fmt.Errorf("render: %w", context.Canceled), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RetryableRenderError(tt.err); got != tt.want {
+				t.Errorf("RetryableRenderError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderStatusErr(t *testing.T) {
+	// Client error pages other than 408 and 429 are rendered pages, not failures
+	for _, code := range []int{200, 301, 399, 400, 404, 410} {
+		if err := RenderStatusErr(code); err != nil {
+			t.Errorf("RenderStatusErr(%d) = %v, want nil", code, err)
+		}
+	}
+	for _, code := range []int{408, 429, 500, 503} {
+		var serr *RenderStatusError
+		if err := RenderStatusErr(code); !errors.As(err, &serr) || serr.StatusCode != code {
+			t.Errorf("RenderStatusErr(%d) = %v, want RenderStatusError %d", code, err, code)
+		}
+	}
+}
+
+func TestRetryPolicyRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Second}
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		want     bool
+	}{
+		{"first failure", 1, RenderStatusErr(503), true},
+		{"last retry", 2, errors.New("timeout"), true},
+		{"attempts used", 3, RenderStatusErr(503), false},
+		{"permanent failure", 1, &RenderStatusError{StatusCode: 404}, false},
+		{"cancelled", 1, context.Canceled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Retry(tt.attempts, tt.err); got != tt.want {
+				t.Errorf("Retry(%d, %v) = %v, want %v", tt.attempts, tt.err, got, tt.want)
+			}
+		})
+	}
+
+	if (RetryPolicy{MaxAttempts: 1}).Retry(1, errors.New("timeout")) {
+		t.Error("Retry() with a single attempt = true, want false")
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempts int
+		want     time.Duration
+	}{
+		{"first retry", RetryPolicy{Backoff: time.Second}, 1, time.Second},
+		{"no attempt", RetryPolicy{Backoff: time.Second}, 0, time.Second},
+		{"doubled", RetryPolicy{Backoff: time.Second}, 2, 2 * time.Second},
+		{"doubled twice", RetryPolicy{Backoff: time.Second}, 3, 4 * time.Second},
+		{"uncapped", RetryPolicy{Backoff: time.Second}, 11, 1024 * time.Second},
+		{"under cap", RetryPolicy{Backoff: time.Second, MaxBackoff: 10 * time.Second}, 4, 8 * time.Second},
+		{"capped", RetryPolicy{Backoff: time.Second, MaxBackoff: 10 * time.Second}, 5, 10 * time.Second},
+		{"capped long after", RetryPolicy{Backoff: time.Second, MaxBackoff: 10 * time.Second}, 1000, 10 * time.Second},
+		{"backoff over cap", RetryPolicy{Backoff: time.Minute, MaxBackoff: 10 * time.Second}, 1, 10 * time.Second},
+		{"no backoff", RetryPolicy{}, 3, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Delay(tt.attempts); got != tt.want {
+				t.Errorf("Delay(%d) = %v, want %v", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
@@ -18,9 +18,13 @@ type S3CachingMeta struct {
 	Bucket      string
 	Region      string
 	ContentType string
+	Metadata    map[string]string
 }
 
+// S3Caching is a cache stored in S3 bucket. The S3 requests are bound to Context,
+// context.Background() is used if it is not set.
 type S3Caching struct {
+	Context      context.Context
 	Client       *s3.Client
 	Meta         S3CachingMeta
 	CachedPrefix string
@@ -41,6 +45,19 @@ func NewS3Caching(
 	}
 }
 
+// WithContext returns a copy of the caching with its S3 requests bound to ctx.
+func (c S3Caching) WithContext(ctx context.Context) S3Caching {
+	c.Context = ctx
+	return c
+}
+
+func (c S3Caching) ctx() context.Context {
+	if c.Context == nil {
+		return context.Background()
+	}
+	return c.Context
+}
+
 func (c S3Caching) Update(reader io.Reader) error {
 	return c.putObject(c.CachedPath, reader)
 }
@@ -50,18 +67,69 @@ func (c S3Caching) UpdateTo(reader io.Reader, suffixPath string) error {
 	return c.putObject(key, reader)
 }
 
+// CreateTo writes the object of suffixPath under CachedPrefix only if it does not
+// exist yet, false is returned if the object exists.
+func (c S3Caching) CreateTo(reader io.Reader, suffixPath string) (bool, error) {
+	_, err := c.Client.PutObject(c.ctx(), &s3.PutObjectInput{
+		Bucket:      aws.String(c.Meta.Bucket),
+		Key:         aws.String(filepath.Join(c.CachedPrefix, suffixPath)),
+		Body:        reader,
+		ContentType: aws.String(c.Meta.ContentType),
+		Metadata:    c.Meta.Metadata,
+		IfNoneMatch: aws.String("*"),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "PreconditionFailed", "ConditionalRequestConflict":
+				return false, nil
+			}
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ReplaceTo uploads the object at the suffix path under the caching prefix only if
+// its entity tag is still etag, false is returned if the object was changed.
+func (c S3Caching) ReplaceTo(reader io.Reader, suffixPath string, etag string) (bool, error) {
+	_, err := c.Client.PutObject(c.ctx(), &s3.PutObjectInput{
+		Bucket:      aws.String(c.Meta.Bucket),
+		Key:         aws.String(filepath.Join(c.CachedPrefix, suffixPath)),
+		Body:        reader,
+		ContentType: aws.String(c.Meta.ContentType),
+		Metadata:    c.Meta.Metadata,
+		IfMatch:     aws.String(etag),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "PreconditionFailed", "ConditionalRequestConflict":
+				return false, nil
+			}
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (c S3Caching) putObject(key string, reader io.Reader) error {
-	_, err := c.Client.PutObject(context.Background(), &s3.PutObjectInput{
+	_, err := c.Client.PutObject(c.ctx(), &s3.PutObjectInput{
 		Bucket:      aws.String(c.Meta.Bucket),
 		Key:         aws.String(key),
 		Body:        reader,
 		ContentType: aws.String(c.Meta.ContentType),
+		Metadata:    c.Meta.Metadata,
 	})
 	return err
 }
 
 func (c S3Caching) Delete() error {
-	_, err := c.Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+	_, err := c.Client.DeleteObject(c.ctx(), &s3.DeleteObjectInput{
 		Bucket: aws.String(c.Meta.Bucket),
 		Key:    aws.String(c.CachedPath),
 	})
@@ -85,7 +153,7 @@ func (c S3Caching) DeletePrefix() error {
 	}
 
 	for {
-		result, err := c.Client.ListObjectsV2(context.Background(), input)
+		result, err := c.Client.ListObjectsV2(c.ctx(), input)
 		if err != nil {
 			return err
 		}
@@ -104,7 +172,7 @@ func (c S3Caching) DeletePrefix() error {
 		}
 
 		// Perform the delete operation
-		_, err = c.Client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+		_, err = c.Client.DeleteObjects(c.ctx(), &s3.DeleteObjectsInput{
 			Bucket: aws.String(c.Meta.Bucket),
 			Delete: &types.Delete{
 				Objects: objectsToDelete,
@@ -129,7 +197,7 @@ func (c S3Caching) DeletePrefix() error {
 // Read method reads the object from S3 bucket and returns its content if exists.
 // If the object does not exist or is empty, a CacheNotFoundError will be returned.
 func (c S3Caching) Read() (CacheContent, error) {
-	obj, err := c.Client.GetObject(context.Background(), &s3.GetObjectInput{
+	obj, err := c.Client.GetObject(c.ctx(), &s3.GetObjectInput{
 		Bucket: aws.String(c.Meta.Bucket),
 		Key:    aws.String(c.CachedPath),
 	})
@@ -160,9 +228,35 @@ func (c S3Caching) Read() (CacheContent, error) {
 	return CacheContent(content), nil
 }
 
+// ReadTag reads the object like Read, along with its entity tag for ReplaceTo.
+func (c S3Caching) ReadTag() (CacheContent, string, error) {
+	obj, err := c.Client.GetObject(c.ctx(), &s3.GetObjectInput{
+		Bucket: aws.String(c.Meta.Bucket),
+		Key:    aws.String(c.CachedPath),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey" {
+			return nil, "", &CacheNotFoundError{err}
+		}
+		return nil, "", err
+	}
+	defer obj.Body.Close()
+
+	content, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(content) == 0 {
+		return nil, "", &CacheNotFoundError{fmt.Errorf("empty cache content")}
+	}
+
+	return CacheContent(content), aws.StringValue(obj.ETag), nil
+}
+
 // Exists checks if S3Caching data exists.
 func (c S3Caching) Exists() (bool, error) {
-	objStats, err := c.Client.HeadObject(context.Background(), &s3.HeadObjectInput{
+	objStats, err := c.Client.HeadObject(c.ctx(), &s3.HeadObjectInput{
 		Bucket: aws.String(c.Meta.Bucket),
 		Key:    aws.String(c.CachedPath),
 	})
@@ -189,6 +283,40 @@ func (c S3Caching) Exists() (bool, error) {
 	return true, nil
 }
 
+// Metadata returns the user-defined metadata of the S3Caching object. If the object
+// does not exist or is empty, a CacheNotFoundError will be returned.
+func (c S3Caching) Metadata() (map[string]string, error) {
+	_, metadata, err := c.ObjectInfo()
+	return metadata, err
+}
+
+// ObjectInfo returns the last modified time and the user-defined metadata of the
+// S3Caching object. If the object does not exist or is empty, a
+// CacheNotFoundError will be returned.
+func (c S3Caching) ObjectInfo() (CacheObjectInfo, map[string]string, error) {
+	objStats, err := c.Client.HeadObject(c.ctx(), &s3.HeadObjectInput{
+		Bucket: aws.String(c.Meta.Bucket),
+		Key:    aws.String(c.CachedPath),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if ok := errors.As(err, &apiErr); ok && apiErr.ErrorCode() == "NotFound" {
+			return CacheObjectInfo{}, nil, &CacheNotFoundError{err}
+		}
+		return CacheObjectInfo{}, nil, err
+	}
+
+	if *objStats.ContentLength == 0 {
+		return CacheObjectInfo{}, nil, &CacheNotFoundError{fmt.Errorf("empty cache content")}
+	}
+
+	info := CacheObjectInfo{Path: c.CachedPath}
+	if objStats.LastModified != nil {
+		info.Modified = *objStats.LastModified
+	}
+	return info, objStats.Metadata, nil
+}
+
 // IsEmptyPrefix checks if the S3 bucket is empty under certain prefix path.
 // If suffixPath is empty, it checks if the CachedPrefix is empty.
 // If suffixPath is not empty, it checks if the CachedPrefix/{suffixPath} is empty.
@@ -200,7 +328,7 @@ func (c S3Caching) IsEmptyPrefix(suffixPath string) (bool, error) {
 		path = filepath.Join(c.CachedPrefix, suffixPath)
 	}
 
-	result, err := c.Client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+	result, err := c.Client.ListObjectsV2(c.ctx(), &s3.ListObjectsV2Input{
 		Bucket:  aws.String(c.Meta.Bucket),
 		Prefix:  aws.String(path),
 		MaxKeys: aws.Int32(1),
@@ -232,13 +360,13 @@ func (c S3Caching) List(suffixPath string) ([]CacheContentInfo, error) {
 
 	var contents []CacheContentInfo
 	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(context.Background())
+		page, err := paginator.NextPage(c.ctx())
 		if err != nil {
 			return nil, err
 		}
 
 		for _, content := range page.Contents {
-			obj, err := c.Client.GetObject(context.Background(), &s3.GetObjectInput{
+			obj, err := c.Client.GetObject(c.ctx(), &s3.GetObjectInput{
 				Bucket: aws.String(c.Meta.Bucket),
 				Key:    content.Key,
 			})
@@ -261,3 +389,37 @@ func (c S3Caching) List(suffixPath string) ([]CacheContentInfo, error) {
 
 	return contents, nil
 }
+
+// ListObjects lists the path and last modified time of the objects under the
+// CachedPrefix/{suffixPath} prefix without reading their content.
+func (c S3Caching) ListObjects(suffixPath string) ([]CacheObjectInfo, error) {
+	var path string
+	if suffixPath == "" {
+		path = c.CachedPrefix
+	} else {
+		path = filepath.Join(c.CachedPrefix, suffixPath)
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(c.Client, &s3.ListObjectsV2Input{
+		Bucket:     aws.String(c.Meta.Bucket),
+		Prefix:     aws.String(path),
+		StartAfter: aws.String(path),
+	})
+
+	var objects []CacheObjectInfo
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(c.ctx())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range page.Contents {
+			objects = append(objects, CacheObjectInfo{
+				Path:     *content.Key,
+				Modified: *content.LastModified,
+			})
+		}
+	}
+
+	return objects, nil
+}